@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	toolresults "google.golang.org/api/toolresults/v1beta3"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	accessibilityReportFormatJSON  = "json"
+	accessibilityReportFormatSARIF = "sarif"
+
+	accessibilityReportEnvID = "VDTESTING_ACCESSIBILITY_REPORT_PATH"
+)
+
+const (
+	accessibilitySeverityError   = "error"
+	accessibilitySeverityWarning = "warning"
+	accessibilitySeverityInfo    = "info"
+)
+
+var htmlTagRegexp = regexp.MustCompile(`<[^>]*>`)
+
+// AccessibilityFinding is an accessibility issue found by a Robo crawl
+type AccessibilityFinding struct {
+	Category     string                   `json:"category"`
+	Title        string                   `json:"title"`
+	Message      string                   `json:"message"`
+	ResourceName string                   `json:"resource_name,omitempty"`
+	ScreenID     string                   `json:"screen_id,omitempty"`
+	Region       *toolresults.RegionProto `json:"region,omitempty"`
+	HelpURL      string                   `json:"help_url,omitempty"`
+}
+
+// DeviceAccessibilityReport contains the accessibility findings of a device dimension grouped by severity
+type DeviceAccessibilityReport struct {
+	Dimension  string                            `json:"dimension"`
+	BySeverity map[string][]AccessibilityFinding `json:"by_severity"`
+}
+
+// AccessibilityReport is the structured result of the accessibility checks of the Robo crawls
+type AccessibilityReport struct {
+	Devices []DeviceAccessibilityReport `json:"devices"`
+}
+
+func accessibilitySeverity(priority string) string {
+	switch priority {
+	case "error":
+		return accessibilitySeverityError
+	case "warning":
+		return accessibilitySeverityWarning
+	default:
+		return accessibilitySeverityInfo
+	}
+}
+
+func safeHTMLText(safeHTML *toolresults.SafeHtmlProto) string {
+	if safeHTML == nil {
+		return ""
+	}
+	text := htmlTagRegexp.ReplaceAllString(safeHTML.PrivateDoNotAccessOrElseSafeHtmlWrappedValue, "")
+	return strings.TrimSpace(html.UnescapeString(text))
+}
+
+// addClusters merges the accessibility clusters of a step into the report, steps of the same device dimension
+// (flaky test attempts) are merged into the same device report. A finding reported again by another attempt
+// (same rule and resource) is kept only once.
+func (r *AccessibilityReport) addClusters(dimension string, clusters []*toolresults.SuggestionClusterProto) {
+	var device *DeviceAccessibilityReport
+	for i := range r.Devices {
+		if r.Devices[i].Dimension == dimension {
+			device = &r.Devices[i]
+		}
+	}
+	if device == nil {
+		r.Devices = append(r.Devices, DeviceAccessibilityReport{Dimension: dimension, BySeverity: map[string][]AccessibilityFinding{}})
+		device = &r.Devices[len(r.Devices)-1]
+	}
+
+	for _, cluster := range clusters {
+		if cluster == nil {
+			continue
+		}
+		for _, suggestion := range cluster.Suggestions {
+			if suggestion == nil {
+				continue
+			}
+
+			message := safeHTMLText(suggestion.ShortMessage)
+			if message == "" {
+				message = safeHTMLText(suggestion.LongMessage)
+			}
+
+			severity := accessibilitySeverity(suggestion.Priority)
+			finding := AccessibilityFinding{
+				Category:     cluster.Category,
+				Title:        suggestion.Title,
+				Message:      message,
+				ResourceName: suggestion.ResourceName,
+				ScreenID:     suggestion.ScreenId,
+				Region:       suggestion.Region,
+				HelpURL:      suggestion.HelpUrl,
+			}
+			if !containsFinding(device.BySeverity[severity], finding) {
+				device.BySeverity[severity] = append(device.BySeverity[severity], finding)
+			}
+		}
+	}
+}
+
+// containsFinding reports whether findings has a finding of the same rule on the same resource,
+// findings without a resource are told apart by their message.
+func containsFinding(findings []AccessibilityFinding, finding AccessibilityFinding) bool {
+	for _, f := range findings {
+		if f.Category == finding.Category && f.Title == finding.Title && f.ResourceName == finding.ResourceName &&
+			(finding.ResourceName != "" || f.Message == finding.Message) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r AccessibilityReport) errorCount() int {
+	count := 0
+	for _, device := range r.Devices {
+		count += len(device.BySeverity[accessibilitySeverityError])
+	}
+	return count
+}
+
+// collectAccessibilityFindings collects the accessibility findings of the robo test runs' steps.
+func collectAccessibilityFindings(backend testBackend, runs []testRun, runSteps map[string][]*toolresults.Step) (AccessibilityReport, error) {
+	var report AccessibilityReport
+	for _, run := range runs {
+		if run.testType != testTypeRobo {
+			continue
+		}
+		for _, step := range runSteps[run.name] {
+			if step.StepId == "" {
+				continue
+			}
+
+			clusters, err := backend.GetAccessibilityClusters(run, step.StepId)
+			if err != nil {
+				return AccessibilityReport{}, fmt.Errorf("step (%s): %w", step.StepId, err)
+			}
+			report.addClusters(runStepDimensionID(run, step), clusters.Clusters)
+		}
+	}
+
+	sort.Slice(report.Devices, func(i, j int) bool {
+		return report.Devices[i].Dimension < report.Devices[j].Dimension
+	})
+
+	return report, nil
+}
+
+func printAccessibilityReport(report AccessibilityReport) {
+	if len(report.Devices) == 0 {
+		log.Printf("No accessibility findings")
+		return
+	}
+
+	for _, device := range report.Devices {
+		log.Printf("%s: %d error(s), %d warning(s), %d info(s)", device.Dimension,
+			len(device.BySeverity[accessibilitySeverityError]),
+			len(device.BySeverity[accessibilitySeverityWarning]),
+			len(device.BySeverity[accessibilitySeverityInfo]))
+
+		for _, finding := range device.BySeverity[accessibilitySeverityError] {
+			log.Warnf("- [%s] %s: %s (%s)", finding.Category, finding.Title, finding.Message, finding.ResourceName)
+		}
+	}
+}
+
+func writeAccessibilityReport(report AccessibilityReport, format, pth string) error {
+	var v interface{} = report
+	if format == accessibilityReportFormatSARIF {
+		v = newSARIFLog(report)
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal accessibility report: %w", err)
+	}
+	if err := os.WriteFile(pth, data, 0644); err != nil {
+		return fmt.Errorf("failed to write accessibility report: %w", err)
+	}
+	return nil
+}
+
+func accessibilityReportFileName(format string) string {
+	if format == accessibilityReportFormatSARIF {
+		return "accessibility_report.sarif"
+	}
+	return "accessibility_report.json"
+}
+
+// SARIF 2.1.0: https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	Name             string       `json:"name"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+	HelpURI          string       `json:"helpUri,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID     string            `json:"ruleId"`
+	Level      string            `json:"level"`
+	Message    sarifMessage      `json:"message"`
+	Locations  []sarifLocation   `json:"locations,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type sarifLocation struct {
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifLogicalLocation struct {
+	Name               string `json:"name"`
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+func sarifLevel(severity string) string {
+	switch severity {
+	case accessibilitySeverityError:
+		return "error"
+	case accessibilitySeverityWarning:
+		return "warning"
+	default:
+		return "note"
+	}
+}
+
+func newSARIFLog(report AccessibilityReport) sarifLog {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "Firebase Test Lab accessibility scanner",
+			InformationURI: "https://firebase.google.com/docs/test-lab/android/accessibility",
+		}},
+		Results: []sarifResult{},
+	}
+
+	rules := map[string]bool{}
+	for _, device := range report.Devices {
+		for _, severity := range []string{accessibilitySeverityError, accessibilitySeverityWarning, accessibilitySeverityInfo} {
+			for _, finding := range device.BySeverity[severity] {
+				ruleID := finding.Category
+				if finding.Title != "" {
+					ruleID += "/" + finding.Title
+				}
+				if !rules[ruleID] {
+					rules[ruleID] = true
+					run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+						ID:               ruleID,
+						Name:             finding.Title,
+						ShortDescription: sarifMessage{Text: finding.Title},
+						HelpURI:          finding.HelpURL,
+					})
+				}
+
+				result := sarifResult{
+					RuleID:  ruleID,
+					Level:   sarifLevel(severity),
+					Message: sarifMessage{Text: finding.Message},
+					Properties: map[string]string{
+						"device":   device.Dimension,
+						"screenId": finding.ScreenID,
+					},
+				}
+				if finding.ResourceName != "" {
+					result.Locations = []sarifLocation{{LogicalLocations: []sarifLogicalLocation{{
+						Name:               finding.ResourceName,
+						FullyQualifiedName: device.Dimension + "/" + finding.ResourceName,
+						Kind:               "element",
+					}}}}
+				}
+				run.Results = append(run.Results, result)
+			}
+		}
+	}
+
+	return sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	toolresults "google.golang.org/api/toolresults/v1beta3"
+)
+
+func TestAccessibilityReport_AddClusters(t *testing.T) {
+	clusters := []*toolresults.SuggestionClusterProto{
+		{
+			Category: "touchTargetSize",
+			Suggestions: []*toolresults.SuggestionProto{
+				{
+					Priority:     "error",
+					Title:        "Touch target size",
+					ShortMessage: &toolresults.SafeHtmlProto{PrivateDoNotAccessOrElseSafeHtmlWrappedValue: "<b>Consider</b> making this clickable item larger &amp; easier to tap."},
+					ResourceName: "com.example.app:id/login",
+				},
+				{Priority: "warning", Title: "Touch target size"},
+			},
+		},
+		{
+			Category: "lowContrast",
+			Suggestions: []*toolresults.SuggestionProto{
+				{Priority: "info", Title: "Low contrast"},
+			},
+		},
+	}
+
+	// a flaky test attempt reports the same findings again, and a new one on another resource
+	retryClusters := []*toolresults.SuggestionClusterProto{
+		clusters[0],
+		{
+			Category: "touchTargetSize",
+			Suggestions: []*toolresults.SuggestionProto{
+				{Priority: "error", Title: "Touch target size", ResourceName: "com.example.app:id/signup"},
+			},
+		},
+	}
+
+	var report AccessibilityReport
+	report.addClusters("MediumPhone.arm-33-en-portrait", clusters)
+	report.addClusters("MediumPhone.arm-33-en-portrait", retryClusters)
+
+	if len(report.Devices) != 1 {
+		t.Fatalf("len(report.Devices) = %d, want 1", len(report.Devices))
+	}
+	device := report.Devices[0]
+	if got := len(device.BySeverity[accessibilitySeverityError]); got != 2 {
+		t.Errorf("errors = %d, want 2", got)
+	}
+	if got := len(device.BySeverity[accessibilitySeverityWarning]); got != 1 {
+		t.Errorf("warnings = %d, want 1", got)
+	}
+	if got := len(device.BySeverity[accessibilitySeverityInfo]); got != 1 {
+		t.Errorf("infos = %d, want 1", got)
+	}
+	if got, want := device.BySeverity[accessibilitySeverityError][0].Message, "Consider making this clickable item larger & easier to tap."; got != want {
+		t.Errorf("message = %q, want %q", got, want)
+	}
+	if got := report.errorCount(); got != 2 {
+		t.Errorf("errorCount() = %d, want 2", got)
+	}
+
+	sarif := newSARIFLog(report)
+	if got := len(sarif.Runs[0].Results); got != 4 {
+		t.Errorf("SARIF results = %d, want 4", got)
+	}
+	if got := len(sarif.Runs[0].Tool.Driver.Rules); got != 2 {
+		t.Errorf("SARIF rules = %d, want 2", got)
+	}
+	if got := sarif.Runs[0].Results[0].Level; got != "error" {
+		t.Errorf("first SARIF result level = %s, want error", got)
+	}
+}
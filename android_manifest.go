@@ -0,0 +1,558 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	testing "google.golang.org/api/testing/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	apkManifestPath = "AndroidManifest.xml"
+	aabManifestPath = "base/manifest/AndroidManifest.xml"
+
+	androidNamespace = "http://schemas.android.com/apk/res/android"
+)
+
+// Resource IDs of the android: attributes, the attribute names may be stripped by resource shrinkers.
+var androidAttributeIDs = map[string]uint32{
+	"name":             0x01010003,
+	"targetPackage":    0x01010021,
+	"value":            0x01010024,
+	"minSdkVersion":    0x0101020c,
+	"versionCode":      0x0101021b,
+	"versionName":      0x0101021c,
+	"targetSdkVersion": 0x01010270,
+}
+
+// xmlElement is an element of a compiled XML document (binary XML of APKs, protobuf XML of AABs)
+type xmlElement struct {
+	Name       string
+	Attributes []xmlAttribute
+	Children   []*xmlElement
+}
+
+type xmlAttribute struct {
+	Namespace  string
+	Name       string
+	Value      string
+	ResourceID uint32
+}
+
+func (e *xmlElement) attribute(name string) string {
+	for _, attribute := range e.Attributes {
+		if attribute.Namespace == "" && attribute.Name == name {
+			return attribute.Value
+		}
+	}
+	return ""
+}
+
+func (e *xmlElement) androidAttribute(name string) string {
+	id := androidAttributeIDs[name]
+	for _, attribute := range e.Attributes {
+		if (attribute.Namespace == androidNamespace && attribute.Name == name) || (id != 0 && attribute.ResourceID == id) {
+			return attribute.Value
+		}
+	}
+	return ""
+}
+
+func (e *xmlElement) children(name string) []*xmlElement {
+	var children []*xmlElement
+	for _, child := range e.Children {
+		if child.Name == name {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// AndroidManifest contains the inspected properties of an app or test app manifest
+type AndroidManifest struct {
+	Package          string
+	VersionCode      string
+	VersionName      string
+	MinSdkVersion    int
+	TargetSdkVersion int
+	Instrumentations []ManifestInstrumentation
+
+	root *xmlElement
+}
+
+// ManifestInstrumentation is an <instrumentation> element of a manifest
+type ManifestInstrumentation struct {
+	Name          string
+	TargetPackage string
+}
+
+func newAndroidManifest(root *xmlElement) (AndroidManifest, error) {
+	if root == nil || root.Name != "manifest" {
+		return AndroidManifest{}, errors.New("root element is not <manifest>")
+	}
+
+	manifest := AndroidManifest{
+		Package:     root.attribute("package"),
+		VersionCode: root.androidAttribute("versionCode"),
+		VersionName: root.androidAttribute("versionName"),
+		// minSdkVersion defaults to 1 if not set
+		MinSdkVersion: 1,
+		root:          root,
+	}
+
+	for _, usesSdk := range root.children("uses-sdk") {
+		// codenames of preview SDKs are not numbers, those are treated as unknown (0)
+		if minSdk := usesSdk.androidAttribute("minSdkVersion"); minSdk != "" {
+			manifest.MinSdkVersion, _ = strconv.Atoi(minSdk)
+		}
+		if targetSdk := usesSdk.androidAttribute("targetSdkVersion"); targetSdk != "" {
+			manifest.TargetSdkVersion, _ = strconv.Atoi(targetSdk)
+		}
+	}
+	if manifest.TargetSdkVersion == 0 {
+		manifest.TargetSdkVersion = manifest.MinSdkVersion
+	}
+
+	for _, instrumentation := range root.children("instrumentation") {
+		manifest.Instrumentations = append(manifest.Instrumentations, ManifestInstrumentation{
+			Name:          instrumentation.androidAttribute("name"),
+			TargetPackage: instrumentation.androidAttribute("targetPackage"),
+		})
+	}
+
+	return manifest, nil
+}
+
+// readAndroidManifest reads the manifest of an APK (binary XML) or an AAB (protobuf XML of the base module).
+func readAndroidManifest(pth string) (AndroidManifest, error) {
+	reader, err := zip.OpenReader(pth)
+	if err != nil {
+		return AndroidManifest{}, fmt.Errorf("failed to open %s: %w", pth, err)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	manifestPath, parse := apkManifestPath, parseBinaryXML
+	if isAppBundle(pth) {
+		manifestPath, parse = aabManifestPath, parseProtoXML
+	}
+
+	data, err := readZipFile(&reader.Reader, manifestPath)
+	if err != nil {
+		return AndroidManifest{}, fmt.Errorf("%s: %w", pth, err)
+	}
+
+	root, err := parse(data)
+	if err != nil {
+		return AndroidManifest{}, fmt.Errorf("failed to parse %s of %s: %w", manifestPath, pth, err)
+	}
+
+	return newAndroidManifest(root)
+}
+
+func readZipFile(reader *zip.Reader, name string) ([]byte, error) {
+	for _, file := range reader.File {
+		if file.Name != name {
+			continue
+		}
+
+		rc, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", name, err)
+		}
+		defer func() {
+			_ = rc.Close()
+		}()
+
+		return io.ReadAll(rc)
+	}
+	return nil, fmt.Errorf("%s not found", name)
+}
+
+// checkMinSdkVersion returns an error listing the devices whose API level is below the app's minSdkVersion.
+func checkMinSdkVersion(manifest AndroidManifest, devices []*testing.AndroidDevice) error {
+	var unsupported []string
+	for _, device := range devices {
+		apiLevel, err := strconv.Atoi(device.AndroidVersionId)
+		if err != nil {
+			continue
+		}
+		if apiLevel < manifest.MinSdkVersion {
+			unsupported = append(unsupported, fmt.Sprintf("%s (API level %d)", device.AndroidModelId, apiLevel))
+		}
+	}
+
+	if len(unsupported) > 0 {
+		return fmt.Errorf("the app requires at least API level %d (minSdkVersion), not supported by: %s", manifest.MinSdkVersion, strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+// Binary XML (AXML) chunk types, see ResourceTypes.h of the Android framework.
+const (
+	resStringPoolType      = 0x0001
+	resXMLType             = 0x0003
+	resXMLStartElementType = 0x0102
+	resXMLEndElementType   = 0x0103
+	resXMLResourceMapType  = 0x0180
+
+	resStringPoolUTF8Flag = 1 << 8
+	resNoEntry            = 0xFFFFFFFF
+)
+
+// Typed value data types of binary XML attributes
+const (
+	resValueTypeReference  = 0x01
+	resValueTypeString     = 0x03
+	resValueTypeIntDec     = 0x10
+	resValueTypeIntHex     = 0x11
+	resValueTypeIntBoolean = 0x12
+)
+
+var errInvalidBinaryXML = errors.New("invalid binary XML")
+
+// parseBinaryXML parses the compiled binary XML format of the APK resources.
+func parseBinaryXML(data []byte) (*xmlElement, error) {
+	if len(data) < 8 || binary.LittleEndian.Uint16(data) != resXMLType {
+		return nil, errInvalidBinaryXML
+	}
+
+	var stringPool []string
+	var resourceIDs []uint32
+	var root *xmlElement
+	var stack []*xmlElement
+
+	str := func(index uint32) string {
+		if index == resNoEntry || int(index) >= len(stringPool) {
+			return ""
+		}
+		return stringPool[index]
+	}
+
+	offset := int(binary.LittleEndian.Uint16(data[2:]))
+	for offset+8 <= len(data) {
+		chunkType := binary.LittleEndian.Uint16(data[offset:])
+		headerSize := int(binary.LittleEndian.Uint16(data[offset+2:]))
+		chunkSize := int(binary.LittleEndian.Uint32(data[offset+4:]))
+		if chunkSize < 8 || headerSize > chunkSize || offset+chunkSize > len(data) {
+			return nil, fmt.Errorf("%w: chunk at offset %d", errInvalidBinaryXML, offset)
+		}
+		chunk := data[offset : offset+chunkSize]
+
+		switch chunkType {
+		case resStringPoolType:
+			var err error
+			if stringPool, err = parseStringPool(chunk); err != nil {
+				return nil, err
+			}
+		case resXMLResourceMapType:
+			for i := headerSize; i+4 <= chunkSize; i += 4 {
+				resourceIDs = append(resourceIDs, binary.LittleEndian.Uint32(chunk[i:]))
+			}
+		case resXMLStartElementType:
+			ext := chunk[headerSize:]
+			if len(ext) < 20 {
+				return nil, fmt.Errorf("%w: element at offset %d", errInvalidBinaryXML, offset)
+			}
+
+			element := &xmlElement{Name: str(binary.LittleEndian.Uint32(ext[4:]))}
+			attributeStart := int(binary.LittleEndian.Uint16(ext[8:]))
+			attributeSize := int(binary.LittleEndian.Uint16(ext[10:]))
+			attributeCount := int(binary.LittleEndian.Uint16(ext[12:]))
+			for i := 0; i < attributeCount; i++ {
+				start := attributeStart + i*attributeSize
+				if attributeSize < 20 || start+20 > len(ext) {
+					return nil, fmt.Errorf("%w: attribute of <%s>", errInvalidBinaryXML, element.Name)
+				}
+				a := ext[start:]
+
+				nameIndex := binary.LittleEndian.Uint32(a[4:])
+				attribute := xmlAttribute{
+					Namespace: str(binary.LittleEndian.Uint32(a[0:])),
+					Name:      str(nameIndex),
+				}
+				if int(nameIndex) < len(resourceIDs) {
+					attribute.ResourceID = resourceIDs[nameIndex]
+				}
+
+				rawValue := binary.LittleEndian.Uint32(a[8:])
+				dataType := a[15]
+				value := binary.LittleEndian.Uint32(a[16:])
+				switch {
+				case rawValue != resNoEntry:
+					attribute.Value = str(rawValue)
+				case dataType == resValueTypeString:
+					attribute.Value = str(value)
+				case dataType == resValueTypeIntDec:
+					attribute.Value = strconv.Itoa(int(int32(value)))
+				case dataType == resValueTypeIntHex:
+					attribute.Value = fmt.Sprintf("0x%08x", value)
+				case dataType == resValueTypeIntBoolean:
+					attribute.Value = strconv.FormatBool(value != 0)
+				case dataType == resValueTypeReference:
+					attribute.Value = fmt.Sprintf("@0x%08x", value)
+				default:
+					attribute.Value = strconv.FormatUint(uint64(value), 10)
+				}
+
+				element.Attributes = append(element.Attributes, attribute)
+			}
+
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, element)
+			} else if root == nil {
+				root = element
+			}
+			stack = append(stack, element)
+		case resXMLEndElementType:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+
+		offset += chunkSize
+	}
+
+	if root == nil {
+		return nil, fmt.Errorf("%w: no root element", errInvalidBinaryXML)
+	}
+	return root, nil
+}
+
+func parseStringPool(chunk []byte) ([]string, error) {
+	if len(chunk) < 28 {
+		return nil, fmt.Errorf("%w: string pool header", errInvalidBinaryXML)
+	}
+	headerSize := int(binary.LittleEndian.Uint16(chunk[2:]))
+	stringCount := int(binary.LittleEndian.Uint32(chunk[8:]))
+	isUTF8 := binary.LittleEndian.Uint32(chunk[16:])&resStringPoolUTF8Flag != 0
+	stringsStart := int(binary.LittleEndian.Uint32(chunk[20:]))
+	if headerSize+4*stringCount > len(chunk) {
+		return nil, fmt.Errorf("%w: string pool offsets", errInvalidBinaryXML)
+	}
+
+	stringPool := make([]string, stringCount)
+	for i := range stringPool {
+		offset := stringsStart + int(binary.LittleEndian.Uint32(chunk[headerSize+4*i:]))
+
+		var s string
+		var ok bool
+		if isUTF8 {
+			s, ok = decodeUTF8PoolString(chunk, offset)
+		} else {
+			s, ok = decodeUTF16PoolString(chunk, offset)
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: string %d of the string pool", errInvalidBinaryXML, i)
+		}
+		stringPool[i] = s
+	}
+
+	return stringPool, nil
+}
+
+func decodeUTF8PoolString(chunk []byte, offset int) (string, bool) {
+	// the UTF-16 length, then the UTF-8 length, each stored in 1 or 2 bytes
+	readLength := func() (int, bool) {
+		if offset >= len(chunk) {
+			return 0, false
+		}
+		length := int(chunk[offset])
+		offset++
+		if length&0x80 != 0 {
+			if offset >= len(chunk) {
+				return 0, false
+			}
+			length = (length&0x7F)<<8 | int(chunk[offset])
+			offset++
+		}
+		return length, true
+	}
+
+	if _, ok := readLength(); !ok {
+		return "", false
+	}
+	length, ok := readLength()
+	if !ok || offset+length > len(chunk) {
+		return "", false
+	}
+	return string(chunk[offset : offset+length]), true
+}
+
+func decodeUTF16PoolString(chunk []byte, offset int) (string, bool) {
+	if offset+2 > len(chunk) {
+		return "", false
+	}
+	length := int(binary.LittleEndian.Uint16(chunk[offset:]))
+	offset += 2
+	if length&0x8000 != 0 {
+		if offset+2 > len(chunk) {
+			return "", false
+		}
+		length = (length&0x7FFF)<<16 | int(binary.LittleEndian.Uint16(chunk[offset:]))
+		offset += 2
+	}
+	if offset+2*length > len(chunk) {
+		return "", false
+	}
+
+	units := make([]uint16, length)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(chunk[offset+2*i:])
+	}
+	return string(utf16.Decode(units)), true
+}
+
+// Field numbers of the aapt2 XML messages (frameworks/base/tools/aapt2/Resources.proto)
+const (
+	protoXMLNodeElement         = 1
+	protoXMLElementName         = 3
+	protoXMLElementAttribute    = 4
+	protoXMLElementChild        = 5
+	protoXMLAttributeNamespace  = 1
+	protoXMLAttributeName       = 2
+	protoXMLAttributeValue      = 3
+	protoXMLAttributeResourceID = 5
+	protoXMLAttributeItem       = 6
+	protoItemPrimitive          = 7
+	protoPrimitiveIntDecimal    = 6
+	protoPrimitiveIntHex        = 7
+	protoPrimitiveBoolean       = 8
+)
+
+// parseProtoXML parses the protobuf XML format (XmlNode message) of the app bundle resources.
+func parseProtoXML(data []byte) (*xmlElement, error) {
+	var root *xmlElement
+	err := consumeProtoFields(data, func(num protowire.Number, value []byte, _ uint64) error {
+		if num != protoXMLNodeElement {
+			return nil
+		}
+		var err error
+		root, err = parseProtoXMLElement(value)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, errors.New("invalid protobuf XML: no root element")
+	}
+	return root, nil
+}
+
+func parseProtoXMLElement(data []byte) (*xmlElement, error) {
+	element := &xmlElement{}
+	err := consumeProtoFields(data, func(num protowire.Number, value []byte, _ uint64) error {
+		switch num {
+		case protoXMLElementName:
+			element.Name = string(value)
+		case protoXMLElementAttribute:
+			attribute, err := parseProtoXMLAttribute(value)
+			if err != nil {
+				return err
+			}
+			element.Attributes = append(element.Attributes, attribute)
+		case protoXMLElementChild:
+			// a child node is either an element or a text node
+			return consumeProtoFields(value, func(num protowire.Number, value []byte, _ uint64) error {
+				if num != protoXMLNodeElement {
+					return nil
+				}
+				child, err := parseProtoXMLElement(value)
+				if err != nil {
+					return err
+				}
+				element.Children = append(element.Children, child)
+				return nil
+			})
+		}
+		return nil
+	})
+	return element, err
+}
+
+func parseProtoXMLAttribute(data []byte) (xmlAttribute, error) {
+	var attribute xmlAttribute
+	var primitive string
+	err := consumeProtoFields(data, func(num protowire.Number, value []byte, varint uint64) error {
+		switch num {
+		case protoXMLAttributeNamespace:
+			attribute.Namespace = string(value)
+		case protoXMLAttributeName:
+			attribute.Name = string(value)
+		case protoXMLAttributeValue:
+			attribute.Value = string(value)
+		case protoXMLAttributeResourceID:
+			attribute.ResourceID = uint32(varint)
+		case protoXMLAttributeItem:
+			return consumeProtoFields(value, func(num protowire.Number, value []byte, _ uint64) error {
+				if num != protoItemPrimitive {
+					return nil
+				}
+				return consumeProtoFields(value, func(num protowire.Number, _ []byte, varint uint64) error {
+					switch num {
+					case protoPrimitiveIntDecimal:
+						primitive = strconv.Itoa(int(int32(varint)))
+					case protoPrimitiveIntHex:
+						primitive = fmt.Sprintf("0x%08x", uint32(varint))
+					case protoPrimitiveBoolean:
+						primitive = strconv.FormatBool(varint != 0)
+					}
+					return nil
+				})
+			})
+		}
+		return nil
+	})
+	if attribute.Value == "" {
+		attribute.Value = primitive
+	}
+	return attribute, err
+}
+
+// consumeProtoFields calls fn with the number and the value of each field of the protobuf message:
+// the content of length delimited fields or the value of varint fields, other fields are skipped.
+func consumeProtoFields(data []byte, fn func(num protowire.Number, value []byte, varint uint64) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return fmt.Errorf("invalid protobuf XML: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		var err error
+		switch typ {
+		case protowire.BytesType:
+			var value []byte
+			value, n = protowire.ConsumeBytes(data)
+			if n >= 0 {
+				err = fn(num, value, 0)
+			}
+		case protowire.VarintType:
+			var varint uint64
+			varint, n = protowire.ConsumeVarint(data)
+			if n >= 0 {
+				err = fn(num, nil, varint)
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return fmt.Errorf("invalid protobuf XML: %w", protowire.ParseError(n))
+		}
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	testingapi "google.golang.org/api/testing/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestReadAndroidManifest_APK(t *testing.T) {
+	pth := filepath.Join(t.TempDir(), "app.apk")
+	if err := os.WriteFile(pth, emptyAndroidApp, 0644); err != nil {
+		t.Fatalf("failed to write app: %v", err)
+	}
+
+	manifest, err := readAndroidManifest(pth)
+	if err != nil {
+		t.Fatalf("readAndroidManifest() returned error: %v", err)
+	}
+
+	if manifest.Package != "com.example.myapplication" || manifest.VersionCode != "1" || manifest.VersionName != "1.0" {
+		t.Errorf("package = %s, version = %s (%s)", manifest.Package, manifest.VersionName, manifest.VersionCode)
+	}
+	if manifest.MinSdkVersion != 21 || manifest.TargetSdkVersion != 33 {
+		t.Errorf("minSdkVersion = %d, targetSdkVersion = %d, want 21, 33", manifest.MinSdkVersion, manifest.TargetSdkVersion)
+	}
+}
+
+func appendProtoXMLAttribute(b []byte, name, value string, primitive int32) []byte {
+	var attribute []byte
+	attribute = protowire.AppendTag(attribute, protoXMLAttributeNamespace, protowire.BytesType)
+	attribute = protowire.AppendString(attribute, androidNamespace)
+	attribute = protowire.AppendTag(attribute, protoXMLAttributeName, protowire.BytesType)
+	attribute = protowire.AppendString(attribute, name)
+	if value != "" {
+		attribute = protowire.AppendTag(attribute, protoXMLAttributeValue, protowire.BytesType)
+		attribute = protowire.AppendString(attribute, value)
+	} else {
+		var prim []byte
+		prim = protowire.AppendTag(prim, protoPrimitiveIntDecimal, protowire.VarintType)
+		prim = protowire.AppendVarint(prim, uint64(primitive))
+		var item []byte
+		item = protowire.AppendTag(item, protoItemPrimitive, protowire.BytesType)
+		item = protowire.AppendBytes(item, prim)
+		attribute = protowire.AppendTag(attribute, protoXMLAttributeItem, protowire.BytesType)
+		attribute = protowire.AppendBytes(attribute, item)
+	}
+
+	b = protowire.AppendTag(b, protoXMLElementAttribute, protowire.BytesType)
+	return protowire.AppendBytes(b, attribute)
+}
+
+func TestReadAndroidManifest_AAB(t *testing.T) {
+	var usesSdk []byte
+	usesSdk = protowire.AppendTag(usesSdk, protoXMLElementName, protowire.BytesType)
+	usesSdk = protowire.AppendString(usesSdk, "uses-sdk")
+	usesSdk = appendProtoXMLAttribute(usesSdk, "minSdkVersion", "", 26)
+	usesSdk = appendProtoXMLAttribute(usesSdk, "targetSdkVersion", "34", 0)
+
+	var usesSdkNode []byte
+	usesSdkNode = protowire.AppendTag(usesSdkNode, protoXMLNodeElement, protowire.BytesType)
+	usesSdkNode = protowire.AppendBytes(usesSdkNode, usesSdk)
+
+	var manifest []byte
+	manifest = protowire.AppendTag(manifest, protoXMLElementName, protowire.BytesType)
+	manifest = protowire.AppendString(manifest, "manifest")
+	manifest = protowire.AppendTag(manifest, protoXMLElementAttribute, protowire.BytesType)
+	var packageAttribute []byte
+	packageAttribute = protowire.AppendTag(packageAttribute, protoXMLAttributeName, protowire.BytesType)
+	packageAttribute = protowire.AppendString(packageAttribute, "package")
+	packageAttribute = protowire.AppendTag(packageAttribute, protoXMLAttributeValue, protowire.BytesType)
+	packageAttribute = protowire.AppendString(packageAttribute, "com.example.bundle")
+	manifest = protowire.AppendBytes(manifest, packageAttribute)
+	manifest = appendProtoXMLAttribute(manifest, "versionName", "2.1", 0)
+	manifest = protowire.AppendTag(manifest, protoXMLElementChild, protowire.BytesType)
+	manifest = protowire.AppendBytes(manifest, usesSdkNode)
+
+	var node []byte
+	node = protowire.AppendTag(node, protoXMLNodeElement, protowire.BytesType)
+	node = protowire.AppendBytes(node, manifest)
+
+	pth := filepath.Join(t.TempDir(), "app.aab")
+	file, err := os.Create(pth)
+	if err != nil {
+		t.Fatalf("failed to create bundle: %v", err)
+	}
+	writer := zip.NewWriter(file)
+	entry, err := writer.Create(aabManifestPath)
+	if err != nil {
+		t.Fatalf("failed to create manifest entry: %v", err)
+	}
+	if _, err := entry.Write(node); err != nil {
+		t.Fatalf("failed to write manifest entry: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close bundle: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("failed to close bundle: %v", err)
+	}
+
+	got, err := readAndroidManifest(pth)
+	if err != nil {
+		t.Fatalf("readAndroidManifest() returned error: %v", err)
+	}
+	if got.Package != "com.example.bundle" || got.VersionName != "2.1" || got.MinSdkVersion != 26 || got.TargetSdkVersion != 34 {
+		t.Errorf("readAndroidManifest() = %+v", got)
+	}
+}
+
+func TestCheckMinSdkVersion(t *testing.T) {
+	devices := []*testingapi.AndroidDevice{
+		{AndroidModelId: "MediumPhone.arm", AndroidVersionId: "33"},
+		{AndroidModelId: "Nexus5", AndroidVersionId: "23"},
+	}
+
+	if err := checkMinSdkVersion(AndroidManifest{MinSdkVersion: 21}, devices); err != nil {
+		t.Errorf("checkMinSdkVersion() returned error: %v", err)
+	}
+
+	err := checkMinSdkVersion(AndroidManifest{MinSdkVersion: 26}, devices)
+	if want := "the app requires at least API level 26 (minSdkVersion), not supported by: Nexus5 (API level 23)"; err == nil || err.Error() != want {
+		t.Errorf("checkMinSdkVersion() error = %v, want %s", err, want)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	// apiConnectTimeout limits the TCP connection and the TLS handshake
+	apiConnectTimeout = 30 * time.Second
+	// apiResponseTimeout limits the wait for the response headers, the response body (downloads) is not limited
+	apiResponseTimeout = 2 * time.Minute
+	// apiMaxRetries is the number of retries of a failed request
+	apiMaxRetries = 3
+	// apiMaxRetryWait caps the wait before a retry, including the wait the server asks for in the Retry-After header
+	apiMaxRetryWait = time.Minute
+)
+
+var (
+	// apiRetryWait is the wait before the first retry, it doubles with every retry
+	apiRetryWait = time.Second
+
+	// stepVersion is set on release builds with -ldflags "-X main.stepVersion=<version>"
+	stepVersion = ""
+)
+
+// newAPIClient returns the HTTP client shared by the test API calls, the uploads and the downloads.
+// Failed requests are retried, waiting with the sleeper.
+func newAPIClient(sleeper sleeper) *http.Client {
+	dialer := &net.Dialer{Timeout: apiConnectTimeout, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   apiConnectTimeout,
+		ResponseHeaderTimeout: apiResponseTimeout,
+		ExpectContinueTimeout: time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	return &http.Client{Transport: &apiTransport{base: transport, sleeper: sleeper, userAgent: userAgent()}}
+}
+
+// userAgent identifies the step and its version.
+func userAgent() string {
+	version := stepVersion
+	if version == "" {
+		version = "dev"
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range info.Settings {
+				if setting.Key == "vcs.revision" && len(setting.Value) >= 7 {
+					version += "-" + setting.Value[:7]
+				}
+			}
+		}
+	}
+	return "bitrise-steps-virtual-device-testing-for-android/" + version
+}
+
+// apiTransport sets the User-Agent, logs the requests in debug mode and retries the failed requests:
+// network errors and 5xx responses of idempotent requests, and 429 responses of any request whose body can be sent again.
+type apiTransport struct {
+	base      http.RoundTripper
+	sleeper   sleeper
+	userAgent string
+}
+
+func (t *apiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if ua := req.Header.Get("User-Agent"); ua != "" {
+		req.Header.Set("User-Agent", t.userAgent+" "+ua)
+	} else {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to reset request body for retry: %s", err)
+			}
+			req.Body = body
+		}
+
+		log.Debugf("HTTP request: %s %s", req.Method, req.URL)
+		start := time.Now()
+		resp, err := t.base.RoundTrip(req)
+		if err != nil {
+			log.Debugf("HTTP request failed: %s %s (%s), error: %s", req.Method, req.URL, time.Since(start).Round(time.Millisecond), err)
+		} else {
+			log.Debugf("HTTP response: %s %s: %s (%s)", req.Method, req.URL, resp.Status, time.Since(start).Round(time.Millisecond))
+		}
+
+		if attempt >= apiMaxRetries || !shouldRetry(req, resp, err) {
+			return resp, err
+		}
+
+		wait := retryWait(resp, attempt)
+		if resp != nil {
+			// the body is drained to reuse the connection
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			_ = resp.Body.Close()
+		}
+		log.Debugf("Retrying %s %s in %s (%d/%d)", req.Method, req.URL, wait, attempt+1, apiMaxRetries)
+		t.sleeper.Sleep(wait)
+	}
+}
+
+// shouldRetry classifies the result of a request.
+func shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	idempotent := req.Method == http.MethodGet || req.Method == http.MethodHead
+
+	switch {
+	case err != nil:
+		return idempotent
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return replayable
+	case resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented:
+		return idempotent
+	default:
+		return false
+	}
+}
+
+// retryWait returns the wait the server asked for in the Retry-After header, or an exponential backoff.
+func retryWait(resp *http.Response, attempt int) time.Duration {
+	wait := apiRetryWait << attempt
+	if resp != nil {
+		if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+			if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+				wait = time.Duration(seconds) * time.Second
+			} else if date, err := http.ParseTime(retryAfter); err == nil {
+				wait = time.Until(date)
+			}
+		}
+	}
+
+	if wait < 0 {
+		wait = 0
+	}
+	if wait > apiMaxRetryWait {
+		wait = apiMaxRetryWait
+	}
+	return wait
+}
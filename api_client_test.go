@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSleeper records the waits instead of sleeping
+type recordingSleeper struct {
+	waits []time.Duration
+}
+
+func (s *recordingSleeper) Sleep(d time.Duration) {
+	s.waits = append(s.waits, d)
+}
+
+func TestAPIClient_Retry(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		statuses   []int
+		retryAfter string
+		wantStatus int
+		wantWaits  []time.Duration
+	}{
+		{
+			name:       "GET 5xx is retried",
+			method:     http.MethodGet,
+			statuses:   []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK},
+			wantStatus: http.StatusOK,
+			wantWaits:  []time.Duration{apiRetryWait, 2 * apiRetryWait},
+		},
+		{
+			name:       "GET 5xx gives up after the retries",
+			method:     http.MethodGet,
+			statuses:   []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusOK},
+			wantStatus: http.StatusBadGateway,
+			wantWaits:  []time.Duration{apiRetryWait, 2 * apiRetryWait, 4 * apiRetryWait},
+		},
+		{
+			name:       "GET 4xx is not retried",
+			method:     http.MethodGet,
+			statuses:   []int{http.StatusNotFound, http.StatusOK},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "POST 5xx is not retried",
+			method:     http.MethodPost,
+			statuses:   []int{http.StatusInternalServerError, http.StatusOK},
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "POST 429 is retried after Retry-After",
+			method:     http.MethodPost,
+			statuses:   []int{http.StatusTooManyRequests, http.StatusOK},
+			retryAfter: "7",
+			wantStatus: http.StatusOK,
+			wantWaits:  []time.Duration{7 * time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var bodies, userAgents []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				body := new(bytes.Buffer)
+				_, _ = body.ReadFrom(r.Body)
+				bodies = append(bodies, body.String())
+				userAgents = append(userAgents, r.Header.Get("User-Agent"))
+
+				status := tt.statuses[len(bodies)-1]
+				if status == http.StatusTooManyRequests {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(status)
+			}))
+			defer server.Close()
+
+			sleeper := &recordingSleeper{}
+			var body io.Reader
+			if tt.method != http.MethodGet {
+				body = strings.NewReader("payload")
+			}
+			req, err := http.NewRequest(tt.method, server.URL, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := newAPIClient(sleeper).Do(req)
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			defer func() { _ = resp.Body.Close() }()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if !reflect.DeepEqual(sleeper.waits, tt.wantWaits) {
+				t.Errorf("waits = %v, want %v", sleeper.waits, tt.wantWaits)
+			}
+			if got, want := len(bodies), len(tt.wantWaits)+1; got != want {
+				t.Errorf("requests = %d, want %d", got, want)
+			}
+			for i, ua := range userAgents {
+				if !strings.HasPrefix(ua, "bitrise-steps-virtual-device-testing-for-android/") {
+					t.Errorf("User-Agent = %q, want the step's user agent", ua)
+				}
+				if tt.method == http.MethodPost && bodies[i] != "payload" {
+					t.Errorf("request %d body = %q, want the body sent again", i, bodies[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRetryWait(t *testing.T) {
+	retryAfter := func(value string) *http.Response {
+		return &http.Response{Header: http.Header{"Retry-After": []string{value}}}
+	}
+
+	if got, want := retryWait(nil, 2), 4*apiRetryWait; got != want {
+		t.Errorf("backoff = %s, want %s", got, want)
+	}
+	if got, want := retryWait(retryAfter("3600"), 0), apiMaxRetryWait; got != want {
+		t.Errorf("long Retry-After = %s, want %s", got, want)
+	}
+	if got := retryWait(retryAfter(time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)), 0); got != 0 {
+		t.Errorf("past Retry-After date = %s, want 0", got)
+	}
+	if got := retryWait(retryAfter(time.Now().Add(30*time.Second).UTC().Format(http.TimeFormat)), 0); got <= 20*time.Second || got > 30*time.Second {
+		t.Errorf("Retry-After date = %s, want about 30s", got)
+	}
+	if got, want := retryWait(retryAfter("soon"), 1), 2*apiRetryWait; got != want {
+		t.Errorf("invalid Retry-After = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	testing "google.golang.org/api/testing/v1"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-io/go-utils/ziputil"
+)
+
+const (
+	assetManifestFileName  = "manifest.json"
+	testAssetsArchiveName  = "vdtesting_test_assets.zip"
+	testAssetsArchiveEnvID = "VDTESTING_DOWNLOADED_FILES_ARCHIVE"
+)
+
+const (
+	artifactTypeXML             = "xml"
+	artifactTypeVideo           = "video"
+	artifactTypeLogcat          = "logcat"
+	artifactTypeScreenshot      = "screenshot"
+	artifactTypePulledDirectory = "pulled_directory"
+	artifactTypeGameLoopResult  = "game_loop_result"
+)
+
+// per test run results: MediumPhone.arm-33-en-portrait_test_result_1.xml
+// per test run video: MediumPhone.arm-33-en-portrait_video_1.mp4
+var attemptSuffixRegexp = regexp.MustCompile(`_(\d+)$`)
+
+// AssetManifest describes the downloaded test assets and their location inside the download directory
+type AssetManifest struct {
+	Files []AssetManifestEntry `json:"files"`
+}
+
+// AssetManifestEntry describes a single downloaded test asset
+type AssetManifestEntry struct {
+	Path      string `json:"path"`
+	Name      string `json:"name"`
+	Dimension string `json:"dimension"`
+	TestRun   string `json:"test_run,omitempty"`
+	Attempt   int    `json:"attempt,omitempty"`
+	Type      string `json:"type"`
+	Size      int64  `json:"size"`
+	Skipped   bool   `json:"skipped,omitempty"`
+}
+
+// deviceDimensionID returns the device identifier Firebase uses as the prefix of the result file names.
+func deviceDimensionID(device *testing.AndroidDevice) string {
+	return fmt.Sprintf("%s-%s-%s-%s", device.AndroidModelId, device.AndroidVersionId, device.Locale, device.Orientation)
+}
+
+// newAssetManifestEntry determines where a downloaded asset belongs based on its Firebase generated file name.
+func newAssetManifestEntry(fileName string, devices []*testing.AndroidDevice) AssetManifestEntry {
+	entry := AssetManifestEntry{
+		Name: fileName,
+		Type: artifactType(fileName),
+	}
+
+	for _, device := range devices {
+		dimension := deviceDimensionID(device)
+		if strings.HasPrefix(fileName, dimension) && len(dimension) > len(entry.Dimension) {
+			entry.Dimension = dimension
+		}
+	}
+	if entry.Dimension == "" {
+		// Model IDs may contain underscores too (MediumPhone_ps16k.arm), so this is only a fallback for unknown devices.
+		if i := strings.LastIndex(fileName, "-"); i >= 0 {
+			if j := strings.IndexByte(fileName[i:], '_'); j >= 0 {
+				entry.Dimension = fileName[:i+j]
+			}
+		}
+	}
+	if entry.Dimension == "" {
+		entry.Dimension = "unknown"
+	}
+
+	base := fileName
+	if ext := filepath.Ext(fileName); !strings.ContainsAny(ext, "-_") {
+		// model IDs contain dots (MediumPhone.arm), extensionless files like logcat must not be cut there
+		base = strings.TrimSuffix(fileName, ext)
+	}
+	// only the files Firebase numbers per attempt have an attempt suffix, the numeric suffix of game loop results
+	// is the scenario and the one of pulled files and screenshots is part of the file name
+	if match := attemptSuffixRegexp.FindStringSubmatch(base); match != nil && isNumberedPerAttempt(entry.Type) {
+		if attempt, err := strconv.Atoi(match[1]); err == nil {
+			entry.Attempt = attempt
+		}
+	}
+
+	entry.Path = entry.Dimension
+	if entry.Attempt > 0 {
+		entry.Path = filepath.Join(entry.Path, fmt.Sprintf("attempt_%d", entry.Attempt))
+	}
+	entry.Path = filepath.Join(entry.Path, fileName)
+
+	return entry
+}
+
+// deviceID identifies the device the asset belongs to, the test run is included if the step started multiple test matrices.
+func (e AssetManifestEntry) deviceID() string {
+	if e.TestRun == "" {
+		return e.Dimension
+	}
+	return e.TestRun + "/" + e.Dimension
+}
+
+func artifactType(fileName string) string {
+	lowerName := strings.ToLower(fileName)
+	switch {
+	case strings.Contains(lowerName, "logcat"):
+		return artifactTypeLogcat
+	case gameLoopResultRegexp.MatchString(lowerName):
+		return artifactTypeGameLoopResult
+	case strings.HasSuffix(lowerName, ".mp4") || strings.HasSuffix(lowerName, ".webm"):
+		return artifactTypeVideo
+	case strings.HasSuffix(lowerName, ".png") || strings.HasSuffix(lowerName, ".jpg") || strings.HasSuffix(lowerName, ".jpeg"):
+		return artifactTypeScreenshot
+	case strings.HasSuffix(lowerName, ".xml"):
+		return artifactTypeXML
+	default:
+		return artifactTypePulledDirectory
+	}
+}
+
+func isNumberedPerAttempt(artifactType string) bool {
+	return artifactType == artifactTypeXML || artifactType == artifactTypeVideo || artifactType == artifactTypeLogcat
+}
+
+func isMedia(artifactType string) bool {
+	return artifactType == artifactTypeVideo || artifactType == artifactTypeScreenshot
+}
+
+// downloadTestAssets downloads the test assets of the test runs (keyed by the test run name) into per device and per attempt
+// subdirectories of the given directory and writes a manifest describing them. The assets of a named test run are placed
+// into a subdirectory named after the test run. Videos and screenshots larger than maxMediaSize bytes are skipped, 0 means no limit.
+func downloadTestAssets(client *http.Client, runAssets map[string]map[string]string, devices []*testing.AndroidDevice, dir string, maxMediaSize int64) (AssetManifest, error) {
+	runNames := make([]string, 0, len(runAssets))
+	for runName := range runAssets {
+		runNames = append(runNames, runName)
+	}
+	sort.Strings(runNames)
+
+	var manifest AssetManifest
+	for _, runName := range runNames {
+		entries, err := downloadTestRunAssets(client, runAssets[runName], devices, dir, runName, maxMediaSize)
+		if err != nil {
+			return AssetManifest{}, err
+		}
+		manifest.Files = append(manifest.Files, entries...)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return AssetManifest{}, fmt.Errorf("failed to marshal asset manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, assetManifestFileName), data, 0644); err != nil {
+		return AssetManifest{}, fmt.Errorf("failed to write asset manifest: %w", err)
+	}
+
+	return manifest, nil
+}
+
+func downloadTestRunAssets(client *http.Client, assets map[string]string, devices []*testing.AndroidDevice, dir, runName string, maxMediaSize int64) ([]AssetManifestEntry, error) {
+	fileNames := make([]string, 0, len(assets))
+	for fileName := range assets {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+
+	var entries []AssetManifestEntry
+	for _, fileName := range fileNames {
+		entry := newAssetManifestEntry(fileName, devices)
+		if runName != "" {
+			entry.TestRun = runName
+			entry.Path = filepath.Join(runName, entry.Path)
+		}
+
+		pth := filepath.Join(dir, entry.Path)
+		if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directory for %s: %w", fileName, err)
+		}
+		var maxSize int64
+		if isMedia(entry.Type) {
+			maxSize = maxMediaSize
+		}
+		if err := downloadFile(client, assets[fileName], pth, maxSize); errors.Is(err, errFileSizeLimitExceeded) {
+			log.Warnf("Skipping %s, it is larger than the media size limit", fileName)
+			if err := os.Remove(pth); err != nil {
+				return nil, fmt.Errorf("failed to remove skipped file (%s): %w", pth, err)
+			}
+			entry.Skipped = true
+			entries = append(entries, entry)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		info, err := os.Stat(pth)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get file info of %s: %w", pth, err)
+		}
+		entry.Size = info.Size()
+
+		log.Debugf("%s -> %s", fileName, entry.Path)
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// prepareDownloadDir returns the absolute path of the directory the test assets are downloaded to,
+// a new temporary directory is created if no directory is specified.
+func prepareDownloadDir(dir string) (string, error) {
+	if dir == "" {
+		return pathutil.NormalizedOSTempDirPath("vdtesting_test_assets")
+	}
+
+	absDir, err := pathutil.AbsPath(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to expand path (%s): %w", dir, err)
+	}
+	if err := pathutil.EnsureDirExist(absDir); err != nil {
+		return "", fmt.Errorf("failed to create directory (%s): %w", absDir, err)
+	}
+
+	return absDir, nil
+}
+
+// prepareReportDir returns the directory the step's reports are written to: the deploy directory if set,
+// so the reports show up as build artifacts, a new temporary directory otherwise.
+func prepareReportDir(deployDir string) (string, error) {
+	if deployDir == "" {
+		return pathutil.NormalizedOSTempDirPath("vdtesting_reports")
+	}
+	if err := pathutil.EnsureDirExist(deployDir); err != nil {
+		return "", fmt.Errorf("failed to create deploy directory (%s): %w", deployDir, err)
+	}
+	return deployDir, nil
+}
+
+// archiveTestAssets zips the download directory into the deploy directory, so the test assets are available as build artifacts.
+func archiveTestAssets(dir, deployDir string) (string, error) {
+	if err := pathutil.EnsureDirExist(deployDir); err != nil {
+		return "", fmt.Errorf("failed to create deploy directory (%s): %w", deployDir, err)
+	}
+
+	archivePth := filepath.Join(deployDir, testAssetsArchiveName)
+	if err := os.RemoveAll(archivePth); err != nil {
+		return "", fmt.Errorf("failed to remove previous archive (%s): %w", archivePth, err)
+	}
+	if err := ziputil.ZipDir(dir, archivePth, true); err != nil {
+		return "", fmt.Errorf("failed to zip test assets: %w", err)
+	}
+
+	return archivePth, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	testingapi "google.golang.org/api/testing/v1"
+)
+
+func TestNewAssetManifestEntry(t *testing.T) {
+	devices := []*testingapi.AndroidDevice{
+		{AndroidModelId: "MediumPhone.arm", AndroidVersionId: "33", Locale: "en", Orientation: "portrait"},
+		{AndroidModelId: "MediumPhone_ps16k.arm", AndroidVersionId: "36", Locale: "en", Orientation: "portrait"},
+	}
+
+	tests := []struct {
+		name     string
+		fileName string
+		want     AssetManifestEntry
+	}{
+		{
+			name:     "per attempt test result",
+			fileName: "MediumPhone.arm-33-en-portrait_test_result_1.xml",
+			want: AssetManifestEntry{
+				Path:      "MediumPhone.arm-33-en-portrait/attempt_1/MediumPhone.arm-33-en-portrait_test_result_1.xml",
+				Name:      "MediumPhone.arm-33-en-portrait_test_result_1.xml",
+				Dimension: "MediumPhone.arm-33-en-portrait",
+				Attempt:   1,
+				Type:      artifactTypeXML,
+			},
+		},
+		{
+			name:     "merged test result",
+			fileName: "MediumPhone.arm-33-en-portrait_test_results_merged.xml",
+			want: AssetManifestEntry{
+				Path:      "MediumPhone.arm-33-en-portrait/MediumPhone.arm-33-en-portrait_test_results_merged.xml",
+				Name:      "MediumPhone.arm-33-en-portrait_test_results_merged.xml",
+				Dimension: "MediumPhone.arm-33-en-portrait",
+				Type:      artifactTypeXML,
+			},
+		},
+		{
+			name:     "game loop scenario results",
+			fileName: "MediumPhone.arm-33-en-portrait_results_scenario_3.json",
+			want: AssetManifestEntry{
+				Path:      "MediumPhone.arm-33-en-portrait/MediumPhone.arm-33-en-portrait_results_scenario_3.json",
+				Name:      "MediumPhone.arm-33-en-portrait_results_scenario_3.json",
+				Dimension: "MediumPhone.arm-33-en-portrait",
+				Type:      artifactTypeGameLoopResult,
+			},
+		},
+		{
+			name:     "video of a model with underscore in its ID",
+			fileName: "MediumPhone_ps16k.arm-36-en-portrait_video_2.mp4",
+			want: AssetManifestEntry{
+				Path:      "MediumPhone_ps16k.arm-36-en-portrait/attempt_2/MediumPhone_ps16k.arm-36-en-portrait_video_2.mp4",
+				Name:      "MediumPhone_ps16k.arm-36-en-portrait_video_2.mp4",
+				Dimension: "MediumPhone_ps16k.arm-36-en-portrait",
+				Attempt:   2,
+				Type:      artifactTypeVideo,
+			},
+		},
+		{
+			name:     "logcat",
+			fileName: "MediumPhone.arm-33-en-portrait_logcat_1",
+			want: AssetManifestEntry{
+				Path:      "MediumPhone.arm-33-en-portrait/attempt_1/MediumPhone.arm-33-en-portrait_logcat_1",
+				Name:      "MediumPhone.arm-33-en-portrait_logcat_1",
+				Dimension: "MediumPhone.arm-33-en-portrait",
+				Attempt:   1,
+				Type:      artifactTypeLogcat,
+			},
+		},
+		{
+			name:     "pulled screenshot with a numeric suffix",
+			fileName: "MediumPhone.arm-33-en-portrait_sdcard_frame_2.png",
+			want: AssetManifestEntry{
+				Path:      "MediumPhone.arm-33-en-portrait/MediumPhone.arm-33-en-portrait_sdcard_frame_2.png",
+				Name:      "MediumPhone.arm-33-en-portrait_sdcard_frame_2.png",
+				Dimension: "MediumPhone.arm-33-en-portrait",
+				Type:      artifactTypeScreenshot,
+			},
+		},
+		{
+			name:     "pulled file with a numeric suffix",
+			fileName: "MediumPhone.arm-33-en-portrait_sdcard_dump_3",
+			want: AssetManifestEntry{
+				Path:      "MediumPhone.arm-33-en-portrait/MediumPhone.arm-33-en-portrait_sdcard_dump_3",
+				Name:      "MediumPhone.arm-33-en-portrait_sdcard_dump_3",
+				Dimension: "MediumPhone.arm-33-en-portrait",
+				Type:      artifactTypePulledDirectory,
+			},
+		},
+		{
+			name:     "pulled file of an unknown device",
+			fileName: "Pixel2.arm-30-en-landscape_sdcard_tempDir_coverage.ec",
+			want: AssetManifestEntry{
+				Path:      "Pixel2.arm-30-en-landscape/Pixel2.arm-30-en-landscape_sdcard_tempDir_coverage.ec",
+				Name:      "Pixel2.arm-30-en-landscape_sdcard_tempDir_coverage.ec",
+				Dimension: "Pixel2.arm-30-en-landscape",
+				Type:      artifactTypePulledDirectory,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := newAssetManifestEntry(tc.fileName, devices)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("newAssetManifestEntry() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDownloadTestAssets_MediaSizeLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat("x", 100)))
+	}))
+	defer server.Close()
+
+	devices := []*testingapi.AndroidDevice{
+		{AndroidModelId: "MediumPhone.arm", AndroidVersionId: "33", Locale: "en", Orientation: "portrait"},
+	}
+	assets := map[string]string{
+		"MediumPhone.arm-33-en-portrait_video_1.mp4":       server.URL + "/video",
+		"MediumPhone.arm-33-en-portrait_test_result_1.xml": server.URL + "/xml",
+	}
+
+	dir := t.TempDir()
+	manifest, err := downloadTestAssets(http.DefaultClient, map[string]map[string]string{"": assets}, devices, dir, 50)
+	if err != nil {
+		t.Fatalf("downloadTestAssets() returned error: %v", err)
+	}
+
+	if len(manifest.Files) != 2 {
+		t.Fatalf("len(manifest.Files) = %d, want 2", len(manifest.Files))
+	}
+	for _, entry := range manifest.Files {
+		_, statErr := os.Stat(filepath.Join(dir, entry.Path))
+		switch entry.Type {
+		case artifactTypeVideo:
+			if !entry.Skipped || !os.IsNotExist(statErr) {
+				t.Errorf("video should be skipped and removed, got skipped: %t, stat error: %v", entry.Skipped, statErr)
+			}
+		case artifactTypeXML:
+			if entry.Skipped || statErr != nil || entry.Size != 100 {
+				t.Errorf("xml should be downloaded, got skipped: %t, size: %d, stat error: %v", entry.Skipped, entry.Size, statErr)
+			}
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, assetManifestFileName)); err != nil {
+		t.Errorf("manifest was not written: %v", err)
+	}
+}
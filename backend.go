@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+
+	toolresults "google.golang.org/api/toolresults/v1beta3"
+)
+
+const (
+	backendVDT      = "vdt"
+	backendFirebase = "firebase"
+)
+
+// testBackend uploads the test assets, starts the test matrices and serves their results. The step talks to the
+// Bitrise Virtual Device Testing API by default, or directly to Firebase Test Lab in the user's own GCP project.
+type testBackend interface {
+	UploadTestAssets() (TestAssetsAndroid, error)
+	StartTestRun(run testRun, testAssets TestAssetsAndroid) error
+	GetTestRunSteps(run testRun) (*toolresults.ListStepsResponse, error)
+	// GetTestRunAssets returns the download URLs of the test run's result files, keyed by the Firebase generated file name
+	GetTestRunAssets(run testRun) (map[string]string, error)
+	// GetPerfMetrics and GetAccessibilityClusters return the reports of a step of the test run's execution
+	GetPerfMetrics(run testRun, stepID string) (PerfMetrics, error)
+	GetAccessibilityClusters(run testRun, stepID string) (*toolresults.ListStepAccessibilityClustersResponse, error)
+	// DownloadClient is the HTTP client the test assets are downloaded with
+	DownloadClient() *http.Client
+}
+
+// newTestBackend creates the backend selected by the inputs, the client is used for the API calls
+// (as the base transport of the authenticated client with the firebase backend).
+func newTestBackend(configs ConfigsModel, client *http.Client) (testBackend, error) {
+	if configs.Backend == backendFirebase {
+		backend, err := newFirebaseBackend(configs, client)
+		if err != nil {
+			return nil, err
+		}
+		return backend, nil
+	}
+	return vdtBackend{configs: configs, client: client}, nil
+}
+
+// vdtBackend is the Bitrise Virtual Device Testing API, it runs the test matrices in the Bitrise GCP project.
+type vdtBackend struct {
+	configs ConfigsModel
+	client  *http.Client
+}
+
+func (b vdtBackend) UploadTestAssets() (TestAssetsAndroid, error) {
+	return uploadTestAssets(b.client, b.configs)
+}
+
+func (b vdtBackend) StartTestRun(run testRun, testAssets TestAssetsAndroid) error {
+	return startTestRun(b.client, b.configs, run, testAssets)
+}
+
+func (b vdtBackend) GetTestRunSteps(run testRun) (*toolresults.ListStepsResponse, error) {
+	return getTestRunSteps(b.client, b.configs, run)
+}
+
+func (b vdtBackend) GetTestRunAssets(run testRun) (map[string]string, error) {
+	return getTestRunAssets(b.client, b.configs, run)
+}
+
+func (b vdtBackend) GetPerfMetrics(run testRun, stepID string) (PerfMetrics, error) {
+	return getPerfMetrics(b.client, b.configs, run, stepID)
+}
+
+func (b vdtBackend) GetAccessibilityClusters(run testRun, stepID string) (*toolresults.ListStepAccessibilityClustersResponse, error) {
+	return getAccessibilityClusters(b.client, b.configs, run, stepID)
+}
+
+// DownloadClient returns the API client, the test API returns signed download URLs.
+func (b vdtBackend) DownloadClient() *http.Client {
+	return b.client
+}
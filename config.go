@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 
@@ -28,15 +30,34 @@ type ConfigsModel struct {
 	APIBaseURL string `env:"api_base_url"`
 	BuildSlug  string `env:"BITRISE_BUILD_SLUG,required"`
 	AppSlug    string `env:"BITRISE_APP_SLUG,required"`
-	APIToken   string `env:"api_token,required"`
+	APIToken   string `env:"api_token"`
+	// APITokenInHeader sends the API token in the Authorization header instead of the URL path
+	APITokenInHeader bool `env:"api_token_in_header,opt[true,false]"`
+
+	// backend
+	Backend               string `env:"backend,opt[vdt,firebase]"`
+	ServiceAccountKey     string `env:"service_account_key"`
+	ServiceAccountKeyJSON []byte
+	ServiceAccountEmail   string
+	GCPProjectID          string `env:"gcp_project_id"`
+	ResultsBucket         string `env:"results_bucket"`
 
 	// shared
+	ConfigFile      string `env:"config_file"`
 	AppPath         string `env:"app_path"`
 	TestApkPath     string `env:"test_apk_path"`
-	TestType        string `env:"test_type,opt[instrumentation,robo,gameloop]"`
+	TestApkPathList string `env:"test_apk_path_list"`
+	TestApkPaths    []string
+	TestType        string `env:"test_type"`
+	TestTypes       []string
 	TestDevicesList string `env:"test_devices"`
 	TestDevices     []*testing.AndroidDevice
 	AppPackageID    string `env:"app_package_id"`
+	DryRun          bool   `env:"dry_run,opt[true,false]"`
+	AppManifest     *AndroidManifest
+	AppNativeABIs   []string
+
+	DropIncompatibleDevices bool `env:"drop_incompatible_devices,opt[true,false]"`
 
 	// test setup
 	AutoGoogleLogin          bool   `env:"auto_google_login,opt[true,false]"`
@@ -49,14 +70,30 @@ type ConfigsModel struct {
 	TestTimeout           float64 `env:"test_timeout,range]0..3600]"`
 	FlakyTestAttempts     int     `env:"num_flaky_test_attempts,range[0..10]"`
 	DownloadTestResults   bool    `env:"download_test_results,opt[true,false]"`
+	DownloadDir           string  `env:"download_dir"`
+	ArchiveTestResults    bool    `env:"archive_test_results,opt[true,false]"`
+	DeployDir             string  `env:"BITRISE_DEPLOY_DIR"`
+	TestResultDir         string  `env:"BITRISE_TEST_RESULT_DIR"`
+	MaxMediaSize          int     `env:"max_media_size,range[0..10240]"`
 	DirectoriesToPullList string  `env:"directories_to_pull"`
 	DirectoriesToPull     []string
 	VerboseLog            bool `env:"use_verbose_log,opt[true,false]"`
 
+	// performance metrics
+	CollectPerfMetrics bool `env:"collect_perf_metrics,opt[true,false]"`
+	PerfMaxPeakMemory  int  `env:"perf_max_peak_memory,range[0..1048576]"`
+
+	// accessibility
+	CollectAccessibilityFindings bool   `env:"collect_accessibility_findings,opt[true,false]"`
+	AccessibilityReportFormat    string `env:"accessibility_report_format,opt[json,sarif]"`
+	AccessibilityMaxErrors       string `env:"accessibility_max_errors"`
+	AccessibilityErrorLimit      int
+
 	// instrumentation
 	InstTestPackageID      string `env:"inst_test_package_id"`
 	InstTestRunnerClass    string `env:"inst_test_runner_class"`
 	InstTestTargets        string `env:"inst_test_targets"`
+	TestTargets            []string
 	UseOrchestrator        bool   `env:"inst_use_orchestrator,opt[true,false]"`
 	QuarantinedTests       string `env:"quarantined_tests"`
 	QuarantinedTestTargets []string
@@ -64,14 +101,16 @@ type ConfigsModel struct {
 	// robo
 	RoboInitialActivity string `env:"robo_initial_activity"`
 	RoboDirectives      string `env:"robo_directives"`
+	RoboDirectiveList   []*testing.RoboDirective
 	RoboScenarioFile    string `env:"robo_scenario_file"`
 	RoboMaxDepth        string `env:"robo_max_depth"`
 	RoboMaxSteps        string `env:"robo_max_steps"`
 
 	// loop
-	LoopScenarios       string `env:"loop_scenarios"`
-	LoopScenarioLabels  string `env:"loop_scenario_labels"`
-	LoopScenarioNumbers string `env:"loop_scenario_numbers"`
+	LoopScenarios         string `env:"loop_scenarios"`
+	LoopScenarioNumbers   []int64
+	LoopScenarioLabels    string `env:"loop_scenario_labels"`
+	LoopScenarioLabelList []string
 
 	// deprecated
 	ApkPath string `env:"apk_path"`
@@ -79,72 +118,150 @@ type ConfigsModel struct {
 
 func (configs *ConfigsModel) print() {
 	log.Infof("Configs:")
+	if configs.ConfigFile != "" {
+		log.Printf("- ConfigFile: %s", configs.ConfigFile)
+	}
 	log.Printf("- AppPath: %s", configs.AppPath)
+	if configs.DryRun {
+		log.Printf("- DryRun: %t", configs.DryRun)
+	}
+	if configs.Backend == backendFirebase {
+		log.Printf("- Backend: Firebase Test Lab (project: %s, results bucket: %s)", configs.GCPProjectID, configs.ResultsBucket)
+		if configs.ServiceAccountEmail != "" {
+			log.Printf("- ServiceAccount: %s", configs.ServiceAccountEmail)
+		}
+	} else if configs.APITokenInHeader {
+		log.Printf("- APITokenInHeader: %t", configs.APITokenInHeader)
+	}
 	if configs.ApkPath != "" {
 		log.Printf("- ApkPath: %s", configs.ApkPath)
 	}
 	if configs.AppPackageID != "" {
 		log.Printf("- AppPackageID: %s", configs.AppPackageID)
 	}
+	if manifest := configs.AppManifest; manifest != nil {
+		log.Printf("- App: %s, version %s (%s), minSdkVersion: %d, targetSdkVersion: %d", manifest.Package, manifest.VersionName, manifest.VersionCode, manifest.MinSdkVersion, manifest.TargetSdkVersion)
+	}
+	if len(configs.AppNativeABIs) > 0 {
+		log.Printf("- AppNativeABIs: %s", strings.Join(configs.AppNativeABIs, ", "))
+	}
 	log.Printf("- TestTimeout: %f", configs.TestTimeout)
 	log.Printf("- FlakyTestAttempts: %d", configs.FlakyTestAttempts)
 	log.Printf("- DownloadTestResults: %t", configs.DownloadTestResults)
+	log.Printf("- DownloadDir: %s", configs.DownloadDir)
+	log.Printf("- ArchiveTestResults: %t", configs.ArchiveTestResults)
+	log.Printf("- MaxMediaSize: %d MB", configs.MaxMediaSize)
 	log.Printf("- DirectoriesToPull: %s", configs.DirectoriesToPullList)
+	log.Printf("- CollectPerfMetrics: %t", configs.CollectPerfMetrics)
+	if configs.PerfMaxPeakMemory > 0 {
+		log.Printf("- PerfMaxPeakMemory: %d MB", configs.PerfMaxPeakMemory)
+	}
 	log.Printf("- AutoGoogleLogin: %t", configs.AutoGoogleLogin)
 	log.Printf("- EnvironmentVariables: %s", configs.EnvironmentVariablesList)
 	log.Printf("- ObbFilesList: %s", configs.ObbFilesList)
 
 	log.Printf("- TestDevices:\n---")
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	w := tabwriter.NewWriter(logOutput, 0, 0, 3, ' ', 0)
 	if _, err := fmt.Fprintln(w, "Model\tAPI Level\tLocale\tOrientation\t"); err != nil {
-		failf("Failed to write in tabwriter, error: %s", err)
+		log.Warnf("Failed to write in tabwriter, error: %s", err)
 	}
 	for _, testDevice := range configs.TestDevices {
 		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t\n", testDevice.AndroidModelId, testDevice.AndroidVersionId, testDevice.Locale, testDevice.Orientation); err != nil {
-			failf("Failed to write in tabwriter, error: %s", err)
+			log.Warnf("Failed to write in tabwriter, error: %s", err)
 		}
 	}
 	if err := w.Flush(); err != nil {
 		log.Errorf("Failed to flush writer, error: %s", err)
 	}
 	log.Printf("---")
+	if configs.DropIncompatibleDevices {
+		log.Printf("- DropIncompatibleDevices: %t", configs.DropIncompatibleDevices)
+	}
 
-	log.Printf("- TestType: %s", configs.TestType)
+	log.Printf("- TestType: %s", strings.Join(configs.TestTypes, ", "))
 	// instruments
-	if configs.TestType == testTypeInstrumentation {
-		log.Printf("- TestApkPath: %s", configs.TestApkPath)
+	if configs.hasTestType(testTypeInstrumentation) {
+		if len(configs.TestApkPaths) > 1 {
+			log.Printf("- TestApkPathList:")
+			for _, pth := range configs.TestApkPaths {
+				log.Printf("  - %s", pth)
+			}
+		} else if len(configs.TestApkPaths) == 1 {
+			log.Printf("- TestApkPath: %s", configs.TestApkPaths[0])
+		}
 		log.Printf("- InstTestPackageID: %s", configs.InstTestPackageID)
 		log.Printf("- InstTestRunnerClass: %s", configs.InstTestRunnerClass)
-		log.Printf("- InstTestTargets: %s", configs.InstTestTargets)
+		if targets := configs.instrumentationTestTargets(); len(targets) > 0 {
+			log.Printf("- TestTargets (sent to Firebase):")
+			for _, target := range targets {
+				log.Printf("  - %s", target)
+			}
+		} else {
+			log.Printf("- TestTargets: all tests")
+		}
 		log.Printf("- UseOrchestrator: %t", configs.UseOrchestrator)
 		log.Printf("- QuarantinedTests: %s", configs.QuarantinedTests)
 	}
 
 	//robo
-	if configs.TestType == testTypeRobo {
+	if configs.hasTestType(testTypeRobo) {
 		log.Printf("- RoboInitialActivity: %s", configs.RoboInitialActivity)
 		log.Printf("- RoboScenarioFile: %s", configs.RoboScenarioFile)
-		log.Printf("- RoboDirectives: %s", configs.RoboDirectives)
+		log.Printf("- RoboDirectives: %s", roboDirectivesString(configs.RoboDirectiveList))
 		log.Printf("- RoboMaxDepth: %s", configs.RoboMaxDepth)
 		log.Printf("- RoboMaxSteps: %s", configs.RoboMaxSteps)
+		log.Printf("- CollectAccessibilityFindings: %t", configs.CollectAccessibilityFindings)
+		if configs.CollectAccessibilityFindings {
+			log.Printf("- AccessibilityReportFormat: %s", configs.AccessibilityReportFormat)
+			log.Printf("- AccessibilityMaxErrors: %s", configs.AccessibilityMaxErrors)
+		}
 	}
 
 	// loop
-	if configs.TestType == "gameloop" {
+	if configs.hasTestType(testTypeGameLoop) {
 		log.Printf("- LoopScenarios: %s", configs.LoopScenarios)
-		log.Printf("- LoopScenarioLabels: %s", configs.LoopScenarioLabels)
-		log.Printf("- LoopScenarioNumbers: %s", configs.LoopScenarioNumbers)
+		log.Printf("- LoopScenarioLabels: %s", strings.Join(configs.LoopScenarioLabelList, ", "))
+		if len(configs.LoopScenarioNumbers) > 0 {
+			log.Printf("- LoopScenarioNumbers: %d scenarios", len(configs.LoopScenarioNumbers))
+		}
 	}
 }
 
 func (configs *ConfigsModel) validate() error {
 	configs.migrate()
 
-	if strings.TrimSpace(configs.APIBaseURL) == "" {
-		if _, set := os.LookupEnv("BITRISE_IO"); !set {
-			log.Warnf("Warning: please make sure that Virtual Device Testing add-on is turned on under your app's settings tab.")
+	if configs.ConfigFile = strings.TrimSpace(configs.ConfigFile); configs.ConfigFile != "" {
+		config, err := parseTestMatrixConfigFile(configs.ConfigFile)
+		if err != nil {
+			return fmt.Errorf("- ConfigFile: %s", err)
+		}
+		for _, note := range config.notes {
+			log.Warnf("Warning: %s", note)
+		}
+		configs.applyTestMatrixConfig(config)
+	}
+
+	var err error
+	if configs.TestTypes, err = parseTestTypes(configs.TestType); err != nil {
+		return fmt.Errorf("- TestType: %s", err)
+	}
+
+	if configs.Backend == backendFirebase {
+		if !configs.DryRun {
+			if err := configs.validateFirebaseBackend(); err != nil {
+				return err
+			}
+		}
+	} else if !configs.DryRun {
+		if strings.TrimSpace(configs.APIBaseURL) == "" {
+			if _, set := os.LookupEnv("BITRISE_IO"); !set {
+				log.Warnf("Warning: please make sure that Virtual Device Testing add-on is turned on under your app's settings tab.")
+			}
+			return fmt.Errorf("- APIBaseURL: required variable is not present")
+		}
+		if strings.TrimSpace(configs.APIToken) == "" {
+			return fmt.Errorf("- APIToken: required variable is not present")
 		}
-		return fmt.Errorf("- APIBaseURL: required variable is not present")
 	}
 
 	if strings.TrimSpace(configs.AppPath) == "" {
@@ -162,27 +279,91 @@ func (configs *ConfigsModel) validate() error {
 		configs.AppPath = appPath
 	}
 
-	if configs.TestType == testTypeInstrumentation {
-		if strings.TrimSpace(configs.TestApkPath) == "" {
-			return fmt.Errorf("- TestApkPath: required variable is not present. Is it possible that you used gradle-runner step and forgot to set `assembleDebugAndroidTest` task?")
-		}
-		if _, err := os.Stat(configs.TestApkPath); err != nil {
-			return fmt.Errorf("- TestApkPath: failed to get file info, error: %s. Is it possible that you used gradle-runner step and forgot to set `assembleDebugAndroidTest` task?", err)
+	if configs.hasTestType(testTypeInstrumentation) {
+		if configs.TestApkPaths = parseTestApkPathList(configs.TestApkPathList); len(configs.TestApkPaths) > 0 {
+			if strings.TrimSpace(configs.TestApkPath) != "" && !slices.Contains(configs.TestApkPaths, strings.TrimSpace(configs.TestApkPath)) {
+				log.Warnf("Warning: TestApkPathList is set, TestApkPath (%s) is ignored", configs.TestApkPath)
+			}
+			for _, pth := range configs.TestApkPaths {
+				if _, err := os.Stat(pth); err != nil {
+					return fmt.Errorf("- TestApkPathList: failed to get file info, error: %s", err)
+				}
+			}
+		} else {
+			if strings.TrimSpace(configs.TestApkPath) == "" {
+				return fmt.Errorf("- TestApkPath: required variable is not present. Is it possible that you used gradle-runner step and forgot to set `assembleDebugAndroidTest` task?")
+			}
+			if _, err := os.Stat(configs.TestApkPath); err != nil {
+				return fmt.Errorf("- TestApkPath: failed to get file info, error: %s. Is it possible that you used gradle-runner step and forgot to set `assembleDebugAndroidTest` task?", err)
+			}
+			configs.TestApkPaths = []string{configs.TestApkPath}
 		}
 	}
 
 	configs.RoboScenarioFile = strings.TrimSpace(configs.RoboScenarioFile)
-	if configs.TestType == testTypeRobo && configs.RoboScenarioFile != "" {
+	if configs.hasTestType(testTypeRobo) && configs.RoboScenarioFile != "" {
 		if _, err := os.Stat(configs.RoboScenarioFile); err != nil {
 			return fmt.Errorf("- RoboScenarioFile: failed to get file info, error: %s", err)
 		}
 	}
 
-	var err error
+	configs.DownloadDir = strings.TrimSpace(configs.DownloadDir)
+	if configs.ArchiveTestResults {
+		if !configs.DownloadTestResults {
+			log.Warnf("Warning: ArchiveTestResults is enabled, but DownloadTestResults is not, no archive will be created")
+		} else if strings.TrimSpace(configs.DeployDir) == "" {
+			return fmt.Errorf("- ArchiveTestResults: BITRISE_DEPLOY_DIR is not set, the archive has no destination")
+		}
+	}
+
+	if configs.PerfMaxPeakMemory > 0 && !configs.CollectPerfMetrics {
+		return fmt.Errorf("- PerfMaxPeakMemory: the limit requires CollectPerfMetrics to be enabled")
+	}
+
+	configs.AccessibilityErrorLimit = -1
+	if maxErrors := strings.TrimSpace(configs.AccessibilityMaxErrors); maxErrors != "" {
+		limit, err := strconv.Atoi(maxErrors)
+		if err != nil || limit < 0 {
+			return fmt.Errorf("- AccessibilityMaxErrors: should be a non-negative integer, got: %s", maxErrors)
+		}
+		if !configs.CollectAccessibilityFindings {
+			return fmt.Errorf("- AccessibilityMaxErrors: the limit requires CollectAccessibilityFindings to be enabled")
+		}
+		configs.AccessibilityErrorLimit = limit
+	}
+	if configs.CollectAccessibilityFindings && !configs.hasTestType(testTypeRobo) {
+		log.Warnf("Warning: accessibility findings are only reported for robo tests")
+	}
+
 	if configs.TestDevices, err = parseDeviceList(configs.TestDevicesList); err != nil {
 		return fmt.Errorf("- TestDevices: %s", err)
 	}
 
+	if manifest, err := readAndroidManifest(configs.AppPath); err != nil {
+		log.Warnf("Warning: failed to inspect the app manifest: %s", err)
+	} else {
+		configs.AppManifest = &manifest
+		if err := checkMinSdkVersion(manifest, configs.TestDevices); err != nil {
+			return fmt.Errorf("- TestDevices: %s", err)
+		}
+	}
+
+	if err := configs.checkNativeABIs(); err != nil {
+		return fmt.Errorf("- TestDevices: %s", err)
+	}
+
+	if configs.hasTestType(testTypeInstrumentation) {
+		for _, testApkPath := range configs.TestApkPaths {
+			warnings, err := checkTestApk(configs.AppManifest, configs.AppPath, testApkPath, configs.InstTestRunnerClass, configs.UseOrchestrator)
+			if err != nil {
+				return fmt.Errorf("- TestApkPath: %s", err)
+			}
+			for _, warning := range warnings {
+				log.Warnf("Warning: %s", warning)
+			}
+		}
+	}
+
 	if configs.ObbFiles, err = parseObbFilesList(configs.ObbFilesList); err != nil {
 		return fmt.Errorf("- ObbFiles: %s", err)
 	}
@@ -194,9 +375,50 @@ func (configs *ConfigsModel) validate() error {
 		return fmt.Errorf("- QuarantinedTests: %s", err)
 	}
 
+	if configs.hasTestType(testTypeRobo) {
+		if configs.RoboDirectiveList, err = parseRoboDirectives(configs.RoboDirectives); err != nil {
+			return fmt.Errorf("- RoboDirectives: %s", err)
+		}
+		if configs.RoboScenarioFile != "" {
+			if err := validateRoboScriptFile(configs.RoboScenarioFile, configs.appPackageID()); err != nil {
+				return fmt.Errorf("- RoboScenarioFile: %s", err)
+			}
+		}
+	}
+
+	if configs.hasTestType(testTypeGameLoop) {
+		if configs.LoopScenarioNumbers, err = parseLoopScenarios(configs.LoopScenarios); err != nil {
+			return fmt.Errorf("- LoopScenarios: %s", err)
+		}
+		configs.LoopScenarioLabelList = parseLoopScenarioLabels(configs.LoopScenarioLabels)
+
+		if configs.AppManifest != nil {
+			loops, err := configs.AppManifest.gameLoops()
+			if err != nil {
+				log.Warnf("Warning: failed to read the game loop scenarios of the app manifest: %s", err)
+			} else if err := checkGameLoopScenarios(loops, configs.LoopScenarioNumbers, configs.LoopScenarioLabelList); err != nil {
+				return fmt.Errorf("- LoopScenarios: %s", err)
+			}
+		}
+	}
+
+	if configs.hasTestType(testTypeInstrumentation) {
+		if configs.TestTargets, err = parseTestTargets(configs.InstTestTargets); err != nil {
+			return fmt.Errorf("- InstTestTargets: %s", err)
+		}
+	}
+
 	return nil
 }
 
+// appPackageID returns the package of the app under test: the deprecated app_package_id input if set, the package of the app manifest otherwise.
+func (configs ConfigsModel) appPackageID() string {
+	if configs.AppPackageID == "" && configs.AppManifest != nil {
+		return configs.AppManifest.Package
+	}
+	return configs.AppPackageID
+}
+
 func (configs *ConfigsModel) migrate() {
 	if configs.ApkPath != "" {
 		log.Warnf("'Apk path' (apk_path) input is deprected, use 'App path' (app_path) instead.")
@@ -238,6 +460,21 @@ func parseDeviceList(deviceList string) ([]*testing.AndroidDevice, error) {
 	return testDevices, nil
 }
 
+// parseTestApkPathList parses the pipe or newline separated test APK paths, as exported by the Gradle steps (BITRISE_TEST_APK_PATH_LIST).
+func parseTestApkPathList(testApkPathList string) []string {
+	var testApkPaths []string
+	for _, pth := range strings.FieldsFunc(testApkPathList, func(r rune) bool {
+		return r == '|' || r == '\n'
+	}) {
+		pth = strings.TrimSpace(pth)
+		if pth == "" || slices.Contains(testApkPaths, pth) {
+			continue
+		}
+		testApkPaths = append(testApkPaths, pth)
+	}
+	return testApkPaths
+}
+
 func parseObbFilesList(obbFilesList string) ([]string, error) {
 	var obbFiles []string
 	files := strings.Split(obbFilesList, "\n")
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Default values of the step inputs which can be overridden by the config file, see step.yml.
+// An input is considered explicitly set if its value differs from the default.
+const (
+	defaultTestType          = testTypeRobo
+	defaultTestDevices       = "MediumPhone.arm,33,en,portrait"
+	defaultFlakyTestAttempts = 0
+	defaultTestTimeout       = 900
+)
+
+// TestMatrixConfig describes a full test specification in the `config_file` input
+type TestMatrixConfig struct {
+	Type              string                     `yaml:"type"`
+	App               string                     `yaml:"app"`
+	TestApk           string                     `yaml:"test_apk"`
+	Devices           []TestMatrixDevice         `yaml:"devices"`
+	Timeout           *float64                   `yaml:"timeout"`
+	FlakyTestAttempts *int                       `yaml:"flaky_test_attempts"`
+	Instrumentation   *TestMatrixInstrumentation `yaml:"instrumentation"`
+	Robo              *TestMatrixRobo            `yaml:"robo"`
+	GameLoop          *TestMatrixGameLoop        `yaml:"gameloop"`
+	Setup             *TestMatrixSetup           `yaml:"setup"`
+
+	root *yaml.Node
+	// notes are the ignored and unsupported keys of a converted Flank config
+	notes []string
+}
+
+// TestMatrixDevice is a device of the test matrix
+type TestMatrixDevice struct {
+	Model       string `yaml:"model"`
+	Version     string `yaml:"version"`
+	Locale      string `yaml:"locale"`
+	Orientation string `yaml:"orientation"`
+}
+
+// TestMatrixInstrumentation contains the instrumentation test options
+type TestMatrixInstrumentation struct {
+	RunnerClass     string   `yaml:"runner_class"`
+	Targets         []string `yaml:"targets"`
+	UseOrchestrator *bool    `yaml:"use_orchestrator"`
+}
+
+// TestMatrixRobo contains the robo test options
+type TestMatrixRobo struct {
+	InitialActivity string                `yaml:"initial_activity"`
+	MaxDepth        *int                  `yaml:"max_depth"`
+	MaxSteps        *int                  `yaml:"max_steps"`
+	ScenarioFile    string                `yaml:"scenario_file"`
+	Directives      []TestMatrixDirective `yaml:"directives"`
+}
+
+// TestMatrixDirective is a robo directive
+type TestMatrixDirective struct {
+	ResourceName string `yaml:"resource_name"`
+	InputText    string `yaml:"input_text"`
+	ActionType   string `yaml:"action_type"`
+}
+
+// TestMatrixGameLoop contains the game loop test options
+type TestMatrixGameLoop struct {
+	Scenarios []int    `yaml:"scenarios"`
+	Labels    []string `yaml:"labels"`
+}
+
+// TestMatrixSetup contains the test setup options
+type TestMatrixSetup struct {
+	EnvironmentVariables map[string]string `yaml:"environment_variables"`
+	DirectoriesToPull    []string          `yaml:"directories_to_pull"`
+	ObbFiles             []string          `yaml:"obb_files"`
+	AutoGoogleLogin      *bool             `yaml:"auto_google_login"`
+}
+
+// ConfigFileError is a validation error pointing to a field of the config file
+type ConfigFileError struct {
+	File  string
+	Line  int
+	Field string
+	Msg   string
+}
+
+func (e ConfigFileError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s: %s", e.File, e.Line, e.Field, e.Msg)
+}
+
+// parseTestMatrixConfigFile reads a YAML or JSON (a subset of YAML) test matrix config file.
+// Flank configs (with top level gcloud and flank keys) are converted to the test matrix config.
+func parseTestMatrixConfigFile(pth string) (TestMatrixConfig, error) {
+	data, err := os.ReadFile(pth)
+	if err != nil {
+		return TestMatrixConfig{}, fmt.Errorf("failed to read config file: %w", err)
+	}
+	return parseTestMatrixConfig(pth, data)
+}
+
+func parseTestMatrixConfig(name string, data []byte) (TestMatrixConfig, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return TestMatrixConfig{}, fmt.Errorf("%s: %s", name, err)
+	}
+	if len(root.Content) == 0 {
+		return TestMatrixConfig{}, fmt.Errorf("%s: config file is empty", name)
+	}
+
+	if isFlankConfig(root.Content[0]) {
+		config, notes, err := parseFlankConfig(name, root.Content[0])
+		if err != nil {
+			return TestMatrixConfig{}, err
+		}
+		config.notes = notes
+		if err := config.validate(name); err != nil {
+			return TestMatrixConfig{}, err
+		}
+		return config, nil
+	}
+
+	var config TestMatrixConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		return TestMatrixConfig{}, fmt.Errorf("%s: %s", name, err)
+	}
+	config.root = root.Content[0]
+
+	if err := config.validate(name); err != nil {
+		return TestMatrixConfig{}, err
+	}
+
+	return config, nil
+}
+
+// node returns the value node at the given path (mapping keys and sequence indexes) or the closest existing parent.
+func (c TestMatrixConfig) node(path ...interface{}) *yaml.Node {
+	node := c.root
+	for _, elem := range path {
+		var next *yaml.Node
+		switch key := elem.(type) {
+		case string:
+			if node.Kind == yaml.MappingNode {
+				for i := 0; i+1 < len(node.Content); i += 2 {
+					if node.Content[i].Value == key {
+						next = node.Content[i+1]
+					}
+				}
+			}
+		case int:
+			if node.Kind == yaml.SequenceNode && key < len(node.Content) {
+				next = node.Content[key]
+			}
+		}
+		if next == nil {
+			return node
+		}
+		node = next
+	}
+	return node
+}
+
+func (c TestMatrixConfig) errorf(file string, path []interface{}, format string, v ...interface{}) error {
+	var field strings.Builder
+	for _, elem := range path {
+		switch key := elem.(type) {
+		case string:
+			if field.Len() > 0 {
+				field.WriteString(".")
+			}
+			field.WriteString(key)
+		case int:
+			field.WriteString("[" + strconv.Itoa(key) + "]")
+		}
+	}
+
+	line := 0
+	if c.root != nil {
+		line = c.node(path...).Line
+	}
+
+	return ConfigFileError{File: file, Line: line, Field: field.String(), Msg: fmt.Sprintf(format, v...)}
+}
+
+func (c TestMatrixConfig) validate(file string) error {
+	if c.Type != "" && !slices.Contains([]string{testTypeInstrumentation, testTypeRobo, testTypeGameLoop}, c.Type) {
+		return c.errorf(file, []interface{}{"type"}, "should be one of instrumentation, robo, gameloop, got: %s", c.Type)
+	}
+
+	for i, device := range c.Devices {
+		for _, field := range []struct{ key, value string }{
+			{"model", device.Model},
+			{"version", device.Version},
+			{"locale", device.Locale},
+			{"orientation", device.Orientation},
+		} {
+			if strings.TrimSpace(field.value) == "" {
+				return c.errorf(file, []interface{}{"devices", i, field.key}, "required field is missing")
+			}
+			if strings.ContainsAny(field.value, ",\n") {
+				return c.errorf(file, []interface{}{"devices", i, field.key}, "should not contain commas or newlines")
+			}
+		}
+	}
+
+	if c.Timeout != nil && (*c.Timeout <= 0 || *c.Timeout > 3600) {
+		return c.errorf(file, []interface{}{"timeout"}, "should be in the ]0..3600] range, got: %v", *c.Timeout)
+	}
+	if c.FlakyTestAttempts != nil && (*c.FlakyTestAttempts < 0 || *c.FlakyTestAttempts > 10) {
+		return c.errorf(file, []interface{}{"flaky_test_attempts"}, "should be in the [0..10] range, got: %d", *c.FlakyTestAttempts)
+	}
+
+	if c.Instrumentation != nil {
+		for i, target := range c.Instrumentation.Targets {
+			if strings.TrimSpace(target) == "" || strings.ContainsAny(target, ",\n") {
+				return c.errorf(file, []interface{}{"instrumentation", "targets", i}, "should be a single, non-empty test target")
+			}
+			if _, err := parseTestTarget(target); err != nil {
+				return c.errorf(file, []interface{}{"instrumentation", "targets", i}, "%s", err)
+			}
+		}
+	}
+
+	if c.Robo != nil {
+		if c.Robo.MaxDepth != nil && *c.Robo.MaxDepth < 0 {
+			return c.errorf(file, []interface{}{"robo", "max_depth"}, "should not be negative")
+		}
+		if c.Robo.MaxSteps != nil && *c.Robo.MaxSteps < 0 {
+			return c.errorf(file, []interface{}{"robo", "max_steps"}, "should not be negative")
+		}
+		for i, directive := range c.Robo.Directives {
+			if strings.TrimSpace(directive.ResourceName) == "" {
+				return c.errorf(file, []interface{}{"robo", "directives", i, "resource_name"}, "required field is missing")
+			}
+			if strings.TrimSpace(directive.ActionType) == "" {
+				return c.errorf(file, []interface{}{"robo", "directives", i, "action_type"}, "required field is missing")
+			}
+			if err := validateRoboDirective(strings.TrimSpace(directive.ResourceName), directive.InputText, strings.TrimSpace(directive.ActionType)); err != nil {
+				return c.errorf(file, []interface{}{"robo", "directives", i}, "%s", err)
+			}
+		}
+	}
+
+	if c.GameLoop != nil {
+		for i, scenario := range c.GameLoop.Scenarios {
+			if scenario < 1 {
+				return c.errorf(file, []interface{}{"gameloop", "scenarios", i}, "should be a positive integer, got: %d", scenario)
+			}
+		}
+		for i, label := range c.GameLoop.Labels {
+			if strings.TrimSpace(label) == "" || strings.Contains(label, ",") {
+				return c.errorf(file, []interface{}{"gameloop", "labels", i}, "should be a single, non-empty label")
+			}
+		}
+	}
+
+	if c.Setup != nil {
+		for key, value := range c.Setup.EnvironmentVariables {
+			if key == "" || strings.ContainsAny(key, "=\n") || strings.Contains(value, "\n") {
+				return c.errorf(file, []interface{}{"setup", "environment_variables", key}, "keys should not contain '=' and values should be single line")
+			}
+		}
+	}
+
+	return nil
+}
+
+// applyTestMatrixConfig fills the inputs, which are not set explicitly (empty or left at their default value),
+// from the config file. Explicitly set inputs take precedence over the config file.
+func (configs *ConfigsModel) applyTestMatrixConfig(config TestMatrixConfig) {
+	setString := func(input *string, isDefault bool, value string) {
+		if value != "" && (strings.TrimSpace(*input) == "" || isDefault) {
+			*input = value
+		}
+	}
+	setBool := func(input *bool, value *bool) {
+		if value != nil && !*input {
+			*input = *value
+		}
+	}
+	setIntString := func(input *string, value *int) {
+		if value != nil && strings.TrimSpace(*input) == "" {
+			*input = strconv.Itoa(*value)
+		}
+	}
+
+	setString(&configs.TestType, configs.TestType == defaultTestType, config.Type)
+	setString(&configs.AppPath, false, config.App)
+	setString(&configs.TestApkPath, false, config.TestApk)
+
+	var devices []string
+	for _, device := range config.Devices {
+		devices = append(devices, strings.Join([]string{device.Model, device.Version, device.Locale, device.Orientation}, ","))
+	}
+	setString(&configs.TestDevicesList, strings.TrimSpace(configs.TestDevicesList) == defaultTestDevices, strings.Join(devices, "\n"))
+
+	if config.Timeout != nil && (configs.TestTimeout == 0 || configs.TestTimeout == defaultTestTimeout) {
+		configs.TestTimeout = *config.Timeout
+	}
+	if config.FlakyTestAttempts != nil && configs.FlakyTestAttempts == defaultFlakyTestAttempts {
+		configs.FlakyTestAttempts = *config.FlakyTestAttempts
+	}
+
+	if inst := config.Instrumentation; inst != nil {
+		setString(&configs.InstTestRunnerClass, false, inst.RunnerClass)
+		setString(&configs.InstTestTargets, false, strings.Join(inst.Targets, "\n"))
+		setBool(&configs.UseOrchestrator, inst.UseOrchestrator)
+	}
+
+	if robo := config.Robo; robo != nil {
+		setString(&configs.RoboInitialActivity, false, robo.InitialActivity)
+		setIntString(&configs.RoboMaxDepth, robo.MaxDepth)
+		setIntString(&configs.RoboMaxSteps, robo.MaxSteps)
+		setString(&configs.RoboScenarioFile, false, robo.ScenarioFile)
+
+		setString(&configs.RoboDirectives, false, roboDirectivesCSV(robo.Directives))
+	}
+
+	if loop := config.GameLoop; loop != nil {
+		var scenarios []string
+		for _, scenario := range loop.Scenarios {
+			scenarios = append(scenarios, strconv.Itoa(scenario))
+		}
+		setString(&configs.LoopScenarios, false, strings.Join(scenarios, ","))
+		setString(&configs.LoopScenarioLabels, false, strings.Join(loop.Labels, ","))
+	}
+
+	if setup := config.Setup; setup != nil {
+		keys := make([]string, 0, len(setup.EnvironmentVariables))
+		for key := range setup.EnvironmentVariables {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		var envs []string
+		for _, key := range keys {
+			envs = append(envs, key+"="+setup.EnvironmentVariables[key])
+		}
+		setString(&configs.EnvironmentVariablesList, false, strings.Join(envs, "\n"))
+		setString(&configs.DirectoriesToPullList, false, strings.Join(setup.DirectoriesToPull, "\n"))
+		setString(&configs.ObbFilesList, false, strings.Join(setup.ObbFiles, "\n"))
+		setBool(&configs.AutoGoogleLogin, setup.AutoGoogleLogin)
+	}
+}
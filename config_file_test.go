@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const sampleTestMatrixConfig = `type: instrumentation
+devices:
+  - model: MediumPhone.arm
+    version: 33
+    locale: en
+    orientation: portrait
+  - model: Pixel2.arm
+    version: "30"
+    locale: de
+    orientation: landscape
+timeout: 600
+flaky_test_attempts: 2
+instrumentation:
+  targets:
+    - class com.example.LoginTest
+    - package com.example.smoke
+  use_orchestrator: true
+setup:
+  environment_variables:
+    coverage: "true"
+    clearPackageData: "true"
+  directories_to_pull:
+    - /sdcard/screenshots
+`
+
+func TestParseTestMatrixConfig_Merge(t *testing.T) {
+	config, err := parseTestMatrixConfig("config.yml", []byte(sampleTestMatrixConfig))
+	if err != nil {
+		t.Fatalf("parseTestMatrixConfig() returned error: %v", err)
+	}
+
+	configs := ConfigsModel{
+		TestType:        defaultTestType,
+		TestDevicesList: defaultTestDevices,
+		TestTimeout:     defaultTestTimeout,
+		InstTestTargets: "class com.example.ExplicitTest",
+	}
+	configs.applyTestMatrixConfig(config)
+
+	if configs.TestType != testTypeInstrumentation {
+		t.Errorf("TestType = %s, want %s", configs.TestType, testTypeInstrumentation)
+	}
+	if want := "MediumPhone.arm,33,en,portrait\nPixel2.arm,30,de,landscape"; configs.TestDevicesList != want {
+		t.Errorf("TestDevicesList = %q, want %q", configs.TestDevicesList, want)
+	}
+	if configs.TestTimeout != 600 || configs.FlakyTestAttempts != 2 || !configs.UseOrchestrator {
+		t.Errorf("TestTimeout = %v, FlakyTestAttempts = %d, UseOrchestrator = %t", configs.TestTimeout, configs.FlakyTestAttempts, configs.UseOrchestrator)
+	}
+	if want := "class com.example.ExplicitTest"; configs.InstTestTargets != want {
+		t.Errorf("explicit InstTestTargets input was overridden: %q, want %q", configs.InstTestTargets, want)
+	}
+	if want := "clearPackageData=true\ncoverage=true"; configs.EnvironmentVariablesList != want {
+		t.Errorf("EnvironmentVariablesList = %q, want %q", configs.EnvironmentVariablesList, want)
+	}
+	if want := "/sdcard/screenshots"; configs.DirectoriesToPullList != want {
+		t.Errorf("DirectoriesToPullList = %q, want %q", configs.DirectoriesToPullList, want)
+	}
+}
+
+func TestParseTestMatrixConfig_ExplicitInputsTakePrecedence(t *testing.T) {
+	config, err := parseTestMatrixConfig("config.yml", []byte(sampleTestMatrixConfig))
+	if err != nil {
+		t.Fatalf("parseTestMatrixConfig() returned error: %v", err)
+	}
+
+	configs := ConfigsModel{
+		TestType:        testTypeGameLoop,
+		TestDevicesList: "SmallPhone.arm,34,en,portrait",
+		TestTimeout:     120,
+	}
+	configs.applyTestMatrixConfig(config)
+
+	if configs.TestType != testTypeGameLoop || configs.TestDevicesList != "SmallPhone.arm,34,en,portrait" || configs.TestTimeout != 120 {
+		t.Errorf("explicit inputs were overridden: %s, %q, %v", configs.TestType, configs.TestDevicesList, configs.TestTimeout)
+	}
+}
+
+func TestParseTestMatrixConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name:    "unknown field",
+			config:  "type: robo\ndevice:\n  - model: a\n",
+			wantErr: "line 2: field device not found",
+		},
+		{
+			name:    "invalid type",
+			config:  "devices: []\ntype: espresso\n",
+			wantErr: "config.yml:2: type: should be one of instrumentation, robo, gameloop, got: espresso",
+		},
+		{
+			name:    "missing device field",
+			config:  "devices:\n  - model: MediumPhone.arm\n    version: 33\n    locale: en\n  - model: Pixel2.arm\n    version: 30\n    locale: en\n",
+			wantErr: "config.yml:2: devices[0].orientation: required field is missing",
+		},
+		{
+			name:    "wrong value type",
+			config:  "flaky_test_attempts: many\n",
+			wantErr: "line 1: cannot unmarshal !!str `many` into int",
+		},
+		{
+			name:    "out of range",
+			config:  "type: robo\nrobo:\n  max_depth: -1\n",
+			wantErr: "config.yml:3: robo.max_depth: should not be negative",
+		},
+		{
+			name:    "invalid robo directive",
+			config:  "type: robo\nrobo:\n  directives:\n    - resource_name: login\n      action_type: CLICK\n",
+			wantErr: "config.yml:4: robo.directives[0]: invalid action type (CLICK) for login",
+		},
+		{
+			name:    "JSON config",
+			config:  "{\n  \"devices\": [\n    {\"model\": \"a\", \"version\": \"33\", \"locale\": \"en\", \"orientation\": \"\"}\n  ]\n}\n",
+			wantErr: "config.yml:3: devices[0].orientation: required field is missing",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseTestMatrixConfig("config.yml", []byte(tc.config))
+			if err == nil {
+				t.Fatalf("expected error containing %q, got nil", tc.wantErr)
+			}
+			if !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("error = %q, want it to contain %q", err.Error(), tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestInputDefaultsMatchStepYML(t *testing.T) {
+	content, err := os.ReadFile("step.yml")
+	if err != nil {
+		t.Fatalf("failed to read step.yml: %v", err)
+	}
+	var step struct {
+		Inputs []map[string]interface{} `yaml:"inputs"`
+	}
+	if err := yaml.Unmarshal(content, &step); err != nil {
+		t.Fatalf("failed to parse step.yml: %v", err)
+	}
+	defaults := map[string]string{}
+	for _, input := range step.Inputs {
+		for key, value := range input {
+			if key != "opts" {
+				defaults[key] = fmt.Sprint(value)
+			}
+		}
+	}
+
+	// applyTestMatrixConfig tells the explicitly set inputs apart by comparing them against these defaults
+	for input, want := range map[string]string{
+		"test_type":               defaultTestType,
+		"test_devices":            defaultTestDevices,
+		"num_flaky_test_attempts": strconv.Itoa(defaultFlakyTestAttempts),
+		"test_timeout":            strconv.Itoa(defaultTestTimeout),
+		"inst_use_orchestrator":   "false",
+		"auto_google_login":       "false",
+	} {
+		if got, ok := defaults[input]; !ok || got != want {
+			t.Errorf("step.yml default of %s = %q, want %q", input, got, want)
+		}
+	}
+}
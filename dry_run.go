@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// dryRunGcsPath is the placeholder bucket of the test assets in dry run mode, as no files are uploaded.
+const dryRunGcsPath = "gs://dry-run-placeholder/"
+
+// dryRunTestAssets returns the test assets as if they were uploaded, with placeholder GCS paths.
+func dryRunTestAssets(configs ConfigsModel) TestAssetsAndroid {
+	testAssets := requestedTestAssets(configs)
+	testAssets.isBundle = isAppBundle(configs.AppPath)
+
+	placeholder := func(asset *TestAsset) {
+		if asset.Filename != "" {
+			asset.GcsPath = dryRunGcsPath + asset.Filename
+		}
+	}
+	placeholder(&testAssets.Apk)
+	placeholder(&testAssets.Aab)
+	placeholder(&testAssets.TestApk)
+	placeholder(&testAssets.RoboScript)
+	for i := range testAssets.TestApks {
+		placeholder(&testAssets.TestApks[i])
+	}
+	for i := range testAssets.ObbFiles {
+		placeholder(&testAssets.ObbFiles[i])
+	}
+
+	if testAssets.isBundle {
+		testAssets.testApp = &testAssets.Aab
+	} else {
+		testAssets.testApp = &testAssets.Apk
+	}
+
+	return testAssets
+}
+
+// dryRunTestMatrixFileName returns the file name of a test run's test matrix: test_matrix.json for a single test run,
+// test_matrix_<test run name>.json otherwise.
+func dryRunTestMatrixFileName(run testRun) string {
+	if run.name == "" {
+		return "test_matrix.json"
+	}
+	return fmt.Sprintf("test_matrix_%s.json", run.name)
+}
+
+// writeDryRunTestMatrices builds the test matrices the step would start and writes them as JSON into the given directory.
+func writeDryRunTestMatrices(configs ConfigsModel, runs []testRun, dir string) ([]string, error) {
+	testAssets := dryRunTestAssets(configs)
+
+	var pths []string
+	for _, run := range runs {
+		testModel, err := newTestMatrix(configs, run, testAssets)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build %s test matrix: %w", run.displayName(), err)
+		}
+
+		data, err := json.MarshalIndent(maskedTestMatrix(testModel), "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal test matrix: %w", err)
+		}
+
+		pth := filepath.Join(dir, dryRunTestMatrixFileName(run))
+		if err := os.WriteFile(pth, data, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write test matrix: %w", err)
+		}
+		log.Debugf("%s test matrix: %s", run.displayName(), string(data))
+
+		pths = append(pths, pth)
+	}
+
+	return pths, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	testingapi "google.golang.org/api/testing/v1"
+)
+
+func TestWriteDryRunTestMatrices(t *testing.T) {
+	configs := ConfigsModel{
+		AppPath:           "app/build/outputs/bundle/debug/app-debug.aab",
+		TestTypes:         []string{testTypeInstrumentation, testTypeRobo},
+		TestApkPaths:      []string{"app-debug-androidTest.apk"},
+		TestDevices:       []*testingapi.AndroidDevice{{AndroidModelId: "MediumPhone.arm", AndroidVersionId: "33", Locale: "en", Orientation: "portrait"}},
+		TestTimeout:       900,
+		FlakyTestAttempts: 1,
+		TestTargets:       []string{"package com.example.smoke"},
+		RoboScenarioFile:  "robo_script.json",
+	}
+	runs := configs.testRuns()
+
+	dir := t.TempDir()
+	pths, err := writeDryRunTestMatrices(configs, runs, dir)
+	if err != nil {
+		t.Fatalf("writeDryRunTestMatrices() returned error: %v", err)
+	}
+
+	wantPths := []string{filepath.Join(dir, "test_matrix_instrumentation.json"), filepath.Join(dir, "test_matrix_robo.json")}
+	if !reflect.DeepEqual(pths, wantPths) {
+		t.Fatalf("writeDryRunTestMatrices() = %v, want %v", pths, wantPths)
+	}
+
+	data, err := os.ReadFile(pths[0])
+	if err != nil {
+		t.Fatalf("failed to read test matrix: %v", err)
+	}
+	var matrix testingapi.TestMatrix
+	if err := json.Unmarshal(data, &matrix); err != nil {
+		t.Fatalf("failed to unmarshal test matrix: %v", err)
+	}
+
+	instrumentation := matrix.TestSpecification.AndroidInstrumentationTest
+	if instrumentation == nil {
+		t.Fatalf("instrumentation test is missing from the test matrix: %s", data)
+	}
+	if got, want := instrumentation.AppBundle.BundleLocation.GcsPath, dryRunGcsPath+"app-debug.aab"; got != want {
+		t.Errorf("app bundle GcsPath = %s, want %s", got, want)
+	}
+	if got, want := instrumentation.TestApk.GcsPath, dryRunGcsPath+"app-debug-androidTest.apk"; got != want {
+		t.Errorf("test APK GcsPath = %s, want %s", got, want)
+	}
+	if want := []string{"package com.example.smoke"}; !reflect.DeepEqual(instrumentation.TestTargets, want) {
+		t.Errorf("TestTargets = %v, want %v", instrumentation.TestTargets, want)
+	}
+	if matrix.FlakyTestAttempts != 1 || len(matrix.EnvironmentMatrix.AndroidDeviceList.AndroidDevices) != 1 {
+		t.Errorf("unexpected test matrix: %s", data)
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// e2eRunStepEnv makes the test binary run the step instead of the tests, the e2e tests start the step this way.
+const e2eRunStepEnv = "VDTESTING_E2E_RUN_STEP"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(e2eRunStepEnv) == "1" {
+		statusPollInterval = 10 * time.Millisecond
+		validationTimeout = time.Second
+		apiRetryWait = time.Millisecond
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+type e2eResult struct {
+	exitCode int
+	output   string
+	// exports are the outputs the step exported with envman
+	exports map[string]string
+}
+
+// stepInputDefaults returns the default values of the step inputs, references to other env vars are left empty.
+func stepInputDefaults(t *testing.T) map[string]string {
+	data, err := os.ReadFile("step.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stepModel struct {
+		Inputs []map[string]interface{} `yaml:"inputs"`
+	}
+	if err := yaml.Unmarshal(data, &stepModel); err != nil {
+		t.Fatal(err)
+	}
+
+	defaults := map[string]string{}
+	for _, input := range stepModel.Inputs {
+		for key, value := range input {
+			if key == "opts" {
+				continue
+			}
+			defaults[key] = ""
+			if value != nil && !strings.HasPrefix(fmt.Sprint(value), "$") {
+				defaults[key] = fmt.Sprint(value)
+			}
+		}
+	}
+	return defaults
+}
+
+// runStep runs the step against the fake server with the step.yml default inputs, overridden by the given inputs.
+func runStep(t *testing.T, server *fakeVDTServer, inputs map[string]string) e2eResult {
+	dir := t.TempDir()
+
+	binDir := filepath.Join(dir, "bin")
+	exportsPth := filepath.Join(dir, "exports")
+	envman := "#!/bin/sh\nprintf '%s=%s\\n' \"$3\" \"$5\" >> \"" + exportsPth + "\"\n"
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(binDir, "envman"), []byte(envman), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	env := stepInputDefaults(t)
+	env["api_base_url"] = server.URL
+	env["api_token"] = fakeAPIToken
+	env["BITRISE_APP_SLUG"] = fakeAppSlug
+	env["BITRISE_BUILD_SLUG"] = fakeBuildSlug
+	env["BITRISE_DEPLOY_DIR"] = filepath.Join(dir, "deploy")
+	env["download_test_results"] = "true"
+	env["download_dir"] = filepath.Join(dir, "download")
+	for key, value := range inputs {
+		env[key] = value
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=^$")
+	cmd.Env = []string{
+		e2eRunStepEnv + "=1",
+		"PATH=" + binDir + string(os.PathListSeparator) + os.Getenv("PATH"),
+		"HOME=" + dir,
+		"TMPDIR=" + dir,
+	}
+	for key, value := range env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+
+	var result e2eResult
+	out, err := cmd.CombinedOutput()
+	result.output = string(out)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatalf("failed to run the step: %v", err)
+	}
+
+	result.exports = map[string]string{}
+	if f, err := os.Open(exportsPth); err == nil {
+		defer func() { _ = f.Close() }()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if key, value, ok := strings.Cut(scanner.Text(), "="); ok {
+				result.exports[key] = value
+			}
+		}
+	}
+
+	return result
+}
+
+func TestE2E(t *testing.T) {
+	if testing.Short() {
+		t.Skip("e2e tests run the step binary")
+	}
+
+	burst := func(scenario fakeVDTScenario, count int) fakeVDTScenario {
+		scenario.statusErrorBurst = count
+		return scenario
+	}
+
+	tests := []struct {
+		name         string
+		scenario     fakeVDTScenario
+		wantExitCode int
+		wantOutput   []string
+		wantExports  []string
+		wantReason   string
+	}{
+		{
+			name:         "success",
+			scenario:     fakeScenarioSuccess,
+			wantExitCode: 0,
+			wantOutput:   []string{"- Validating", "=> Test finished", "1 merged test results XML(s) found", "=> 2 test Assets downloaded"},
+			wantExports:  []string{"VDTESTING_DOWNLOADED_FILES_DIR", logcatReportEnvID},
+		},
+		{
+			name:         "test failure",
+			scenario:     fakeScenarioTestFailure,
+			wantExitCode: 1,
+			wantOutput:   []string{"failure(Crashed)", "1 test run(s) failed"},
+			wantReason:   failureReasonTestFailure,
+		},
+		{
+			name:         "infrastructure error",
+			scenario:     fakeScenarioInfrastructureError,
+			wantExitCode: 1,
+			wantOutput:   []string{"inconclusive(InfrastructureFailure)", "1 test run(s) failed"},
+			wantReason:   failureReasonInfrastructure,
+		},
+		{
+			name:         "stuck validation",
+			scenario:     fakeScenarioStuckValidation,
+			wantExitCode: 1,
+			wantOutput:   []string{"- Validating", "Test matrix validation did not finish in 1s"},
+			wantReason:   failureReasonInfrastructure,
+		},
+		{
+			name:         "single 5xx response",
+			scenario:     burst(fakeScenarioSuccess, 1),
+			wantExitCode: 0,
+			wantOutput:   []string{"=> Test finished"},
+		},
+		{
+			name:         "5xx burst within the retries",
+			scenario:     burst(fakeScenarioSuccess, apiMaxRetries),
+			wantExitCode: 0,
+			wantOutput:   []string{"=> Test finished"},
+		},
+		{
+			name:         "5xx burst",
+			scenario:     burst(fakeScenarioSuccess, apiMaxRetries+1),
+			wantExitCode: 1,
+			wantOutput:   []string{"Failed to get robo test status", "upstream unavailable"},
+			wantReason:   failureReasonAPI,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeVDTServer(tt.scenario)
+			defer server.Close()
+
+			result := runStep(t, server, nil)
+			if result.exitCode != tt.wantExitCode {
+				t.Errorf("exit code = %d, want %d, output:\n%s", result.exitCode, tt.wantExitCode, result.output)
+			}
+			for _, want := range tt.wantOutput {
+				if !strings.Contains(result.output, want) {
+					t.Errorf("output does not contain %q, output:\n%s", want, result.output)
+				}
+			}
+			if got := result.exports[failureReasonEnvID]; got != tt.wantReason {
+				t.Errorf("%s = %q, want %q", failureReasonEnvID, got, tt.wantReason)
+			}
+			for _, key := range tt.wantExports {
+				if _, ok := result.exports[key]; !ok {
+					t.Errorf("%s is not exported, exports: %v", key, result.exports)
+				}
+			}
+
+			if _, ok := server.uploads["app.apk"]; !ok {
+				t.Errorf("the app is not uploaded, requests: %v", server.requests)
+			}
+			if server.matrices[""] == nil || server.matrices[""].TestSpecification.AndroidRoboTest == nil {
+				t.Fatalf("no robo test matrix started, requests: %v", server.requests)
+			}
+			if got, want := server.matrices[""].TestSpecification.AndroidRoboTest.AppApk.GcsPath, "gs://fake-bucket/app.apk"; got != want {
+				t.Errorf("app GCS path = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestE2E_APITokenInHeader(t *testing.T) {
+	if testing.Short() {
+		t.Skip("e2e tests run the step binary")
+	}
+
+	server := newFakeVDTServer(fakeScenarioSuccess)
+	defer server.Close()
+
+	result := runStep(t, server, map[string]string{"api_token_in_header": "true", "use_verbose_log": "true"})
+	if result.exitCode != 0 {
+		t.Fatalf("exit code = %d, output:\n%s", result.exitCode, result.output)
+	}
+
+	for _, request := range server.requests {
+		if strings.Contains(request, fakeAPIToken) {
+			t.Errorf("the API token is part of the request path: %s", request)
+		}
+	}
+	if strings.Contains(result.output, fakeAPIToken) || strings.Contains(result.output, "X-Goog-Signature") {
+		t.Errorf("output contains a secret, output:\n%s", result.output)
+	}
+}
+
+func TestE2E_MultipleTestRuns(t *testing.T) {
+	if testing.Short() {
+		t.Skip("e2e tests run the step binary")
+	}
+
+	server := newFakeVDTServer(fakeScenarioSuccess)
+	defer server.Close()
+
+	// one instrumentation test run per module, and a robo test run
+	const runner = "androidx.test.runner.AndroidJUnitRunner"
+	var testApkPaths []string
+	for _, module := range []string{"login", "cart"} {
+		pth := writeTestApk(t, "com.example."+module+".test", runner, "com.example."+module+".test", map[string]string{runner: "android.app.Instrumentation"})
+		modulePth := filepath.Join(filepath.Dir(pth), module+"-debug-androidTest.apk")
+		if err := os.Rename(pth, modulePth); err != nil {
+			t.Fatal(err)
+		}
+		testApkPaths = append(testApkPaths, modulePth)
+	}
+
+	downloadDir := filepath.Join(t.TempDir(), "results")
+	result := runStep(t, server, map[string]string{
+		"test_type":           "instrumentation,robo",
+		"test_apk_path_list":  strings.Join(testApkPaths, "|"),
+		"download_dir":        downloadDir,
+		"api_token_in_header": "true",
+	})
+	if result.exitCode != 0 {
+		t.Fatalf("exit code = %d, output:\n%s", result.exitCode, result.output)
+	}
+
+	runs := []string{"login-debug", "cart-debug", testTypeRobo}
+	if len(server.matrices) != len(runs) {
+		t.Fatalf("started test matrices = %d, want %d, requests: %v", len(server.matrices), len(runs), server.requests)
+	}
+	for _, run := range runs {
+		matrix := server.matrices[run]
+		if matrix == nil {
+			t.Errorf("no %s test matrix started, requests: %v", run, server.requests)
+			continue
+		}
+		if isRobo := matrix.TestSpecification.AndroidRoboTest != nil; isRobo != (run == testTypeRobo) {
+			t.Errorf("%s test matrix is a robo test: %t", run, isRobo)
+		}
+		if polls := server.statusPolls[run]; polls <= fakeScenarioSuccess.validatingPolls+fakeScenarioSuccess.runningPolls {
+			t.Errorf("%s test matrix status polls = %d, want the test matrix to finish", run, polls)
+		}
+		for name := range fakeScenarioSuccess.files {
+			if _, err := os.Stat(filepath.Join(downloadDir, run, "MediumPhone.arm-33-en-portrait", name)); err != nil {
+				t.Errorf("%s of the %s test run is not downloaded: %v", name, run, err)
+			}
+		}
+	}
+	if login, cart := server.matrices["login-debug"], server.matrices["cart-debug"]; login != nil && cart != nil &&
+		login.TestSpecification.AndroidInstrumentationTest.TestApk.GcsPath == cart.TestSpecification.AndroidInstrumentationTest.TestApk.GcsPath {
+		t.Errorf("the module test runs share the test APK %s", login.TestSpecification.AndroidInstrumentationTest.TestApk.GcsPath)
+	}
+}
+
+func TestE2E_RoboDirectiveSecret(t *testing.T) {
+	if testing.Short() {
+		t.Skip("e2e tests run the step binary")
+	}
+
+	// Bitrise expands the inputs before the step starts, unless is_expand is false: the step has to get the reference,
+	// otherwise a secret starting with $ is expanded twice and one containing a comma breaks the CSV.
+	data, err := os.ReadFile("step.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stepModel struct {
+		Inputs []map[string]interface{} `yaml:"inputs"`
+	}
+	if err := yaml.Unmarshal(data, &stepModel); err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range stepModel.Inputs {
+		if _, ok := input["robo_directives"]; !ok {
+			continue
+		}
+		opts, _ := input["opts"].(map[string]interface{})
+		if isExpand, ok := opts["is_expand"].(bool); !ok || isExpand {
+			t.Errorf("robo_directives input opts is_expand = %v, want false", opts["is_expand"])
+		}
+	}
+
+	server := newFakeVDTServer(fakeScenarioSuccess)
+	defer server.Close()
+
+	secret := `$HOME,"pa$$word`
+	result := runStep(t, server, map[string]string{
+		"robo_directives": "password_resource,$LOGIN_PASSWORD,ENTER_TEXT",
+		"LOGIN_PASSWORD":  secret,
+	})
+	if result.exitCode != 0 {
+		t.Fatalf("exit code = %d, output:\n%s", result.exitCode, result.output)
+	}
+
+	directives := server.matrices[""].TestSpecification.AndroidRoboTest.RoboDirectives
+	if len(directives) != 1 || directives[0].InputText != secret {
+		t.Errorf("robo directives = %+v, want the secret as the input text", directives)
+	}
+	if strings.Contains(result.output, secret) {
+		t.Errorf("output contains the secret, output:\n%s", result.output)
+	}
+}
+
+func TestE2E_RoboDirectiveReferences(t *testing.T) {
+	if testing.Short() {
+		t.Skip("e2e tests run the step binary")
+	}
+
+	rejected := fakeScenarioSuccess
+	rejected.rejectTestMatrix = true
+
+	tests := []struct {
+		name          string
+		scenario      fakeVDTScenario
+		inputs        map[string]string
+		wantExitCode  int
+		wantInputText string
+		// wantHidden must not be in the output
+		wantHidden string
+	}{
+		{
+			name:          "inline reference",
+			scenario:      fakeScenarioSuccess,
+			inputs:        map[string]string{"robo_directives": "username_resource,user_$BUILD_NUMBER,ENTER_TEXT", "BUILD_NUMBER": "42"},
+			wantInputText: "user_42",
+		},
+		{
+			// the name does not look like a secret, the value is masked because it is used as an input text
+			name:          "secret echoed by the API",
+			scenario:      rejected,
+			inputs:        map[string]string{"robo_directives": "pin_resource,$LOGIN_PIN_CODE,ENTER_TEXT", "LOGIN_PIN_CODE": "pin-983271"},
+			wantExitCode:  1,
+			wantInputText: "pin-983271",
+			wantHidden:    "pin-983271",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeVDTServer(tt.scenario)
+			defer server.Close()
+
+			result := runStep(t, server, tt.inputs)
+			if result.exitCode != tt.wantExitCode {
+				t.Fatalf("exit code = %d, want %d, output:\n%s", result.exitCode, tt.wantExitCode, result.output)
+			}
+
+			directives := server.matrices[""].TestSpecification.AndroidRoboTest.RoboDirectives
+			if len(directives) != 1 || directives[0].InputText != tt.wantInputText {
+				t.Errorf("robo directives = %+v, want %s input text", directives, tt.wantInputText)
+			}
+			if tt.wantHidden != "" && (strings.Contains(result.output, tt.wantHidden) || strings.Contains(result.exports[failureMessageEnvID], tt.wantHidden)) {
+				t.Errorf("output contains %s, output:\n%s", tt.wantHidden, result.output)
+			}
+		})
+	}
+}
+
+func TestE2E_DownloadedAssets(t *testing.T) {
+	if testing.Short() {
+		t.Skip("e2e tests run the step binary")
+	}
+
+	server := newFakeVDTServer(fakeScenarioSuccess)
+	defer server.Close()
+
+	downloadDir := filepath.Join(t.TempDir(), "results")
+	result := runStep(t, server, map[string]string{"download_dir": downloadDir, "num_flaky_test_attempts": "2"})
+	if result.exitCode != 0 {
+		t.Fatalf("exit code = %d, output:\n%s", result.exitCode, result.output)
+	}
+
+	if got, want := server.matrices[""].FlakyTestAttempts, int64(2); got != want {
+		t.Errorf("FlakyTestAttempts = %d, want %d", got, want)
+	}
+	if got := result.exports["VDTESTING_DOWNLOADED_FILES_DIR"]; got != downloadDir {
+		t.Errorf("VDTESTING_DOWNLOADED_FILES_DIR = %s, want %s", got, downloadDir)
+	}
+	for name, content := range fakeScenarioSuccess.files {
+		data, err := os.ReadFile(filepath.Join(downloadDir, "MediumPhone.arm-33-en-portrait", name))
+		if err != nil {
+			t.Errorf("%s is not downloaded: %v", name, err)
+		} else if string(data) != content {
+			t.Errorf("%s content = %q, want %q", name, data, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(downloadDir, assetManifestFileName)); err != nil {
+		t.Errorf("asset manifest is not written: %v", err)
+	}
+}
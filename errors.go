@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	failureReasonEnvID  = "VDTESTING_FAILURE_REASON"
+	failureMessageEnvID = "VDTESTING_FAILURE_MESSAGE"
+
+	// maxFailureMessageLength keeps the failure message output short enough for notifications
+	maxFailureMessageLength = 500
+)
+
+// Failure reasons exported in VDTESTING_FAILURE_REASON
+const (
+	// failureReasonConfig: the inputs are invalid
+	failureReasonConfig = "config_error"
+	// failureReasonUpload: the app or test files could not be uploaded
+	failureReasonUpload = "upload_error"
+	// failureReasonAPI: a test API request (start, status, result listing) failed
+	failureReasonAPI = "api_error"
+	// failureReasonInvalidTestMatrix: the test backend rejected the test matrix, for example because of an invalid app or test APK
+	failureReasonInvalidTestMatrix = "invalid_test_matrix"
+	// failureReasonInfrastructure: the tests could not run because of an infrastructure problem on the devices or in validation
+	failureReasonInfrastructure = "infrastructure_error"
+	// failureReasonTestFailure: a test run failed
+	failureReasonTestFailure = "test_failure"
+	// failureReasonThresholdExceeded: the tests passed, but a performance or accessibility threshold is exceeded
+	failureReasonThresholdExceeded = "threshold_exceeded"
+	// failureReasonDownload: the test results could not be downloaded
+	failureReasonDownload = "download_error"
+	// failureReasonInternal: any other error of the step
+	failureReasonInternal = "internal_error"
+)
+
+// errInvalidTestMatrix is wrapped by the test backends if the test matrix is rejected
+var errInvalidTestMatrix = errors.New("invalid test matrix")
+
+// configError is returned if the inputs are invalid
+type configError struct {
+	err error
+}
+
+func (e *configError) Error() string {
+	return e.err.Error()
+}
+
+func (e *configError) Unwrap() error {
+	return e.err
+}
+
+// stepError is a failure of the step with its reason
+type stepError struct {
+	reason string
+	err    error
+}
+
+func newStepError(reason string, format string, v ...interface{}) *stepError {
+	return &stepError{reason: reason, err: fmt.Errorf(format, v...)}
+}
+
+func (e *stepError) Error() string {
+	return e.err.Error()
+}
+
+func (e *stepError) Unwrap() error {
+	return e.err
+}
+
+// testFailureError is returned if the tests ran but a test run failed or a threshold is exceeded, the outputs are still exported
+type testFailureError struct {
+	failedTestRuns []string
+	// infrastructureFailures are the failed test runs where every attempt was inconclusive because of an infrastructure failure
+	infrastructureFailures []string
+	thresholdViolations    []string
+}
+
+func (e *testFailureError) Error() string {
+	var messages []string
+	messages = append(messages, e.thresholdViolations...)
+	if len(e.failedTestRuns) > 0 {
+		messages = append(messages, fmt.Sprintf("%d test run(s) failed", len(e.failedTestRuns)))
+	} else if len(e.thresholdViolations) > 0 {
+		messages = append(messages, fmt.Sprintf("%d threshold(s) exceeded", len(e.thresholdViolations)))
+	}
+	return strings.Join(messages, "\n")
+}
+
+// reason is infrastructure_error only if all failed test runs failed because of the infrastructure, real test failures take precedence.
+func (e *testFailureError) reason() string {
+	switch {
+	case len(e.failedTestRuns) > 0 && len(e.infrastructureFailures) == len(e.failedTestRuns):
+		return failureReasonInfrastructure
+	case len(e.failedTestRuns) > 0:
+		return failureReasonTestFailure
+	default:
+		return failureReasonThresholdExceeded
+	}
+}
+
+// failureReason classifies the error of the step.
+func failureReason(err error) string {
+	var configErr *configError
+	var failure *testFailureError
+	var stepErr *stepError
+	switch {
+	case errors.As(err, &configErr):
+		return failureReasonConfig
+	case errors.As(err, &failure):
+		return failure.reason()
+	case errors.As(err, &stepErr):
+		return stepErr.reason
+	default:
+		return failureReasonInternal
+	}
+}
+
+// failureMessage returns the redacted error message on a single line, shortened to maxFailureMessageLength characters.
+func failureMessage(err error) string {
+	message := strings.Join(strings.Fields(logOutput.redact(err.Error())), " ")
+	if runes := []rune(message); len(runes) > maxFailureMessageLength {
+		message = string(runes[:maxFailureMessageLength-3]) + "..."
+	}
+	return message
+}
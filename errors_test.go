@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFailureReason(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "config error",
+			err:  &configError{err: errors.New("- AppPath: required")},
+			want: failureReasonConfig,
+		},
+		{
+			name: "step error",
+			err:  newStepError(failureReasonUpload, "Failed to upload test assets, error: %s", errors.New("EOF")),
+			want: failureReasonUpload,
+		},
+		{
+			name: "invalid test matrix",
+			err:  newStepError(apiFailureReason(fmt.Errorf("%w: NO_SIGNATURE", errInvalidTestMatrix)), "Starting test run failed"),
+			want: failureReasonInvalidTestMatrix,
+		},
+		{
+			name: "api error",
+			err:  newStepError(apiFailureReason(errors.New("502 Bad Gateway")), "Failed to get test status"),
+			want: failureReasonAPI,
+		},
+		{
+			name: "test failure",
+			err:  &testFailureError{failedTestRuns: []string{"a", "b"}, infrastructureFailures: []string{"a"}},
+			want: failureReasonTestFailure,
+		},
+		{
+			name: "infrastructure failure only",
+			err:  &testFailureError{failedTestRuns: []string{"a"}, infrastructureFailures: []string{"a"}},
+			want: failureReasonInfrastructure,
+		},
+		{
+			name: "threshold exceeded",
+			err:  &testFailureError{thresholdViolations: []string{"peak memory exceeded"}},
+			want: failureReasonThresholdExceeded,
+		},
+		{
+			name: "unclassified error",
+			err:  errors.New("unexpected"),
+			want: failureReasonInternal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := failureReason(tt.err); got != tt.want {
+				t.Errorf("failureReason() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailureMessage(t *testing.T) {
+	if got, want := failureMessage(errors.New("peak memory exceeded\n1 test run(s) failed")), "peak memory exceeded 1 test run(s) failed"; got != want {
+		t.Errorf("failureMessage() = %q, want %q", got, want)
+	}
+
+	got := failureMessage(errors.New(strings.Repeat("a", 2*maxFailureMessageLength)))
+	if len(got) != maxFailureMessageLength || !strings.HasSuffix(got, "...") {
+		t.Errorf("failureMessage() = %q (%d characters), want %d characters ending with ...", got, len(got), maxFailureMessageLength)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	testingapi "google.golang.org/api/testing/v1"
+	toolresults "google.golang.org/api/toolresults/v1beta3"
+)
+
+const (
+	fakeAppSlug   = "app-slug"
+	fakeBuildSlug = "build-slug"
+	fakeAPIToken  = "api-token"
+)
+
+// fakeVDTScenario scripts the answers of the fake Virtual Device Testing API.
+type fakeVDTScenario struct {
+	// validatingPolls is the number of status polls answered without steps, -1 never finishes the validation
+	validatingPolls int
+	// runningPolls is the number of status polls answered with running steps after the validation
+	runningPolls int
+	// statusErrorBurst is the number of 502 responses the first status polls get
+	statusErrorBurst int
+	// outcome is the outcome of the finished steps
+	outcome *toolresults.Outcome
+	// files are the result files of the test run, keyed by the Firebase generated file name
+	files map[string]string
+	// rejectTestMatrix answers the test run start with a 400 echoing the test matrix, like an API validation error
+	rejectTestMatrix bool
+}
+
+var (
+	fakeScenarioSuccess = fakeVDTScenario{
+		validatingPolls: 2,
+		runningPolls:    2,
+		outcome:         &toolresults.Outcome{Summary: "success"},
+		files: map[string]string{
+			"MediumPhone.arm-33-en-portrait_test_results_merged.xml": `<testsuite name="robo" tests="1" failures="0"></testsuite>`,
+			"MediumPhone.arm-33-en-portrait_logcat":                  "01-01 00:00:00.000  1000  1000 I ActivityManager: Start proc",
+		},
+	}
+	fakeScenarioTestFailure = fakeVDTScenario{
+		validatingPolls: 1,
+		runningPolls:    1,
+		outcome:         &toolresults.Outcome{Summary: "failure", FailureDetail: &toolresults.FailureDetail{Crashed: true}},
+	}
+	fakeScenarioInfrastructureError = fakeVDTScenario{
+		validatingPolls: 1,
+		outcome:         &toolresults.Outcome{Summary: "inconclusive", InconclusiveDetail: &toolresults.InconclusiveDetail{InfrastructureFailure: true}},
+	}
+	fakeScenarioStuckValidation = fakeVDTScenario{
+		validatingPolls: -1,
+	}
+)
+
+// fakeVDTServer is an in-memory Virtual Device Testing API serving the asset, start, status and download endpoints.
+// Every test run (identified by the run query parameter, empty for a single test matrix) gets its own test matrix,
+// all of them answered according to the scenario.
+type fakeVDTServer struct {
+	*httptest.Server
+	scenario fakeVDTScenario
+
+	mu          sync.Mutex
+	uploads     map[string][]byte
+	matrices    map[string]*testingapi.TestMatrix
+	statusPolls map[string]int
+	errorBurst  int
+	requests    []string
+}
+
+func newFakeVDTServer(scenario fakeVDTScenario) *fakeVDTServer {
+	s := &fakeVDTServer{
+		scenario:    scenario,
+		uploads:     map[string][]byte{},
+		matrices:    map[string]*testingapi.TestMatrix{},
+		statusPolls: map[string]int{},
+		errorBurst:  scenario.statusErrorBurst,
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+func (s *fakeVDTServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.RequestURI())
+	run := r.URL.Query().Get("run")
+
+	// the token is either sent in the Authorization header or it is the last element of the path
+	testRunPath := "/" + fakeAppSlug + "/" + fakeBuildSlug
+	if r.Header.Get("Authorization") != "Bearer "+fakeAPIToken {
+		testRunPath += "/" + fakeAPIToken
+	}
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/assets/android"+testRunPath:
+		s.requestUploadURLs(w, r)
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/upload/"):
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.uploads[strings.TrimPrefix(r.URL.Path, "/upload/")] = data
+	case r.Method == http.MethodPost && r.URL.Path == testRunPath:
+		matrix := &testingapi.TestMatrix{}
+		if err := json.NewDecoder(r.Body).Decode(matrix); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.matrices[run] = matrix
+		if s.scenario.rejectTestMatrix {
+			data, _ := json.Marshal(matrix)
+			http.Error(w, "invalid test matrix: "+string(data), http.StatusBadRequest)
+			return
+		}
+	case r.Method == http.MethodGet && r.URL.Path == testRunPath:
+		s.status(w, run)
+	case r.Method == http.MethodGet && r.URL.Path == "/assets"+testRunPath:
+		if s.matrices[run] == nil {
+			http.Error(w, "test matrix is not started", http.StatusNotFound)
+			return
+		}
+		assets := map[string]string{}
+		for name := range s.scenario.files {
+			assets[name] = s.URL + "/download/" + name
+		}
+		writeFakeJSON(w, assets)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/download/"):
+		content, ok := s.scenario.files[strings.TrimPrefix(r.URL.Path, "/download/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	default:
+		http.Error(w, "unexpected request: "+r.Method+" "+r.URL.Path, http.StatusNotFound)
+	}
+}
+
+func (s *fakeVDTServer) requestUploadURLs(w http.ResponseWriter, r *http.Request) {
+	var assets TestAssetsAndroid
+	if err := json.NewDecoder(r.Body).Decode(&assets); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	setURLs := func(asset *TestAsset) {
+		if asset.Filename == "" {
+			return
+		}
+		asset.UploadURL = s.URL + "/upload/" + asset.Filename + "?X-Goog-Algorithm=GOOG4-RSA-SHA256&X-Goog-Credential=vdt%40example.iam.gserviceaccount.com&X-Goog-Signature=0123abcd"
+		asset.GcsPath = "gs://fake-bucket/" + asset.Filename
+	}
+	setURLs(&assets.Apk)
+	setURLs(&assets.Aab)
+	setURLs(&assets.TestApk)
+	setURLs(&assets.RoboScript)
+	for i := range assets.TestApks {
+		setURLs(&assets.TestApks[i])
+	}
+	for i := range assets.ObbFiles {
+		setURLs(&assets.ObbFiles[i])
+	}
+
+	writeFakeJSON(w, assets)
+}
+
+func (s *fakeVDTServer) status(w http.ResponseWriter, run string) {
+	if s.errorBurst > 0 {
+		s.errorBurst--
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+		return
+	}
+	matrix := s.matrices[run]
+	if matrix == nil {
+		http.Error(w, "test matrix is not started", http.StatusNotFound)
+		return
+	}
+
+	s.statusPolls[run]++
+	polls := s.statusPolls[run]
+	if s.scenario.validatingPolls < 0 || polls <= s.scenario.validatingPolls {
+		writeFakeJSON(w, toolresults.ListStepsResponse{})
+		return
+	}
+
+	var steps []*toolresults.Step
+	for i, device := range matrix.EnvironmentMatrix.AndroidDeviceList.AndroidDevices {
+		step := &toolresults.Step{
+			StepId: "step-" + string(rune('a'+i)),
+			State:  "inProgress",
+			DimensionValue: []*toolresults.StepDimensionValueEntry{
+				{Key: "Model", Value: device.AndroidModelId},
+				{Key: "Version", Value: device.AndroidVersionId},
+				{Key: "Locale", Value: device.Locale},
+				{Key: "Orientation", Value: device.Orientation},
+			},
+		}
+		if polls > s.scenario.validatingPolls+s.scenario.runningPolls {
+			step.State = "complete"
+			step.Outcome = s.scenario.outcome
+		}
+		steps = append(steps, step)
+	}
+	writeFakeJSON(w, toolresults.ListStepsResponse{Steps: steps})
+}
+
+func writeFakeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
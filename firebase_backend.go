@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	testing "google.golang.org/api/testing/v1"
+	toolresults "google.golang.org/api/toolresults/v1beta3"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+	storageBaseURL     = "https://storage.googleapis.com"
+
+	// firebaseResultsRoot is the object prefix the test assets and results of the builds are stored under in the results bucket
+	firebaseResultsRoot = "bitrise-vdtesting"
+	// accessibilityLocale is the locale of the accessibility finding descriptions
+	accessibilityLocale = "en"
+)
+
+// test matrix states, see https://firebase.google.com/docs/test-lab/reference/testing/rest/v1/projects.testMatrices#TestState
+const (
+	testMatrixStateError   = "ERROR"
+	testMatrixStateInvalid = "INVALID"
+)
+
+// serviceAccountKey contains the fields of a service account JSON key the step checks
+type serviceAccountKey struct {
+	Type        string `json:"type"`
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+}
+
+// readServiceAccountKey reads the service account JSON key, the value is either the key itself or the path of the key file.
+func readServiceAccountKey(value string) ([]byte, serviceAccountKey, error) {
+	data := []byte(strings.TrimSpace(value))
+	if !strings.HasPrefix(string(data), "{") {
+		var err error
+		if data, err = os.ReadFile(strings.TrimPrefix(string(data), "file://")); err != nil {
+			return nil, serviceAccountKey{}, fmt.Errorf("failed to read the key file: %s", err)
+		}
+	}
+
+	var key serviceAccountKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, serviceAccountKey{}, fmt.Errorf("invalid JSON key: %s", err)
+	}
+	if key.Type != "service_account" {
+		return nil, serviceAccountKey{}, fmt.Errorf("should be a service account JSON key, got a key of type (%s)", key.Type)
+	}
+	return data, key, nil
+}
+
+// validateFirebaseBackend reads the service account key and the results bucket of the Firebase backend.
+func (configs *ConfigsModel) validateFirebaseBackend() error {
+	if strings.TrimSpace(configs.ServiceAccountKey) == "" {
+		return fmt.Errorf("- ServiceAccountKey: required variable is not present")
+	}
+	keyJSON, key, err := readServiceAccountKey(configs.ServiceAccountKey)
+	if err != nil {
+		return fmt.Errorf("- ServiceAccountKey: %s", err)
+	}
+	configs.ServiceAccountKeyJSON = keyJSON
+	configs.ServiceAccountEmail = key.ClientEmail
+
+	if configs.GCPProjectID = strings.TrimSpace(configs.GCPProjectID); configs.GCPProjectID == "" {
+		if key.ProjectID == "" {
+			return fmt.Errorf("- GCPProjectID: required variable is not present and the service account key has no project_id")
+		}
+		configs.GCPProjectID = key.ProjectID
+	}
+
+	configs.ResultsBucket = strings.Trim(strings.TrimPrefix(strings.TrimSpace(configs.ResultsBucket), "gs://"), "/")
+	if configs.ResultsBucket == "" {
+		return fmt.Errorf("- ResultsBucket: required variable is not present")
+	}
+	if strings.Contains(configs.ResultsBucket, "/") {
+		return fmt.Errorf("- ResultsBucket: should be a bucket name, got: %s", configs.ResultsBucket)
+	}
+
+	return nil
+}
+
+// firebaseBackend runs the test matrices in the user's GCP project with the Firebase Test Lab API, the test assets and
+// results are stored in the results bucket under bitrise-vdtesting/<build slug>.
+type firebaseBackend struct {
+	configs    ConfigsModel
+	projectID  string
+	bucket     string
+	dir        string
+	storageURL string
+
+	client      *http.Client
+	testing     *testing.Service
+	toolresults *toolresults.Service
+
+	// matrixIDs maps the test run names to the started test matrices
+	matrixIDs map[string]string
+	// stepExecutions maps the step IDs to their tool results execution, for the per step API calls
+	stepExecutions map[string]*testing.ToolResultsExecution
+}
+
+func newFirebaseBackend(configs ConfigsModel, baseClient *http.Client) (*firebaseBackend, error) {
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, baseClient)
+	credentials, err := google.CredentialsFromJSON(ctx, configs.ServiceAccountKeyJSON, cloudPlatformScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the service account key: %s", err)
+	}
+	return newFirebaseBackendWithClient(configs, oauth2.NewClient(ctx, credentials.TokenSource), storageBaseURL)
+}
+
+// newFirebaseBackendWithClient creates the backend with an authenticated client, the API endpoints can be overridden by the options.
+func newFirebaseBackendWithClient(configs ConfigsModel, client *http.Client, storageURL string, opts ...option.ClientOption) (*firebaseBackend, error) {
+	ctx := context.Background()
+	opts = append([]option.ClientOption{option.WithHTTPClient(client)}, opts...)
+
+	testingService, err := testing.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Test Lab client: %s", err)
+	}
+	toolresultsService, err := toolresults.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Tool Results client: %s", err)
+	}
+
+	return &firebaseBackend{
+		configs:        configs,
+		projectID:      configs.GCPProjectID,
+		bucket:         configs.ResultsBucket,
+		dir:            firebaseResultsRoot + "/" + configs.BuildSlug,
+		storageURL:     strings.TrimSuffix(storageURL, "/"),
+		client:         client,
+		testing:        testingService,
+		toolresults:    toolresultsService,
+		matrixIDs:      map[string]string{},
+		stepExecutions: map[string]*testing.ToolResultsExecution{},
+	}, nil
+}
+
+// objectURL returns the Cloud Storage XML API URL of an object in the results bucket, used for both upload and download.
+func (b *firebaseBackend) objectURL(object string) string {
+	return b.storageURL + (&url.URL{Path: "/" + b.bucket + "/" + object}).EscapedPath()
+}
+
+// resultsDir returns the object prefix Test Lab writes the results of the test run to.
+func (b *firebaseBackend) resultsDir(run testRun) string {
+	dir := b.dir + "/results"
+	if run.name != "" {
+		dir += "/" + run.name
+	}
+	return dir
+}
+
+func (b *firebaseBackend) UploadTestAssets() (TestAssetsAndroid, error) {
+	testAssets := requestedTestAssets(b.configs)
+	testAssets.isBundle = isAppBundle(b.configs.AppPath)
+	if testAssets.isBundle {
+		testAssets.testApp = &testAssets.Aab
+	} else {
+		testAssets.testApp = &testAssets.Apk
+	}
+
+	type assetUpload struct {
+		asset *TestAsset
+		pth   string
+	}
+	uploads := []assetUpload{{testAssets.testApp, b.configs.AppPath}}
+	for i := range testAssets.TestApks {
+		uploads = append(uploads, assetUpload{&testAssets.TestApks[i], b.configs.TestApkPaths[i]})
+	}
+	if testAssets.TestApk.Filename != "" {
+		uploads = append(uploads, assetUpload{&testAssets.TestApk, b.configs.TestApkPaths[0]})
+	}
+	if testAssets.RoboScript.Filename != "" {
+		uploads = append(uploads, assetUpload{&testAssets.RoboScript, b.configs.RoboScenarioFile})
+	}
+	for i := range testAssets.ObbFiles {
+		uploads = append(uploads, assetUpload{&testAssets.ObbFiles[i], b.configs.ObbFiles[i]})
+	}
+
+	for i, upload := range uploads {
+		// the file names are kept, as Test Lab pushes the obb files with their names, the index keeps same named files apart
+		object := fmt.Sprintf("%s/assets/%d/%s", b.dir, i, upload.asset.Filename)
+		upload.asset.GcsPath = "gs://" + b.bucket + "/" + object
+		upload.asset.UploadURL = b.objectURL(object)
+
+		log.Debugf("Uploading file(%s) to (%s)", upload.pth, upload.asset.GcsPath)
+		if err := uploadFile(b.client, upload.asset.UploadURL, upload.pth); err != nil {
+			return TestAssetsAndroid{}, fmt.Errorf("failed to upload file(%s) to (%s), error: %s", upload.pth, upload.asset.GcsPath, err)
+		}
+	}
+
+	return testAssets, nil
+}
+
+func (b *firebaseBackend) StartTestRun(run testRun, testAssets TestAssetsAndroid) error {
+	testModel, err := newTestMatrix(b.configs, run, testAssets)
+	if err != nil {
+		return err
+	}
+	testModel.ProjectId = b.projectID
+	testModel.ClientInfo = &testing.ClientInfo{Name: "Bitrise"}
+	testModel.ResultStorage = &testing.ResultStorage{
+		GoogleCloudStorage: &testing.GoogleCloudStorage{GcsPath: "gs://" + b.bucket + "/" + b.resultsDir(run) + "/"},
+	}
+
+	matrix, err := b.testing.Projects.TestMatrices.Create(b.projectID, testModel).Do()
+	if err != nil {
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusBadRequest {
+			return fmt.Errorf("%w: %s", errInvalidTestMatrix, err)
+		}
+		return fmt.Errorf("failed to create test matrix, error: %s", err)
+	}
+	log.Debugf("Test matrix (%s) created, results: %s", matrix.TestMatrixId, testModel.ResultStorage.GoogleCloudStorage.GcsPath)
+
+	b.matrixIDs[run.name] = matrix.TestMatrixId
+	return nil
+}
+
+// GetTestRunSteps returns the steps of the test matrix's tool results execution, no steps are returned while the matrix is validated.
+func (b *firebaseBackend) GetTestRunSteps(run testRun) (*toolresults.ListStepsResponse, error) {
+	matrixID, ok := b.matrixIDs[run.name]
+	if !ok {
+		return nil, fmt.Errorf("test matrix is not started")
+	}
+
+	matrix, err := b.testing.Projects.TestMatrices.Get(b.projectID, matrixID).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get test matrix (%s), error: %s", matrixID, err)
+	}
+	switch matrix.State {
+	case testMatrixStateInvalid:
+		return nil, fmt.Errorf("test matrix (%s) is invalid: %w: %s", matrixID, errInvalidTestMatrix, matrix.InvalidMatrixDetails)
+	case testMatrixStateError:
+		return nil, fmt.Errorf("test matrix (%s) failed to run", matrixID)
+	}
+
+	responseModel := &toolresults.ListStepsResponse{}
+	if matrix.ResultStorage == nil || matrix.ResultStorage.ToolResultsExecution == nil || matrix.ResultStorage.ToolResultsExecution.ExecutionId == "" {
+		return responseModel, nil
+	}
+
+	execution := matrix.ResultStorage.ToolResultsExecution
+	call := b.toolresults.Projects.Histories.Executions.Steps.List(execution.ProjectId, execution.HistoryId, execution.ExecutionId)
+	if err := call.Pages(context.Background(), func(page *toolresults.ListStepsResponse) error {
+		responseModel.Steps = append(responseModel.Steps, page.Steps...)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to list test matrix (%s) steps, error: %s", matrixID, err)
+	}
+
+	for _, step := range responseModel.Steps {
+		b.stepExecutions[step.StepId] = execution
+	}
+	return responseModel, nil
+}
+
+// storageObjects is the object list response of the Cloud Storage JSON API
+type storageObjects struct {
+	Items []struct {
+		Name string `json:"name"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+// GetTestRunAssets lists the result files of the test run. The files are named like the test API names them, the path
+// inside the results directory joined by underscores: MediumPhone.arm-33-en-portrait/test_result_1.xml -> MediumPhone.arm-33-en-portrait_test_result_1.xml
+func (b *firebaseBackend) GetTestRunAssets(run testRun) (map[string]string, error) {
+	prefix := b.resultsDir(run) + "/"
+	assets := map[string]string{}
+
+	pageToken := ""
+	for {
+		query := url.Values{"prefix": {prefix}, "fields": {"items(name),nextPageToken"}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		resp, err := b.client.Get(b.storageURL + "/storage/v1/b/" + url.PathEscape(b.bucket) + "/o?" + query.Encode())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get http response, error: %s", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err := resp.Body.Close(); err != nil {
+			log.Debugf("Failed to close response body: %s", err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response body, error: %s", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to list result files: %d, error: %s", resp.StatusCode, string(body))
+		}
+
+		var objects storageObjects
+		if err := json.Unmarshal(body, &objects); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal response body, error: %s", err)
+		}
+		for _, object := range objects.Items {
+			// folder placeholder objects
+			if strings.HasSuffix(object.Name, "/") {
+				continue
+			}
+			name := strings.ReplaceAll(strings.TrimPrefix(object.Name, prefix), "/", "_")
+			assets[name] = b.objectURL(object.Name)
+		}
+
+		if pageToken = objects.NextPageToken; pageToken == "" {
+			return assets, nil
+		}
+	}
+}
+
+func (b *firebaseBackend) stepExecution(stepID string) (*testing.ToolResultsExecution, error) {
+	execution, ok := b.stepExecutions[stepID]
+	if !ok {
+		return nil, fmt.Errorf("unknown step")
+	}
+	return execution, nil
+}
+
+// GetPerfMetrics returns the performance metrics of the step, the steps are keyed by their execution, so the test run is not needed.
+func (b *firebaseBackend) GetPerfMetrics(_ testRun, stepID string) (PerfMetrics, error) {
+	execution, err := b.stepExecution(stepID)
+	if err != nil {
+		return PerfMetrics{}, err
+	}
+	steps := b.toolresults.Projects.Histories.Executions.Steps
+
+	summary, err := steps.GetPerfMetricsSummary(execution.ProjectId, execution.HistoryId, execution.ExecutionId, stepID).Do()
+	if err != nil {
+		return PerfMetrics{}, fmt.Errorf("failed to get performance metrics summary, error: %s", err)
+	}
+	perfMetrics := PerfMetrics{Summary: summary}
+
+	seriesList, err := steps.PerfSampleSeries.List(execution.ProjectId, execution.HistoryId, execution.ExecutionId, stepID).Do()
+	if err != nil {
+		return PerfMetrics{}, fmt.Errorf("failed to list performance sample series, error: %s", err)
+	}
+	for _, series := range seriesList.PerfSampleSeries {
+		sampleSeries := PerfSampleSeries{Series: series}
+		call := steps.PerfSampleSeries.Samples.List(execution.ProjectId, execution.HistoryId, execution.ExecutionId, stepID, series.SampleSeriesId)
+		if err := call.Pages(context.Background(), func(page *toolresults.ListPerfSamplesResponse) error {
+			sampleSeries.Samples = append(sampleSeries.Samples, page.PerfSamples...)
+			return nil
+		}); err != nil {
+			return PerfMetrics{}, fmt.Errorf("failed to list performance samples of series (%s), error: %s", series.SampleSeriesId, err)
+		}
+		perfMetrics.SampleSeries = append(perfMetrics.SampleSeries, sampleSeries)
+	}
+
+	return perfMetrics, nil
+}
+
+func (b *firebaseBackend) GetAccessibilityClusters(_ testRun, stepID string) (*toolresults.ListStepAccessibilityClustersResponse, error) {
+	execution, err := b.stepExecution(stepID)
+	if err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("projects/%s/histories/%s/executions/%s/steps/%s", execution.ProjectId, execution.HistoryId, execution.ExecutionId, stepID)
+	clusters, err := b.toolresults.Projects.Histories.Executions.Steps.AccessibilityClusters(name).Locale(accessibilityLocale).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get accessibility clusters, error: %s", err)
+	}
+	return clusters, nil
+}
+
+// DownloadClient returns the authenticated client, the result files are not public.
+func (b *firebaseBackend) DownloadClient() *http.Client {
+	return b.client
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/option"
+	testingapi "google.golang.org/api/testing/v1"
+	toolresults "google.golang.org/api/toolresults/v1beta3"
+)
+
+func TestValidateFirebaseBackend(t *testing.T) {
+	const key = `{"type": "service_account", "project_id": "key-project", "client_email": "vdt@key-project.iam.gserviceaccount.com"}`
+	keyPth := filepath.Join(t.TempDir(), "key.json")
+	if err := os.WriteFile(keyPth, []byte(key), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		configs     ConfigsModel
+		wantProject string
+		wantBucket  string
+		wantErr     string
+	}{
+		{
+			name:        "key content",
+			configs:     ConfigsModel{ServiceAccountKey: key, ResultsBucket: "gs://results/"},
+			wantProject: "key-project",
+			wantBucket:  "results",
+		},
+		{
+			name:        "key file and project",
+			configs:     ConfigsModel{ServiceAccountKey: keyPth, GCPProjectID: "other-project", ResultsBucket: "results"},
+			wantProject: "other-project",
+			wantBucket:  "results",
+		},
+		{
+			name:    "missing key",
+			configs: ConfigsModel{ResultsBucket: "results"},
+			wantErr: "- ServiceAccountKey: required variable is not present",
+		},
+		{
+			name:    "not a service account key",
+			configs: ConfigsModel{ServiceAccountKey: `{"type": "authorized_user"}`, ResultsBucket: "results"},
+			wantErr: "should be a service account JSON key, got a key of type (authorized_user)",
+		},
+		{
+			name:    "missing bucket",
+			configs: ConfigsModel{ServiceAccountKey: key},
+			wantErr: "- ResultsBucket: required variable is not present",
+		},
+		{
+			name:    "bucket with path",
+			configs: ConfigsModel{ServiceAccountKey: key, ResultsBucket: "gs://results/dir"},
+			wantErr: "- ResultsBucket: should be a bucket name, got: results/dir",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configs := tt.configs
+			err := configs.validateFirebaseBackend()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("validateFirebaseBackend() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateFirebaseBackend() returned error: %v", err)
+			}
+			if configs.GCPProjectID != tt.wantProject || configs.ResultsBucket != tt.wantBucket {
+				t.Errorf("project: %s, bucket: %s, want project: %s, bucket: %s", configs.GCPProjectID, configs.ResultsBucket, tt.wantProject, tt.wantBucket)
+			}
+		})
+	}
+}
+
+// fakeFirebase serves the Test Lab, Tool Results and Cloud Storage endpoints used by the Firebase backend
+type fakeFirebase struct {
+	mu          sync.Mutex
+	objects     map[string]string
+	matrix      *testingapi.TestMatrix
+	matrixGets  int
+	stepsListed bool
+}
+
+func (f *fakeFirebase) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/bucket/"):
+		data, _ := io.ReadAll(r.Body)
+		f.objects[strings.TrimPrefix(r.URL.Path, "/bucket/")] = string(data)
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/projects/project/testMatrices":
+		f.matrix = &testingapi.TestMatrix{}
+		if err := json.NewDecoder(r.Body).Decode(f.matrix); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.matrix.TestMatrixId = "matrix-1"
+		f.matrix.State = "VALIDATING"
+		_ = json.NewEncoder(w).Encode(f.matrix)
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/projects/project/testMatrices/matrix-1":
+		f.matrixGets++
+		if f.matrixGets > 1 {
+			f.matrix.State = "RUNNING"
+			f.matrix.ResultStorage.ToolResultsExecution = &testingapi.ToolResultsExecution{ProjectId: "project", HistoryId: "history", ExecutionId: "execution"}
+		}
+		_ = json.NewEncoder(w).Encode(f.matrix)
+	case r.Method == http.MethodGet && r.URL.Path == "/toolresults/v1beta3/projects/project/histories/history/executions/execution/steps":
+		f.stepsListed = true
+		_ = json.NewEncoder(w).Encode(toolresults.ListStepsResponse{Steps: []*toolresults.Step{{StepId: "step-1", State: "complete"}}})
+	case r.Method == http.MethodGet && r.URL.Path == "/storage/v1/b/bucket/o":
+		var objects storageObjects
+		for name := range f.objects {
+			if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+				objects.Items = append(objects.Items, struct {
+					Name string `json:"name"`
+				}{Name: name})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(objects)
+	default:
+		http.Error(w, "unexpected request: "+r.Method+" "+r.URL.Path, http.StatusNotFound)
+	}
+}
+
+func TestFirebaseBackend(t *testing.T) {
+	dir := t.TempDir()
+	appPth := filepath.Join(dir, "app.apk")
+	obbPth := filepath.Join(dir, "main.1.com.example.app.obb")
+	for _, pth := range []string{appPth, obbPth} {
+		if err := os.WriteFile(pth, []byte(filepath.Base(pth)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fake := &fakeFirebase{objects: map[string]string{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	configs := ConfigsModel{
+		BuildSlug:     "build",
+		GCPProjectID:  "project",
+		ResultsBucket: "bucket",
+		AppPath:       appPth,
+		ObbFiles:      []string{obbPth},
+		TestTypes:     []string{testTypeRobo},
+		TestTimeout:   900,
+	}
+	backend, err := newFirebaseBackendWithClient(configs, server.Client(), server.URL, option.WithEndpoint(server.URL+"/"))
+	if err != nil {
+		t.Fatalf("newFirebaseBackendWithClient() returned error: %v", err)
+	}
+
+	testAssets, err := backend.UploadTestAssets()
+	if err != nil {
+		t.Fatalf("UploadTestAssets() returned error: %v", err)
+	}
+	wantObjects := map[string]string{
+		"bitrise-vdtesting/build/assets/0/app.apk":                    "app.apk",
+		"bitrise-vdtesting/build/assets/1/main.1.com.example.app.obb": "main.1.com.example.app.obb",
+	}
+	if !reflect.DeepEqual(fake.objects, wantObjects) {
+		t.Errorf("uploaded objects = %v, want %v", fake.objects, wantObjects)
+	}
+
+	run := configs.testRuns()[0]
+	if err := backend.StartTestRun(run, testAssets); err != nil {
+		t.Fatalf("StartTestRun() returned error: %v", err)
+	}
+	if got, want := fake.matrix.TestSpecification.AndroidRoboTest.AppApk.GcsPath, "gs://bucket/bitrise-vdtesting/build/assets/0/app.apk"; got != want {
+		t.Errorf("app GCS path = %s, want %s", got, want)
+	}
+	if got, want := fake.matrix.TestSpecification.TestSetup.FilesToPush[0].ObbFile.ObbFileName, "main.1.com.example.app.obb"; got != want {
+		t.Errorf("obb file name = %s, want %s", got, want)
+	}
+	if got, want := fake.matrix.ResultStorage.GoogleCloudStorage.GcsPath, "gs://bucket/bitrise-vdtesting/build/results/"; got != want {
+		t.Errorf("results GCS path = %s, want %s", got, want)
+	}
+
+	steps, err := backend.GetTestRunSteps(run)
+	if err != nil {
+		t.Fatalf("GetTestRunSteps() returned error: %v", err)
+	}
+	if len(steps.Steps) != 0 || fake.stepsListed {
+		t.Errorf("steps of a validating matrix = %d, listed: %t, want none", len(steps.Steps), fake.stepsListed)
+	}
+	steps, err = backend.GetTestRunSteps(run)
+	if err != nil {
+		t.Fatalf("GetTestRunSteps() returned error: %v", err)
+	}
+	if len(steps.Steps) != 1 || steps.Steps[0].StepId != "step-1" {
+		t.Errorf("steps of a running matrix = %+v, want step-1", steps.Steps)
+	}
+
+	fake.objects["bitrise-vdtesting/build/results/MediumPhone.arm-33-en-portrait/test_result_1.xml"] = "<testsuite/>"
+	fake.objects["bitrise-vdtesting/build/results/MediumPhone.arm-33-en-portrait/"] = ""
+	assets, err := backend.GetTestRunAssets(run)
+	if err != nil {
+		t.Fatalf("GetTestRunAssets() returned error: %v", err)
+	}
+	wantAssets := map[string]string{
+		"MediumPhone.arm-33-en-portrait_test_result_1.xml": server.URL + "/bucket/bitrise-vdtesting/build/results/MediumPhone.arm-33-en-portrait/test_result_1.xml",
+	}
+	if !reflect.DeepEqual(assets, wantAssets) {
+		t.Errorf("GetTestRunAssets() = %v, want %v", assets, wantAssets)
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Flank config reference: https://flank.github.io/flank/
+//
+// Only the keys, which have an equivalent step input, are mapped, the rest is reported as ignored or unsupported.
+
+// flankIgnoredKeys are Flank keys which are valid, but have no effect on Bitrise (mostly result storage and reporting options).
+var flankIgnoredKeys = map[string]string{
+	"async":                               "the step always waits for the test results",
+	"client-details":                      "not used by the test API",
+	"network-profile":                     "not supported by the test API",
+	"performance-metrics":                 "use the collect_perf_metrics input instead",
+	"record-video":                        "videos are always recorded",
+	"results-bucket":                      "results are stored by the test API",
+	"results-dir":                         "results are stored by the test API",
+	"results-history-name":                "results are stored by the test API",
+	"fail-fast":                           "not supported by the test API",
+	"test-timeout":                        "use timeout instead",
+	"project":                             "the Firebase project is managed by the test API",
+	"local-result-dir":                    "use the download_dir input instead",
+	"output-style":                        "not applicable",
+	"full-junit-result":                   "merged JUnit results are always downloaded",
+	"legacy-junit-result":                 "merged JUnit results are always downloaded",
+	"ignore-failed-tests":                 "not supported",
+	"output-report":                       "not applicable",
+	"skip-config-validation":              "not applicable",
+	"run-timeout":                         "not applicable",
+	"smart-flank-gcs-path":                "smart sharding is not supported",
+	"smart-flank-disable-upload":          "smart sharding is not supported",
+	"default-test-time":                   "smart sharding is not supported",
+	"default-class-test-time":             "smart sharding is not supported",
+	"use-average-test-time-for-new-tests": "smart sharding is not supported",
+	"disable-results-upload":              "not applicable",
+	"disable-usage-statistics":            "not applicable",
+	"keep-file-path":                      "downloaded files are placed in per device directories",
+	"files-to-download":                   "use download_test_results to download every file",
+}
+
+// flankUnsupportedKeys are Flank keys which change the test run, but have no equivalent in the step.
+var flankUnsupportedKeys = map[string]string{
+	"max-test-shards":          "test sharding is not supported",
+	"num-uniform-shards":       "test sharding is not supported",
+	"test-targets-for-shard":   "test sharding is not supported",
+	"shard-time":               "test sharding is not supported",
+	"disable-sharding":         "test sharding is not supported",
+	"num-test-runs":            "repeated test runs are not supported, use num_flaky_test_attempts",
+	"test-targets-always-run":  "not supported",
+	"additional-apks":          "additional APKs are not supported",
+	"additional-app-test-apks": "additional test APKs are not supported",
+	"other-files":              "pushing files to the device is only supported for OBB files",
+	"grant-permissions":        "not supported by the test API",
+	"resign":                   "not supported",
+}
+
+var flankRoboActionTypes = map[string]string{
+	"text":   "ENTER_TEXT",
+	"click":  "SINGLE_CLICK",
+	"ignore": "IGNORE",
+}
+
+// isFlankConfig reports whether the document is a Flank config (with top level gcloud and/or flank keys).
+func isFlankConfig(root *yaml.Node) bool {
+	if root.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if key := root.Content[i].Value; key == "gcloud" || key == "flank" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFlankDuration parses Flank's timeout format: 30m, 1h, 90s or plain seconds.
+func parseFlankDuration(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return seconds, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration: %s", value)
+	}
+	return duration.Seconds(), nil
+}
+
+type flankConverter struct {
+	file   string
+	config TestMatrixConfig
+	notes  []string
+}
+
+func (c *flankConverter) errorf(node *yaml.Node, field, format string, v ...interface{}) error {
+	return ConfigFileError{File: c.file, Line: node.Line, Field: field, Msg: fmt.Sprintf(format, v...)}
+}
+
+func (c *flankConverter) decode(node *yaml.Node, field string, v interface{}) error {
+	if err := node.Decode(v); err != nil {
+		return c.errorf(node, field, "%s", strings.TrimPrefix(err.Error(), "yaml: unmarshal errors:\n  "))
+	}
+	return nil
+}
+
+// parseFlankConfig converts a Flank config into the step's test matrix config. The returned notes list
+// the Flank keys which are ignored or not supported by the step.
+func parseFlankConfig(file string, root *yaml.Node) (TestMatrixConfig, []string, error) {
+	converter := flankConverter{file: file}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case "gcloud":
+			if err := converter.convertGcloud(value); err != nil {
+				return TestMatrixConfig{}, nil, err
+			}
+		case "flank":
+			if err := converter.convertFlank(value); err != nil {
+				return TestMatrixConfig{}, nil, err
+			}
+		default:
+			converter.notes = append(converter.notes, fmt.Sprintf("%s:%d: %s: unknown top level key, ignored", file, key.Line, key.Value))
+		}
+	}
+
+	return converter.config, converter.notes, nil
+}
+
+func (c *flankConverter) convertGcloud(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return c.errorf(node, "gcloud", "should be a mapping")
+	}
+
+	config := &c.config
+	instrumentation := func() *TestMatrixInstrumentation {
+		if config.Instrumentation == nil {
+			config.Instrumentation = &TestMatrixInstrumentation{}
+		}
+		return config.Instrumentation
+	}
+	robo := func() *TestMatrixRobo {
+		if config.Robo == nil {
+			config.Robo = &TestMatrixRobo{}
+		}
+		return config.Robo
+	}
+	gameLoop := func() *TestMatrixGameLoop {
+		if config.GameLoop == nil {
+			config.GameLoop = &TestMatrixGameLoop{}
+		}
+		return config.GameLoop
+	}
+	setup := func() *TestMatrixSetup {
+		if config.Setup == nil {
+			config.Setup = &TestMatrixSetup{}
+		}
+		return config.Setup
+	}
+
+	var testType string
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		field := "gcloud." + key.Value
+
+		var err error
+		switch key.Value {
+		case "app":
+			err = c.decode(value, field, &config.App)
+		case "test":
+			err = c.decode(value, field, &config.TestApk)
+		case "type":
+			err = c.decode(value, field, &testType)
+			if err == nil {
+				switch testType {
+				case "instrumentation", "robo":
+				case "game-loop":
+					testType = testTypeGameLoop
+				default:
+					return c.errorf(value, field, "should be one of instrumentation, robo, game-loop, got: %s", testType)
+				}
+				config.Type = testType
+			}
+		case "device":
+			err = c.convertDevices(value, field)
+		case "timeout":
+			var timeout string
+			if err = c.decode(value, field, &timeout); err == nil {
+				seconds, parseErr := parseFlankDuration(timeout)
+				if parseErr != nil {
+					return c.errorf(value, field, "%s", parseErr)
+				}
+				config.Timeout = &seconds
+			}
+		case "num-flaky-test-attempts":
+			err = c.decode(value, field, &config.FlakyTestAttempts)
+		case "test-targets":
+			err = c.decode(value, field, &instrumentation().Targets)
+		case "test-runner-class":
+			err = c.decode(value, field, &instrumentation().RunnerClass)
+		case "use-orchestrator":
+			err = c.decode(value, field, &instrumentation().UseOrchestrator)
+		case "robo-directives":
+			err = c.convertRoboDirectives(value, field, robo())
+		case "robo-script":
+			err = c.decode(value, field, &robo().ScenarioFile)
+		case "scenario-numbers":
+			var scenarios []string
+			if err = c.decode(value, field, &scenarios); err == nil {
+				for j, scenario := range scenarios {
+					number, parseErr := strconv.Atoi(scenario)
+					if parseErr != nil {
+						return c.errorf(value.Content[j], fmt.Sprintf("%s[%d]", field, j), "should be an integer, got: %s", scenario)
+					}
+					gameLoop().Scenarios = append(gameLoop().Scenarios, number)
+				}
+			}
+		case "scenario-labels":
+			err = c.decode(value, field, &gameLoop().Labels)
+		case "environment-variables":
+			err = c.decode(value, field, &setup().EnvironmentVariables)
+		case "directories-to-pull":
+			err = c.decode(value, field, &setup().DirectoriesToPull)
+		case "obb-files":
+			err = c.decode(value, field, &setup().ObbFiles)
+		case "auto-google-login":
+			err = c.decode(value, field, &setup().AutoGoogleLogin)
+		case "app-package", "test-package":
+			c.notes = append(c.notes, fmt.Sprintf("%s:%d: %s: ignored, the package is extracted from the manifest", c.file, key.Line, field))
+		default:
+			c.note(key, field)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Flank runs instrumentation tests if a test APK is given, otherwise a Robo test.
+	if config.Type == "" {
+		config.Type = testTypeRobo
+		if config.TestApk != "" {
+			config.Type = testTypeInstrumentation
+		}
+	}
+
+	return nil
+}
+
+func (c *flankConverter) convertFlank(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return c.errorf(node, "flank", "should be a mapping")
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		c.note(key, "flank."+key.Value)
+	}
+
+	return nil
+}
+
+func (c *flankConverter) note(key *yaml.Node, field string) {
+	if reason, ok := flankIgnoredKeys[key.Value]; ok {
+		c.notes = append(c.notes, fmt.Sprintf("%s:%d: %s: ignored, %s", c.file, key.Line, field, reason))
+		return
+	}
+	if reason, ok := flankUnsupportedKeys[key.Value]; ok {
+		c.notes = append(c.notes, fmt.Sprintf("%s:%d: %s: unsupported, %s", c.file, key.Line, field, reason))
+		return
+	}
+	c.notes = append(c.notes, fmt.Sprintf("%s:%d: %s: unsupported key", c.file, key.Line, field))
+}
+
+func (c *flankConverter) convertDevices(node *yaml.Node, field string) error {
+	if node.Kind != yaml.SequenceNode {
+		return c.errorf(node, field, "should be a list of devices")
+	}
+
+	for i, deviceNode := range node.Content {
+		deviceField := fmt.Sprintf("%s[%d]", field, i)
+
+		var device map[string]string
+		if err := c.decode(deviceNode, deviceField, &device); err != nil {
+			return err
+		}
+
+		converted := TestMatrixDevice{
+			Model:       device["model"],
+			Version:     device["version"],
+			Locale:      device["locale"],
+			Orientation: device["orientation"],
+		}
+		// Flank defaults, the model and the version are required, as Flank's default device is not available as a virtual device.
+		if converted.Locale == "" {
+			converted.Locale = "en"
+		}
+		if converted.Orientation == "" {
+			converted.Orientation = "portrait"
+		}
+		if converted.Model == "" {
+			return c.errorf(deviceNode, deviceField+".model", "required field is missing")
+		}
+		if converted.Version == "" {
+			return c.errorf(deviceNode, deviceField+".version", "required field is missing")
+		}
+
+		var unknownKeys []string
+		for key := range device {
+			switch key {
+			case "model", "version", "locale", "orientation":
+			default:
+				unknownKeys = append(unknownKeys, key)
+			}
+		}
+		sort.Strings(unknownKeys)
+		for _, key := range unknownKeys {
+			c.notes = append(c.notes, fmt.Sprintf("%s:%d: %s.%s: unsupported key", c.file, deviceNode.Line, deviceField, key))
+		}
+
+		c.config.Devices = append(c.config.Devices, converted)
+	}
+
+	return nil
+}
+
+// convertRoboDirectives converts Flank's robo directives (a map of `action:resource_name` keys and input text values).
+func (c *flankConverter) convertRoboDirectives(node *yaml.Node, field string, robo *TestMatrixRobo) error {
+	if node.Kind != yaml.MappingNode {
+		return c.errorf(node, field, "should be a mapping of `action:resource_name` keys and input text values")
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		action, resourceName, found := strings.Cut(key.Value, ":")
+		actionType, ok := flankRoboActionTypes[action]
+		if !found || !ok || resourceName == "" {
+			return c.errorf(key, field+"."+key.Value, "should be in the `text:resource_name`, `click:resource_name` or `ignore:resource_name` format")
+		}
+
+		robo.Directives = append(robo.Directives, TestMatrixDirective{
+			ResourceName: resourceName,
+			InputText:    value.Value,
+			ActionType:   actionType,
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sampleFlankConfig = `gcloud:
+  app: ./app-debug.apk
+  test: ./app-debug-androidTest.apk
+  device:
+    - model: MediumPhone.arm
+      version: 33
+    - model: Pixel2.arm
+      version: 30
+      locale: de
+      orientation: landscape
+  timeout: 10m
+  num-flaky-test-attempts: 1
+  test-targets:
+    - class com.example.LoginTest
+  use-orchestrator: true
+  environment-variables:
+    clearPackageData: true
+  record-video: true
+  results-bucket: my-bucket
+flank:
+  max-test-shards: 4
+  project: my-project
+  some-new-key: 1
+`
+
+func TestParseTestMatrixConfig_Flank(t *testing.T) {
+	config, err := parseTestMatrixConfig("flank.yml", []byte(sampleFlankConfig))
+	if err != nil {
+		t.Fatalf("parseTestMatrixConfig() returned error: %v", err)
+	}
+
+	wantNotes := []string{
+		"flank.yml:18: gcloud.record-video: ignored, videos are always recorded",
+		"flank.yml:19: gcloud.results-bucket: ignored, results are stored by the test API",
+		"flank.yml:21: flank.max-test-shards: unsupported, test sharding is not supported",
+		"flank.yml:22: flank.project: ignored, the Firebase project is managed by the test API",
+		"flank.yml:23: flank.some-new-key: unsupported key",
+	}
+	if !reflect.DeepEqual(config.notes, wantNotes) {
+		t.Errorf("notes = %#v, want %#v", config.notes, wantNotes)
+	}
+
+	configs := ConfigsModel{
+		TestType:        defaultTestType,
+		TestDevicesList: defaultTestDevices,
+		TestTimeout:     defaultTestTimeout,
+	}
+	configs.applyTestMatrixConfig(config)
+
+	if configs.TestType != testTypeInstrumentation {
+		t.Errorf("TestType = %s, want %s", configs.TestType, testTypeInstrumentation)
+	}
+	if want := "MediumPhone.arm,33,en,portrait\nPixel2.arm,30,de,landscape"; configs.TestDevicesList != want {
+		t.Errorf("TestDevicesList = %q, want %q", configs.TestDevicesList, want)
+	}
+	if configs.TestTimeout != 600 || configs.FlakyTestAttempts != 1 || !configs.UseOrchestrator {
+		t.Errorf("TestTimeout = %v, FlakyTestAttempts = %d, UseOrchestrator = %t", configs.TestTimeout, configs.FlakyTestAttempts, configs.UseOrchestrator)
+	}
+	if want := "class com.example.LoginTest"; configs.InstTestTargets != want {
+		t.Errorf("InstTestTargets = %q, want %q", configs.InstTestTargets, want)
+	}
+	if want := "clearPackageData=true"; configs.EnvironmentVariablesList != want {
+		t.Errorf("EnvironmentVariablesList = %q, want %q", configs.EnvironmentVariablesList, want)
+	}
+}
+
+func TestParseTestMatrixConfig_FlankRobo(t *testing.T) {
+	config, err := parseTestMatrixConfig("flank.yml", []byte(`gcloud:
+  app: ./app.apk
+  robo-directives:
+    text:username_field: alice
+    click:login_button: ""
+`))
+	if err != nil {
+		t.Fatalf("parseTestMatrixConfig() returned error: %v", err)
+	}
+
+	if config.Type != testTypeRobo {
+		t.Errorf("Type = %s, want %s", config.Type, testTypeRobo)
+	}
+	want := []TestMatrixDirective{
+		{ResourceName: "username_field", InputText: "alice", ActionType: "ENTER_TEXT"},
+		{ResourceName: "login_button", ActionType: "SINGLE_CLICK"},
+	}
+	if config.Robo == nil || !reflect.DeepEqual(config.Robo.Directives, want) {
+		t.Errorf("Robo = %#v, want directives %#v", config.Robo, want)
+	}
+}
+
+func TestParseTestMatrixConfig_FlankErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name:    "invalid type",
+			config:  "gcloud:\n  type: xctest\n",
+			wantErr: "flank.yml:2: gcloud.type: should be one of instrumentation, robo, game-loop, got: xctest",
+		},
+		{
+			name:    "missing device version",
+			config:  "gcloud:\n  device:\n    - model: Pixel2.arm\n",
+			wantErr: "flank.yml:3: gcloud.device[0].version: required field is missing",
+		},
+		{
+			name:    "invalid timeout",
+			config:  "gcloud:\n  timeout: 15 minutes\n",
+			wantErr: "flank.yml:2: gcloud.timeout: invalid duration: 15 minutes",
+		},
+		{
+			name:    "invalid robo directive",
+			config:  "gcloud:\n  robo-directives:\n    swipe:list: \"\"\n",
+			wantErr: "flank.yml:3: gcloud.robo-directives.swipe:list: should be in the",
+		},
+		{
+			name:    "invalid scenario number",
+			config:  "gcloud:\n  type: game-loop\n  scenario-numbers: [1, two]\n",
+			wantErr: "flank.yml:3: gcloud.scenario-numbers[1]: should be an integer, got: two",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTestMatrixConfig("flank.yml", []byte(tt.config))
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("parseTestMatrixConfig() error = %v, want prefix %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Game loop manifest declarations, see https://firebase.google.com/docs/test-lab/android/game-loop
+const (
+	testLoopAction        = "com.google.intent.action.TEST_LOOP"
+	testLoopsMetaData     = "com.google.test.loops"
+	testLoopsLabelsPrefix = testLoopsMetaData + "."
+
+	// maxLoopScenarios is the maximum number of scenarios in a test matrix
+	maxLoopScenarios = 1024
+)
+
+// GameLoops contains the game loop scenarios declared by the app manifest
+type GameLoops struct {
+	// Declared is true if an activity handles the TEST_LOOP intent
+	Declared bool
+	// ScenarioCount is the number of scenarios (com.google.test.loops meta-data, 1 by default)
+	ScenarioCount int64
+	// Labels maps the labels (com.google.test.loops.<label> meta-data) to their scenarios
+	Labels map[string][]int64
+}
+
+// gameLoops reads the TEST_LOOP intent filter and the scenario meta-data of the manifest.
+func (manifest AndroidManifest) gameLoops() (GameLoops, error) {
+	loops := GameLoops{ScenarioCount: 1, Labels: map[string][]int64{}}
+	if manifest.root == nil {
+		return loops, nil
+	}
+
+	for _, application := range manifest.root.children("application") {
+		for _, activity := range application.children("activity") {
+			for _, intentFilter := range activity.children("intent-filter") {
+				for _, action := range intentFilter.children("action") {
+					loops.Declared = loops.Declared || action.androidAttribute("name") == testLoopAction
+				}
+			}
+		}
+
+		for _, metaData := range application.children("meta-data") {
+			name, value := metaData.androidAttribute("name"), metaData.androidAttribute("value")
+			switch {
+			case name == testLoopsMetaData:
+				count, err := strconv.ParseInt(value, 10, 64)
+				if err != nil || count < 1 {
+					return GameLoops{}, fmt.Errorf("invalid %s meta-data value (%s), should be a positive number", testLoopsMetaData, value)
+				}
+				loops.ScenarioCount = count
+			case strings.HasPrefix(name, testLoopsLabelsPrefix):
+				scenarios, err := parseLoopScenarios(value)
+				if err != nil {
+					return GameLoops{}, fmt.Errorf("invalid %s meta-data value: %s", name, err)
+				}
+				loops.Labels[strings.TrimPrefix(name, testLoopsLabelsPrefix)] = scenarios
+			}
+		}
+	}
+
+	return loops, nil
+}
+
+// label returns the scenarios of a label, which can be given with or without the com.google.test.loops. prefix.
+func (loops GameLoops) label(label string) ([]int64, bool) {
+	scenarios, ok := loops.Labels[strings.TrimPrefix(label, testLoopsLabelsPrefix)]
+	return scenarios, ok
+}
+
+// parseLoopScenarios parses a comma (or newline) separated list of scenario numbers and ranges (`1,3-5`).
+// Duplicates are dropped, the order is kept.
+func parseLoopScenarios(loopScenarios string) ([]int64, error) {
+	var scenarios []int64
+	seen := map[int64]bool{}
+
+	for _, entry := range strings.FieldsFunc(loopScenarios, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		first, last := entry, entry
+		if from, to, isRange := strings.Cut(entry, "-"); isRange {
+			first, last = strings.TrimSpace(from), strings.TrimSpace(to)
+		}
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 1 {
+			return nil, fmt.Errorf("invalid scenario (%s), should be a positive number or a range like 1-5", entry)
+		}
+		end, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return nil, fmt.Errorf("invalid scenario range (%s), should be a range like 1-5", entry)
+		}
+		if end-start >= maxLoopScenarios {
+			return nil, fmt.Errorf("too many scenarios in %s, a maximum of %d scenarios may be specified", entry, maxLoopScenarios)
+		}
+
+		for scenario := start; scenario <= end; scenario++ {
+			if !seen[scenario] {
+				seen[scenario] = true
+				scenarios = append(scenarios, scenario)
+			}
+		}
+		if len(scenarios) > maxLoopScenarios {
+			return nil, fmt.Errorf("%d scenarios specified, a maximum of %d scenarios may be specified", len(scenarios), maxLoopScenarios)
+		}
+	}
+
+	return scenarios, nil
+}
+
+// parseLoopScenarioLabels parses the comma (or newline) separated scenario labels.
+func parseLoopScenarioLabels(loopScenarioLabels string) []string {
+	var labels []string
+	for _, label := range strings.FieldsFunc(loopScenarioLabels, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if label = strings.TrimSpace(label); label != "" {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+// checkGameLoopScenarios checks the selected scenario numbers and labels against the ones the app declares.
+func checkGameLoopScenarios(loops GameLoops, scenarios []int64, labels []string) error {
+	if !loops.Declared {
+		return fmt.Errorf("the app has no activity handling the %s intent, it does not support game loop tests", testLoopAction)
+	}
+
+	var undeclared []string
+	for _, scenario := range scenarios {
+		if scenario > loops.ScenarioCount {
+			undeclared = append(undeclared, strconv.FormatInt(scenario, 10))
+		}
+	}
+	if len(undeclared) > 0 {
+		return fmt.Errorf("the app declares %d scenarios (%s meta-data), scenarios not declared: %s", loops.ScenarioCount, testLoopsMetaData, strings.Join(undeclared, ", "))
+	}
+
+	selected := map[int64]bool{}
+	for _, scenario := range scenarios {
+		selected[scenario] = true
+	}
+	for _, label := range labels {
+		labelScenarios, ok := loops.label(label)
+		if !ok {
+			return fmt.Errorf("scenario label %s is not declared by the app (%s<label> meta-data), %s", label, testLoopsLabelsPrefix, loops.declaredLabels())
+		}
+		for _, scenario := range labelScenarios {
+			selected[scenario] = true
+		}
+	}
+	if len(selected) > maxLoopScenarios {
+		return fmt.Errorf("the scenarios and labels select %d scenarios, a maximum of %d scenarios may be specified", len(selected), maxLoopScenarios)
+	}
+
+	return nil
+}
+
+func (loops GameLoops) declaredLabels() string {
+	if len(loops.Labels) == 0 {
+		return "the app declares no labels"
+	}
+
+	labels := make([]string, 0, len(loops.Labels))
+	for label := range loops.Labels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return "declared labels: " + strings.Join(labels, ", ")
+}
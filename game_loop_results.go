@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	gameLoopResultsFileName = "game_loop_results.xml"
+	gameLoopResultsEnvID    = "VDTESTING_GAME_LOOP_RESULTS_PATH"
+
+	// gameLoopTestResultDirName is the subdirectory of the Bitrise test result directory the JUnit XML is copied to
+	gameLoopTestResultDirName = "vdtesting_game_loop"
+)
+
+const (
+	gameLoopOutcomePassed  = "passed"
+	gameLoopOutcomeFailed  = "failed"
+	gameLoopOutcomeSkipped = "skipped"
+	gameLoopOutcomeUnknown = "unknown"
+)
+
+// per scenario results file of a game loop test: MediumPhone.arm-33-en-portrait_results_scenario_2.json
+var gameLoopResultRegexp = regexp.MustCompile(`results_scenario_(\d+)(\.\w+)?$`)
+
+// GameLoopScenarioResult is the outcome of a game loop scenario on a device, read from the results file the app wrote
+type GameLoopScenarioResult struct {
+	Dimension string `json:"dimension"`
+	TestRun   string `json:"test_run,omitempty"`
+	Scenario  int    `json:"scenario"`
+	Outcome   string `json:"outcome"`
+	Message   string `json:"message,omitempty"`
+	// Path is the results file relative to the download directory
+	Path string `json:"path"`
+}
+
+// collectGameLoopResults reads the per scenario results files of the downloaded test assets.
+func collectGameLoopResults(manifest AssetManifest, dir string) ([]GameLoopScenarioResult, error) {
+	var results []GameLoopScenarioResult
+	for _, entry := range manifest.Files {
+		if entry.Type != artifactTypeGameLoopResult || entry.Skipped {
+			continue
+		}
+
+		match := gameLoopResultRegexp.FindStringSubmatch(strings.ToLower(entry.Name))
+		scenario, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid scenario number in %s: %w", entry.Name, err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Path))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read game loop results: %w", err)
+		}
+
+		outcome, message := parseGameLoopResult(data)
+		results = append(results, GameLoopScenarioResult{
+			Dimension: entry.Dimension,
+			TestRun:   entry.TestRun,
+			Scenario:  scenario,
+			Outcome:   outcome,
+			Message:   message,
+			Path:      entry.Path,
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Dimension != results[j].Dimension {
+			return results[i].Dimension < results[j].Dimension
+		}
+		return results[i].Scenario < results[j].Scenario
+	})
+
+	return results, nil
+}
+
+// parseGameLoopResult determines the outcome of a scenario from its results file. The format of the file is up to the app,
+// JSON objects with a boolean `success`/`passed` or a textual `outcome`/`status`/`result` field are understood,
+// the outcome of other files is unknown.
+func parseGameLoopResult(data []byte) (string, string) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return gameLoopOutcomeUnknown, "the results file is not a JSON object"
+	}
+
+	var message string
+	for _, key := range []string{"message", "error", "failure", "reason"} {
+		if value, ok := fields[key].(string); ok && value != "" {
+			message = value
+			break
+		}
+	}
+
+	for _, key := range []string{"success", "passed"} {
+		if value, ok := fields[key].(bool); ok {
+			if value {
+				return gameLoopOutcomePassed, message
+			}
+			return gameLoopOutcomeFailed, message
+		}
+	}
+
+	for _, key := range []string{"outcome", "status", "result"} {
+		value, ok := fields[key].(string)
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(value) {
+		case "pass", "passed", "success", "succeeded", "ok":
+			return gameLoopOutcomePassed, message
+		case "fail", "failed", "failure", "error":
+			return gameLoopOutcomeFailed, message
+		case "skip", "skipped":
+			return gameLoopOutcomeSkipped, message
+		}
+	}
+
+	return gameLoopOutcomeUnknown, message
+}
+
+func printGameLoopResults(results []GameLoopScenarioResult) {
+	for _, result := range results {
+		device := result.Dimension
+		if result.TestRun != "" {
+			device = result.TestRun + "/" + device
+		}
+
+		line := fmt.Sprintf("- %s, scenario %d: %s", device, result.Scenario, result.Outcome)
+		if result.Message != "" {
+			line += fmt.Sprintf(" (%s)", result.Message)
+		}
+
+		switch result.Outcome {
+		case gameLoopOutcomePassed:
+			log.Donef("%s", line)
+		case gameLoopOutcomeFailed:
+			log.Errorf("%s", line)
+		default:
+			log.Warnf("%s", line)
+		}
+	}
+}
+
+type junitTestSuites struct {
+	XMLName    xml.Name         `xml:"testsuites"`
+	TestSuites []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+// newGameLoopJUnitReport converts the scenario results to JUnit test suites, one per device with a test case per scenario.
+// Scenarios with an unknown outcome are reported as passed, as the app finished them.
+func newGameLoopJUnitReport(results []GameLoopScenarioResult) junitTestSuites {
+	var report junitTestSuites
+	suiteIndex := map[string]int{}
+	for _, result := range results {
+		name := result.Dimension
+		if result.TestRun != "" {
+			name = result.TestRun + "/" + name
+		}
+
+		i, ok := suiteIndex[name]
+		if !ok {
+			i = len(report.TestSuites)
+			suiteIndex[name] = i
+			report.TestSuites = append(report.TestSuites, junitTestSuite{Name: name})
+		}
+		suite := &report.TestSuites[i]
+
+		testCase := junitTestCase{
+			Name:      fmt.Sprintf("scenario %d", result.Scenario),
+			ClassName: testTypeGameLoop,
+		}
+		switch result.Outcome {
+		case gameLoopOutcomeFailed:
+			testCase.Failure = &junitMessage{Message: result.Message}
+			suite.Failures++
+		case gameLoopOutcomeSkipped:
+			testCase.Skipped = &junitMessage{Message: result.Message}
+			suite.Skipped++
+		case gameLoopOutcomeUnknown:
+			testCase.SystemOut = fmt.Sprintf("No outcome found in the results file (%s): %s", result.Path, result.Message)
+		}
+		suite.Tests++
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+	return report
+}
+
+func writeGameLoopJUnitReport(results []GameLoopScenarioResult, pth string) error {
+	data, err := xml.MarshalIndent(newGameLoopJUnitReport(results), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal game loop results: %w", err)
+	}
+	if err := os.WriteFile(pth, append([]byte(xml.Header), data...), 0644); err != nil {
+		return fmt.Errorf("failed to write game loop results: %w", err)
+	}
+	return nil
+}
+
+// copyToTestResultDir copies the JUnit XML into the Bitrise test result directory, so it shows up in the test reports.
+func copyToTestResultDir(pth, testResultDir string) (string, error) {
+	dir := filepath.Join(testResultDir, gameLoopTestResultDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create test result directory: %w", err)
+	}
+
+	data, err := os.ReadFile(pth)
+	if err != nil {
+		return "", fmt.Errorf("failed to read game loop results: %w", err)
+	}
+	dst := filepath.Join(dir, filepath.Base(pth))
+	if err := os.WriteFile(dst, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to copy game loop results: %w", err)
+	}
+
+	testInfo := []byte(`{"test-name":"Game loop scenarios"}`)
+	if err := os.WriteFile(filepath.Join(dir, "test-info.json"), testInfo, 0644); err != nil {
+		return "", fmt.Errorf("failed to write test info: %w", err)
+	}
+
+	return dst, nil
+}
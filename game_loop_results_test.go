@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	testingapi "google.golang.org/api/testing/v1"
+)
+
+func TestParseGameLoopResult(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		wantOutcome string
+		wantMessage string
+	}{
+		{name: "success flag", data: `{"success": true, "fps": 58}`, wantOutcome: gameLoopOutcomePassed},
+		{name: "failed flag with message", data: `{"passed": false, "message": "level 3 did not load"}`, wantOutcome: gameLoopOutcomeFailed, wantMessage: "level 3 did not load"},
+		{name: "status", data: `{"status": "FAILURE", "error": "crash"}`, wantOutcome: gameLoopOutcomeFailed, wantMessage: "crash"},
+		{name: "skipped outcome", data: `{"outcome": "skipped"}`, wantOutcome: gameLoopOutcomeSkipped},
+		{name: "no outcome", data: `{"fps": 60}`, wantOutcome: gameLoopOutcomeUnknown},
+		{name: "not JSON", data: "frames: 1200", wantOutcome: gameLoopOutcomeUnknown, wantMessage: "the results file is not a JSON object"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outcome, message := parseGameLoopResult([]byte(tt.data))
+			if outcome != tt.wantOutcome || message != tt.wantMessage {
+				t.Errorf("parseGameLoopResult() = %s, %q, want %s, %q", outcome, message, tt.wantOutcome, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestCollectGameLoopResults(t *testing.T) {
+	devices := []*testingapi.AndroidDevice{
+		{AndroidModelId: "MediumPhone.arm", AndroidVersionId: "33", Locale: "en", Orientation: "portrait"},
+		{AndroidModelId: "Pixel2.arm", AndroidVersionId: "30", Locale: "en", Orientation: "portrait"},
+	}
+	files := map[string]string{
+		"Pixel2.arm-30-en-portrait_results_scenario_1.json":      `{"success": false, "message": "timeout"}`,
+		"MediumPhone.arm-33-en-portrait_results_scenario_2.json": `{"success": true}`,
+		"MediumPhone.arm-33-en-portrait_results_scenario_1.json": `not json`,
+		"MediumPhone.arm-33-en-portrait_logcat":                  "",
+	}
+
+	dir := t.TempDir()
+	var manifest AssetManifest
+	for name, content := range files {
+		entry := newAssetManifestEntry(name, devices)
+		pth := filepath.Join(dir, entry.Path)
+		if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(pth, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		manifest.Files = append(manifest.Files, entry)
+	}
+
+	results, err := collectGameLoopResults(manifest, dir)
+	if err != nil {
+		t.Fatalf("collectGameLoopResults() returned error: %v", err)
+	}
+
+	var got []string
+	for _, result := range results {
+		got = append(got, strings.Join([]string{result.Dimension, result.Outcome}, ":"))
+	}
+	want := []string{
+		"MediumPhone.arm-33-en-portrait:" + gameLoopOutcomeUnknown,
+		"MediumPhone.arm-33-en-portrait:" + gameLoopOutcomePassed,
+		"Pixel2.arm-30-en-portrait:" + gameLoopOutcomeFailed,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("collectGameLoopResults() = %v, want %v", got, want)
+	}
+
+	pth := filepath.Join(dir, gameLoopResultsFileName)
+	if err := writeGameLoopJUnitReport(results, pth); err != nil {
+		t.Fatalf("writeGameLoopJUnitReport() returned error: %v", err)
+	}
+	data, err := os.ReadFile(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, wantXML := range []string{
+		`<testsuite name="MediumPhone.arm-33-en-portrait" tests="2" failures="0" skipped="0">`,
+		`<testcase name="scenario 2" classname="gameloop"></testcase>`,
+		`<testsuite name="Pixel2.arm-30-en-portrait" tests="1" failures="1" skipped="0">`,
+		`<failure message="timeout"></failure>`,
+	} {
+		if !strings.Contains(string(data), wantXML) {
+			t.Errorf("JUnit report does not contain %s:\n%s", wantXML, data)
+		}
+	}
+
+	testResultDir := t.TempDir()
+	copied, err := copyToTestResultDir(pth, testResultDir)
+	if err != nil {
+		t.Fatalf("copyToTestResultDir() returned error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(copied), "test-info.json")); err != nil {
+		t.Errorf("test-info.json is missing: %v", err)
+	}
+}
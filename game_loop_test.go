@@ -0,0 +1,119 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLoopScenarios(t *testing.T) {
+	tests := []struct {
+		loopScenarios string
+		want          []int64
+		wantErr       string
+	}{
+		{loopScenarios: "", want: nil},
+		{loopScenarios: "1,2", want: []int64{1, 2}},
+		{loopScenarios: "3-5, 1\n4", want: []int64{3, 4, 5, 1}},
+		{loopScenarios: "1-1024", want: func() []int64 {
+			var all []int64
+			for i := int64(1); i <= 1024; i++ {
+				all = append(all, i)
+			}
+			return all
+		}()},
+		{loopScenarios: "0", wantErr: "invalid scenario (0)"},
+		{loopScenarios: "a", wantErr: "invalid scenario (a)"},
+		{loopScenarios: "5-3", wantErr: "invalid scenario range (5-3)"},
+		{loopScenarios: "1-1025", wantErr: "too many scenarios in 1-1025"},
+		{loopScenarios: "1-1000,2000-2030", wantErr: "1031 scenarios specified"},
+	}
+	for _, tt := range tests {
+		got, err := parseLoopScenarios(tt.loopScenarios)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseLoopScenarios(%q) error = %v, want %s", tt.loopScenarios, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseLoopScenarios(%q) returned error: %v", tt.loopScenarios, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseLoopScenarios(%q) = %v, want %v", tt.loopScenarios, got, tt.want)
+		}
+	}
+}
+
+func androidElement(name string, attributes map[string]string, children ...*xmlElement) *xmlElement {
+	element := &xmlElement{Name: name, Children: children}
+	for key, value := range attributes {
+		element.Attributes = append(element.Attributes, xmlAttribute{Namespace: androidNamespace, Name: key, Value: value})
+	}
+	return element
+}
+
+func TestGameLoops(t *testing.T) {
+	root := androidElement("manifest", nil,
+		androidElement("application", nil,
+			androidElement("activity", map[string]string{"name": ".MainActivity"},
+				androidElement("intent-filter", nil,
+					androidElement("action", map[string]string{"name": testLoopAction}),
+					androidElement("category", map[string]string{"name": "android.intent.category.DEFAULT"}),
+				),
+			),
+			androidElement("meta-data", map[string]string{"name": "com.google.test.loops", "value": "5"}),
+			androidElement("meta-data", map[string]string{"name": "com.google.test.loops.player_experience", "value": "1,3-4"}),
+			androidElement("meta-data", map[string]string{"name": "com.example.other", "value": "x"}),
+		),
+	)
+
+	loops, err := AndroidManifest{root: root}.gameLoops()
+	if err != nil {
+		t.Fatalf("gameLoops() returned error: %v", err)
+	}
+	want := GameLoops{Declared: true, ScenarioCount: 5, Labels: map[string][]int64{"player_experience": {1, 3, 4}}}
+	if !reflect.DeepEqual(loops, want) {
+		t.Errorf("gameLoops() = %+v, want %+v", loops, want)
+	}
+
+	notDeclared, err := AndroidManifest{root: androidElement("manifest", nil, androidElement("application", nil))}.gameLoops()
+	if err != nil {
+		t.Fatalf("gameLoops() returned error: %v", err)
+	}
+	if notDeclared.Declared || notDeclared.ScenarioCount != 1 {
+		t.Errorf("gameLoops() = %+v, want not declared with 1 scenario", notDeclared)
+	}
+}
+
+func TestCheckGameLoopScenarios(t *testing.T) {
+	loops := GameLoops{Declared: true, ScenarioCount: 5, Labels: map[string][]int64{"player_experience": {1, 3}}}
+
+	tests := []struct {
+		name      string
+		loops     GameLoops
+		scenarios []int64
+		labels    []string
+		wantErr   string
+	}{
+		{name: "all scenarios", loops: loops},
+		{name: "declared scenarios and labels", loops: loops, scenarios: []int64{1, 5}, labels: []string{"player_experience", "com.google.test.loops.player_experience"}},
+		{name: "no TEST_LOOP intent filter", loops: GameLoops{ScenarioCount: 1}, wantErr: "does not support game loop tests"},
+		{name: "undeclared scenario", loops: loops, scenarios: []int64{4, 6, 7}, wantErr: "the app declares 5 scenarios (com.google.test.loops meta-data), scenarios not declared: 6, 7"},
+		{name: "undeclared label", loops: loops, labels: []string{"gpu"}, wantErr: "scenario label gpu is not declared by the app (com.google.test.loops.<label> meta-data), declared labels: player_experience"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkGameLoopScenarios(tt.loops, tt.scenarios, tt.labels)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkGameLoopScenarios() returned error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("checkGameLoopScenarios() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -7,10 +7,15 @@ require (
 	github.com/bitrise-io/go-steputils/v2 v2.0.0-alpha.40
 	github.com/bitrise-io/go-utils v1.0.13
 	github.com/bitrise-io/go-utils/v2 v2.0.0-alpha.26
+	golang.org/x/oauth2 v0.7.0
 	google.golang.org/api v0.114.0
 )
 
-require github.com/bitrise-steplib/steps-virtual-device-testing-for-ios v0.0.0-20250811073801-393566b79d9f
+require (
+	github.com/bitrise-steplib/steps-virtual-device-testing-for-ios v0.0.0-20250811073801-393566b79d9f
+	google.golang.org/protobuf v1.33.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	cloud.google.com/go/compute v1.19.1 // indirect
@@ -22,11 +27,9 @@ require (
 	github.com/googleapis/gax-go/v2 v2.7.1 // indirect
 	go.opencensus.io v0.24.0 // indirect
 	golang.org/x/net v0.25.0 // indirect
-	golang.org/x/oauth2 v0.7.0 // indirect
 	golang.org/x/sys v0.22.0 // indirect
 	golang.org/x/text v0.16.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
 	google.golang.org/grpc v1.56.3 // indirect
-	google.golang.org/protobuf v1.33.0 // indirect
 )
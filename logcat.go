@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	logcatReportFileName = "logcat_report.json"
+	logcatReportEnvID    = "VDTESTING_LOGCAT_REPORT_PATH"
+
+	maxLogcatStackTraceLines = 40
+	printedStackTraceLines   = 10
+
+	// logcatUnknownProcess is the process of the findings logged by a process whose start is not in the logcat
+	logcatUnknownProcess = "unknown"
+)
+
+const (
+	logcatFindingCrash       = "crash"
+	logcatFindingANR         = "anr"
+	logcatFindingNativeCrash = "native_crash"
+	logcatFindingStrictMode  = "strict_mode"
+)
+
+var (
+	// threadtime format: 10-19 12:34:56.789  1234  1256 E AndroidRuntime: FATAL EXCEPTION: main
+	threadtimeLineRegexp = regexp.MustCompile(`^\d\d-\d\d\s+\d\d:\d\d:\d\d\.\d+\s+(\d+)\s+\d+\s+([VDIWEFA])\s+(.*?)\s*: (.*)$`)
+	// brief format: E/AndroidRuntime( 1234): FATAL EXCEPTION: main
+	briefLineRegexp = regexp.MustCompile(`^([VDIWEFA])/(.*?)\(\s*(\d+)\): (.*)$`)
+
+	startProcRegexp     = regexp.MustCompile(`Start proc (\d+):([^/\s]+)`)
+	crashProcessRegexp  = regexp.MustCompile(`^Process: ([^,\s]+), PID: (\d+)`)
+	anrRegexp           = regexp.MustCompile(`^ANR in (\S+)`)
+	fatalSignalRegexp   = regexp.MustCompile(`^Fatal signal \d+ \(\w+\).*pid (\d+) \(([^)]+)\)`)
+	nativeBacktraceLine = regexp.MustCompile(`#\d+ pc `)
+)
+
+// LogcatFinding is a de-duplicated crash, ANR, native crash or StrictMode violation found in a device's logcat
+type LogcatFinding struct {
+	Type       string   `json:"type"`
+	Process    string   `json:"process"`
+	Message    string   `json:"message"`
+	StackTrace []string `json:"stack_trace,omitempty"`
+	Count      int      `json:"count"`
+}
+
+// DeviceLogcatReport contains the logcat findings of a device dimension across all of its test attempts
+type DeviceLogcatReport struct {
+	Dimension string          `json:"dimension"`
+	Findings  []LogcatFinding `json:"findings"`
+}
+
+// LogcatReport is the structured result of the logcat analysis
+type LogcatReport struct {
+	AppPackageID string               `json:"app_package_id,omitempty"`
+	Devices      []DeviceLogcatReport `json:"devices"`
+}
+
+type logcatLine struct {
+	pid     string
+	level   string
+	tag     string
+	message string
+}
+
+func parseLogcatLine(line string) (logcatLine, bool) {
+	if match := threadtimeLineRegexp.FindStringSubmatch(line); match != nil {
+		return logcatLine{pid: match[1], level: match[2], tag: match[3], message: match[4]}, true
+	}
+	if match := briefLineRegexp.FindStringSubmatch(line); match != nil {
+		return logcatLine{pid: match[3], level: match[1], tag: strings.TrimSpace(match[2]), message: match[4]}, true
+	}
+	return logcatLine{}, false
+}
+
+type logcatAnalyzer struct {
+	appPackageID string
+	processes    map[string]string
+
+	findings []*LogcatFinding
+	index    map[string]*LogcatFinding
+
+	current    *LogcatFinding
+	currentTag string
+	currentPID string
+}
+
+func newLogcatAnalyzer(appPackageID string) *logcatAnalyzer {
+	return &logcatAnalyzer{
+		appPackageID: appPackageID,
+		processes:    map[string]string{},
+		index:        map[string]*LogcatFinding{},
+	}
+}
+
+// belongsToApp reports whether the process is the app's main process or one of its named sub-processes (com.example:remote).
+// Every process is accepted if the app's package is unknown.
+func (a *logcatAnalyzer) belongsToApp(process string) bool {
+	if a.appPackageID == "" {
+		return true
+	}
+	return process == a.appPackageID || strings.HasPrefix(process, a.appPackageID+":")
+}
+
+func (a *logcatAnalyzer) analyze(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line, ok := parseLogcatLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		a.processLine(line)
+	}
+	a.closeCurrent()
+	return scanner.Err()
+}
+
+func (a *logcatAnalyzer) processLine(line logcatLine) {
+	if match := startProcRegexp.FindStringSubmatch(line.message); match != nil {
+		a.processes[match[1]] = match[2]
+	}
+
+	if a.current != nil {
+		if a.continueCurrent(line) {
+			return
+		}
+		a.closeCurrent()
+	}
+
+	switch {
+	case line.tag == "AndroidRuntime" && strings.HasPrefix(line.message, "FATAL EXCEPTION"):
+		a.open(&LogcatFinding{Type: logcatFindingCrash, Process: a.processes[line.pid]}, line)
+	case line.tag == "ActivityManager" && anrRegexp.MatchString(line.message):
+		process := anrRegexp.FindStringSubmatch(line.message)[1]
+		a.open(&LogcatFinding{Type: logcatFindingANR, Process: process, Message: line.message}, line)
+	case fatalSignalRegexp.MatchString(line.message):
+		match := fatalSignalRegexp.FindStringSubmatch(line.message)
+		a.processes[match[1]] = match[2]
+		a.open(&LogcatFinding{Type: logcatFindingNativeCrash, Process: match[2], Message: line.message}, line)
+	case line.tag == "StrictMode" && strings.Contains(line.message, "policy violation"):
+		message := line.message
+		if i := strings.LastIndex(message, ": "); i >= 0 {
+			message = message[i+2:]
+		}
+		a.open(&LogcatFinding{Type: logcatFindingStrictMode, Process: a.processes[line.pid], Message: message}, line)
+	}
+}
+
+func (a *logcatAnalyzer) open(finding *LogcatFinding, line logcatLine) {
+	a.current = finding
+	a.currentTag = line.tag
+	a.currentPID = line.pid
+}
+
+// continueCurrent consumes the line if it is the continuation of the finding being collected.
+func (a *logcatAnalyzer) continueCurrent(line logcatLine) bool {
+	finding := a.current
+
+	if finding.Type == logcatFindingNativeCrash {
+		// the tombstone is written by the crash_dump process, not by the crashed one
+		if line.tag == "DEBUG" || line.tag == "crash_dump64" || line.tag == "crash_dump32" {
+			if nativeBacktraceLine.MatchString(line.message) {
+				a.appendStackTrace(strings.TrimSpace(line.message))
+			}
+			return true
+		}
+		return line.tag == "libc" && line.pid == a.currentPID
+	}
+
+	if line.tag != a.currentTag || line.pid != a.currentPID {
+		return false
+	}
+
+	switch finding.Type {
+	case logcatFindingCrash:
+		if match := crashProcessRegexp.FindStringSubmatch(line.message); match != nil {
+			finding.Process = match[1]
+			a.processes[match[2]] = match[1]
+			return true
+		}
+		if finding.Message == "" {
+			finding.Message = strings.TrimSpace(line.message)
+			return true
+		}
+	case logcatFindingANR:
+		if strings.HasPrefix(line.message, "Reason: ") {
+			finding.Message = fmt.Sprintf("ANR in %s: %s", finding.Process, strings.TrimPrefix(line.message, "Reason: "))
+		}
+		return true
+	case logcatFindingStrictMode:
+		if finding.Process == "" {
+			finding.Process = a.processes[line.pid]
+		}
+	}
+
+	a.appendStackTrace(strings.TrimSpace(line.message))
+	return true
+}
+
+func (a *logcatAnalyzer) appendStackTrace(line string) {
+	if line == "" || len(a.current.StackTrace) >= maxLogcatStackTraceLines {
+		return
+	}
+	a.current.StackTrace = append(a.current.StackTrace, line)
+}
+
+func (a *logcatAnalyzer) closeCurrent() {
+	finding := a.current
+	a.current = nil
+	if finding == nil {
+		return
+	}
+	// the process might be the app's, findings of unknown processes are kept
+	if finding.Process == "" {
+		finding.Process = logcatUnknownProcess
+	} else if !a.belongsToApp(finding.Process) {
+		return
+	}
+
+	key := finding.Type + "|" + finding.Process + "|" + finding.Message
+	if finding.Type != logcatFindingANR && len(finding.StackTrace) > 0 {
+		key += "|" + finding.StackTrace[0]
+	}
+
+	if existing, ok := a.index[key]; ok {
+		existing.Count++
+		return
+	}
+
+	finding.Count = 1
+	a.index[key] = finding
+	a.findings = append(a.findings, finding)
+}
+
+func (a *logcatAnalyzer) result() []LogcatFinding {
+	findings := make([]LogcatFinding, 0, len(a.findings))
+	for _, finding := range a.findings {
+		findings = append(findings, *finding)
+	}
+	return findings
+}
+
+// analyzeLogcats scans the downloaded logcat files of every device dimension for crashes, ANRs,
+// native crashes and StrictMode violations of the app.
+func analyzeLogcats(manifest AssetManifest, dir, appPackageID string) (LogcatReport, error) {
+	report := LogcatReport{AppPackageID: appPackageID}
+
+	analyzers := map[string]*logcatAnalyzer{}
+	var dimensions []string
+	for _, entry := range manifest.Files {
+		if entry.Type != artifactTypeLogcat {
+			continue
+		}
+
+		dimension := entry.deviceID()
+		analyzer, ok := analyzers[dimension]
+		if !ok {
+			analyzer = newLogcatAnalyzer(appPackageID)
+			analyzers[dimension] = analyzer
+			dimensions = append(dimensions, dimension)
+		}
+
+		if err := analyzeLogcatFile(analyzer, filepath.Join(dir, entry.Path)); err != nil {
+			return LogcatReport{}, err
+		}
+	}
+
+	for _, dimension := range dimensions {
+		report.Devices = append(report.Devices, DeviceLogcatReport{
+			Dimension: dimension,
+			Findings:  analyzers[dimension].result(),
+		})
+	}
+
+	return report, nil
+}
+
+func analyzeLogcatFile(analyzer *logcatAnalyzer, pth string) error {
+	f, err := os.Open(pth)
+	if err != nil {
+		return fmt.Errorf("failed to open logcat (%s): %w", pth, err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("Failed to close logcat (%s): %s", pth, err)
+		}
+	}()
+
+	if err := analyzer.analyze(f); err != nil {
+		return fmt.Errorf("failed to read logcat (%s): %w", pth, err)
+	}
+	return nil
+}
+
+func writeLogcatReport(report LogcatReport, pth string) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal logcat report: %w", err)
+	}
+	if err := os.WriteFile(pth, data, 0644); err != nil {
+		return fmt.Errorf("failed to write logcat report: %w", err)
+	}
+	return nil
+}
+
+func printLogcatReport(report LogcatReport) {
+	for _, device := range report.Devices {
+		counts := map[string]int{}
+		for _, finding := range device.Findings {
+			counts[finding.Type] += finding.Count
+		}
+
+		log.Printf("%s: %d crash(es), %d ANR(s), %d native crash(es), %d StrictMode violation(s)", device.Dimension,
+			counts[logcatFindingCrash], counts[logcatFindingANR], counts[logcatFindingNativeCrash], counts[logcatFindingStrictMode])
+
+		for _, finding := range device.Findings {
+			message := fmt.Sprintf("- [%s] %s: %s (%dx)", finding.Type, finding.Process, finding.Message, finding.Count)
+			if finding.Type == logcatFindingStrictMode {
+				log.Printf("%s", message)
+			} else {
+				log.Warnf("%s", message)
+			}
+
+			for i, line := range finding.StackTrace {
+				if i == printedStackTraceLines {
+					log.Printf("    ... %d more line(s)", len(finding.StackTrace)-i)
+					break
+				}
+				log.Printf("    %s", line)
+			}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sampleLogcat = `--------- beginning of main
+10-19 12:00:00.000   512   530 I ActivityManager: Start proc 4321:com.example.app/u0a123 for activity {com.example.app/com.example.app.MainActivity}
+10-19 12:00:01.000  4321  4321 D StrictMode: StrictMode policy violation; ~duration=12 ms: android.os.strictmode.DiskReadViolation
+10-19 12:00:01.000  4321  4321 D StrictMode: 	at android.os.StrictMode$AndroidBlockGuardPolicy.onReadFromDisk(StrictMode.java:1596)
+10-19 12:00:01.000  4321  4321 D StrictMode: 	at com.example.app.MainActivity.onCreate(MainActivity.kt:21)
+10-19 12:00:02.000   777   777 D StrictMode: StrictMode policy violation; ~duration=3 ms: android.os.strictmode.DiskWriteViolation
+10-19 12:00:03.000  4321  4321 E AndroidRuntime: FATAL EXCEPTION: main
+10-19 12:00:03.000  4321  4321 E AndroidRuntime: Process: com.example.app, PID: 4321
+10-19 12:00:03.000  4321  4321 E AndroidRuntime: java.lang.NullPointerException: boom
+10-19 12:00:03.000  4321  4321 E AndroidRuntime: 	at com.example.app.MainActivity.onClick(MainActivity.kt:42)
+10-19 12:00:03.000  4321  4321 E AndroidRuntime: 	at android.view.View.performClick(View.java:7448)
+10-19 12:00:03.500   512   530 I ActivityManager: Process com.example.app (pid 4321) has died
+10-19 12:00:04.000   512   530 I ActivityManager: Start proc 4400:com.example.app/u0a123 for activity {com.example.app/com.example.app.MainActivity}
+10-19 12:00:05.000  4400  4400 E AndroidRuntime: FATAL EXCEPTION: main
+10-19 12:00:05.000  4400  4400 E AndroidRuntime: Process: com.example.app, PID: 4400
+10-19 12:00:05.000  4400  4400 E AndroidRuntime: java.lang.NullPointerException: boom
+10-19 12:00:05.000  4400  4400 E AndroidRuntime: 	at com.example.app.MainActivity.onClick(MainActivity.kt:42)
+10-19 12:00:05.000  4400  4400 E AndroidRuntime: 	at android.view.View.performClick(View.java:7448)
+10-19 12:00:06.000   512   531 E ActivityManager: ANR in com.example.app (com.example.app/.MainActivity)
+10-19 12:00:06.000   512   531 E ActivityManager: PID: 4400
+10-19 12:00:06.000   512   531 E ActivityManager: Reason: Input dispatching timed out
+10-19 12:00:07.000  4500  4510 F libc    : Fatal signal 11 (SIGSEGV), code 1 (SEGV_MAPERR), fault addr 0x0 in tid 4510 (RenderThread), pid 4500 (com.example.app:render)
+10-19 12:00:07.100  4600  4600 F DEBUG   : *** *** *** *** *** *** *** *** *** *** *** *** *** *** *** ***
+10-19 12:00:07.100  4600  4600 F DEBUG   :       #00 pc 000000000001e0c4  /data/app/com.example.app/lib/arm64/libnative.so (crash+4)
+10-19 12:00:07.100  4600  4600 F DEBUG   :       #01 pc 000000000001e1a0  /data/app/com.example.app/lib/arm64/libnative.so (run+20)
+10-19 12:00:08.000  5000  5000 E AndroidRuntime: FATAL EXCEPTION: main
+10-19 12:00:08.000  5000  5000 E AndroidRuntime: Process: com.other.app, PID: 5000
+10-19 12:00:08.000  5000  5000 E AndroidRuntime: java.lang.IllegalStateException: not ours
+`
+
+func TestLogcatAnalyzer(t *testing.T) {
+	analyzer := newLogcatAnalyzer("com.example.app")
+	if err := analyzer.analyze(strings.NewReader(sampleLogcat)); err != nil {
+		t.Fatalf("analyze() returned error: %v", err)
+	}
+
+	want := []LogcatFinding{
+		{
+			Type:    logcatFindingStrictMode,
+			Process: "com.example.app",
+			Message: "android.os.strictmode.DiskReadViolation",
+			StackTrace: []string{
+				"at android.os.StrictMode$AndroidBlockGuardPolicy.onReadFromDisk(StrictMode.java:1596)",
+				"at com.example.app.MainActivity.onCreate(MainActivity.kt:21)",
+			},
+			Count: 1,
+		},
+		{
+			Type:    logcatFindingStrictMode,
+			Process: logcatUnknownProcess,
+			Message: "android.os.strictmode.DiskWriteViolation",
+			Count:   1,
+		},
+		{
+			Type:    logcatFindingCrash,
+			Process: "com.example.app",
+			Message: "java.lang.NullPointerException: boom",
+			StackTrace: []string{
+				"at com.example.app.MainActivity.onClick(MainActivity.kt:42)",
+				"at android.view.View.performClick(View.java:7448)",
+			},
+			Count: 2,
+		},
+		{
+			Type:       logcatFindingANR,
+			Process:    "com.example.app",
+			Message:    "ANR in com.example.app: Input dispatching timed out",
+			StackTrace: nil,
+			Count:      1,
+		},
+		{
+			Type:    logcatFindingNativeCrash,
+			Process: "com.example.app:render",
+			Message: "Fatal signal 11 (SIGSEGV), code 1 (SEGV_MAPERR), fault addr 0x0 in tid 4510 (RenderThread), pid 4500 (com.example.app:render)",
+			StackTrace: []string{
+				"#00 pc 000000000001e0c4  /data/app/com.example.app/lib/arm64/libnative.so (crash+4)",
+				"#01 pc 000000000001e1a0  /data/app/com.example.app/lib/arm64/libnative.so (run+20)",
+			},
+			Count: 1,
+		},
+	}
+
+	if got := analyzer.result(); !reflect.DeepEqual(got, want) {
+		t.Errorf("result() = %#v, want %#v", got, want)
+	}
+}
+
+func TestLogcatAnalyzer_UnknownPackage(t *testing.T) {
+	analyzer := newLogcatAnalyzer("")
+	if err := analyzer.analyze(strings.NewReader(sampleLogcat)); err != nil {
+		t.Fatalf("analyze() returned error: %v", err)
+	}
+
+	// every process is reported, including the unattributed StrictMode violation and the other app's crash
+	if got := len(analyzer.result()); got != 6 {
+		t.Errorf("len(result()) = %d, want 6", got)
+	}
+}
@@ -1,24 +1,18 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"path/filepath"
-	"slices"
 	"strconv"
-	"strings"
-	"text/tabwriter"
 	"time"
 
 	toolresults "google.golang.org/api/toolresults/v1beta3"
 
-	"github.com/bitrise-io/go-steputils/stepconf"
 	"github.com/bitrise-io/go-utils/colorstring"
 	"github.com/bitrise-io/go-utils/log"
-	"github.com/bitrise-io/go-utils/pathutil"
 	logv2 "github.com/bitrise-io/go-utils/v2/log"
 	"github.com/bitrise-steplib/steps-virtual-device-testing-for-ios/output"
 )
@@ -26,252 +20,55 @@ import (
 const (
 	testTypeInstrumentation = "instrumentation"
 	testTypeRobo            = "robo"
+	testTypeGameLoop        = "gameloop"
 )
 
-func failf(f string, v ...interface{}) {
-	log.Errorf(f, v...)
-	os.Exit(1)
-}
+var (
+	// statusPollInterval is the time between two test status requests
+	statusPollInterval = 10 * time.Second
+	// validationTimeout is the maximum time the test matrices may stay in validation, before any test is started
+	validationTimeout = 30 * time.Minute
+)
 
 func main() {
-	logger := logv2.NewLogger()
-	outputExporter := output.NewExporter(output.NewOutputExporter(), logger)
-
-	var configs ConfigsModel
-	if err := stepconf.Parse(&configs); err != nil {
-		failf("Invalid input: %s", err)
-	}
-
-	if err := configs.validate(); err != nil {
-		log.Errorf("Failed to parse config:")
-		failf("%s", err)
-	}
-
-	fmt.Println()
-	configs.print()
-
-	log.SetEnableDebugLog(configs.VerboseLog)
+	os.Exit(run())
+}
 
-	fmt.Println()
+// run runs the step phases, the errors of the phases are turned into the exit code here.
+func run() int {
+	log.SetOutWriter(logOutput)
+	logOutput.addSensitiveEnvs(os.Environ())
 
-	log.Infof("Uploading app and test files")
+	logger := logv2.NewLogger()
+	envExporter := output.NewOutputExporter()
+	outputExporter := output.NewExporter(envExporter, logger)
+	step := NewStep(newAPIClient(systemClock{}), systemClock{}, systemClock{}, envExporter, outputExporter)
 
-	testAssets, err := uploadTestAssets(configs)
+	configs, err := step.ProcessConfig()
 	if err != nil {
-		failf("Failed to upload test assets, error: %s", err)
-	}
-	log.Donef("=> Files uploaded")
-
-	fmt.Println()
-	log.Infof("Starting test")
-
-	if err = startTestRun(configs, testAssets); err != nil {
-		failf("Starting test run failed, error: %s", err)
-	}
-	log.Donef("=> Test started")
-
-	fmt.Println()
-	log.Infof("Waiting for test results")
-
-	dimensionToStatus := map[string]bool{}
-	{
-		finished := false
-		printedLogs := []string{}
-
-		for !finished {
-			url := configs.APIBaseURL + "/" + configs.AppSlug + "/" + configs.BuildSlug + "/" + configs.APIToken
-
-			req, err := http.NewRequest("GET", url, nil)
-			if err != nil {
-				failf("Failed to create http request, error: %s", err)
-			}
-
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil || resp.StatusCode != http.StatusOK {
-				resp, err = client.Do(req)
-				if err != nil {
-					failf("Failed to get http response, error: %s", err)
-				}
-			}
-
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				failf("Failed to read response body, error: %s", err)
-			}
-
-			if resp.StatusCode != http.StatusOK {
-				failf("Failed to get test status, error: %s", string(body))
-			}
-
-			responseModel := &toolresults.ListStepsResponse{}
-
-			err = json.Unmarshal(body, responseModel)
-			if err != nil {
-				failf("Failed to unmarshal response body, error: %s, body: %s", err, string(body))
-			}
-
-			finished = true
-			testsRunning := 0
-			for _, step := range responseModel.Steps {
-				if step.State != "complete" {
-					finished = false
-					testsRunning++
-				}
-			}
-
-			msg := ""
-			if len(responseModel.Steps) == 0 {
-				finished = false
-				msg = "- Validating"
-			} else {
-				msg = fmt.Sprintf("- (%d/%d) running", testsRunning, len(responseModel.Steps))
-			}
-
-			if !slices.Contains(printedLogs, msg) {
-				log.Printf(msg)
-				printedLogs = append(printedLogs, msg)
-			}
-
-			if finished {
-				log.Donef("=> Test finished")
-				fmt.Println()
-
-				log.Infof("Test results:")
-				w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
-				if _, err := fmt.Fprintln(w, "Model\tAPI Level\tLocale\tOrientation\tOutcome\t"); err != nil {
-					failf("Failed to write in tabwriter, error: %s", err)
-				}
-
-				anyDeviceRunCrashed := false
-
-				for _, step := range responseModel.Steps {
-					dimensions := map[string]string{}
-					for _, dimension := range step.DimensionValue {
-						dimensions[dimension.Key] = dimension.Value
-					}
-
-					dimensionID := fmt.Sprintf("%s.%s.%s.%s", dimensions["Model"], dimensions["Version"], dimensions["Orientation"], dimensions["Locale"])
-					isSuccess := true
-					if step.Outcome.Summary == "failure" || step.Outcome.Summary == "inconclusive" || step.Outcome.Summary == "skipped" {
-						isSuccess = false
-					}
-
-					_, exists := dimensionToStatus[dimensionID]
-					if exists {
-						if isSuccess {
-							// Mark the dimension as successful if at least one step (test run) was successful.
-							dimensionToStatus[dimensionID] = true
-						}
-					} else {
-						dimensionToStatus[dimensionID] = isSuccess
-					}
-
-					outcome, crashed := processStepResult(step)
-					anyDeviceRunCrashed = anyDeviceRunCrashed || crashed
-
-					if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t\n", dimensions["Model"], dimensions["Version"], dimensions["Locale"], dimensions["Orientation"], outcome); err != nil {
-						failf("Failed to write in tabwriter, error: %s", err)
-					}
-				}
-
-				if err := w.Flush(); err != nil {
-					log.Errorf("Failed to flush writer, error: %s", err)
-				}
-
-				if anyDeviceRunCrashed {
-					fmt.Println()
-					log.Warnf("Firebase detected an app crash during one of the runs.")
-					log.Warnf("Note: If the crash occurred outside active test execution (e.g., during cleanup or background processes), individual test results will still appear successful.")
-					fmt.Println()
-				}
-			}
-			if !finished {
-				time.Sleep(10 * time.Second)
-			}
-		}
+		return exitCode(step, err)
 	}
 
-	if configs.DownloadTestResults {
-		fmt.Println()
-		log.Infof("Downloading test assets")
-		{
-			url := configs.APIBaseURL + "/assets/" + configs.AppSlug + "/" + configs.BuildSlug + "/" + configs.APIToken
-
-			req, err := http.NewRequest("GET", url, nil)
-			if err != nil {
-				failf("Failed to create http request, error: %s", err)
-			}
-
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				failf("Failed to get http response, error: %s", err)
-			}
-
-			if resp.StatusCode != http.StatusOK {
-				failf("Failed to get http response, status code: %d", resp.StatusCode)
-			}
-
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				failf("Failed to read response body, error: %s", err)
-			}
-
-			responseModel := map[string]string{}
-
-			err = json.Unmarshal(body, &responseModel)
-			if err != nil {
-				failf("Failed to unmarshal response body, error: %s", err)
-			}
+	result, runErr := step.Run(configs)
+	step.Export(configs, result)
 
-			tempDir, err := pathutil.NormalizedOSTempDirPath("vdtesting_test_assets")
-			if err != nil {
-				failf("Failed to create temp dir, error: %s", err)
-			}
-
-			var mergedTestResultXmlPths []string
-			for fileName, fileURL := range responseModel {
-				pth := filepath.Join(tempDir, fileName)
-				err := downloadFile(fileURL, pth)
-				if err != nil {
-					failf("Failed to download file, error: %s", err)
-				}
-
-				// per test run results: MediumPhone.arm-33-en-portrait_test_result_1.xml
-				// merged result: MediumPhone.arm-33-en-portrait_test_results_merged.xml
-				if strings.HasSuffix(fileName, "test_results_merged.xml") {
-					mergedTestResultXmlPths = append(mergedTestResultXmlPths, pth)
-				}
-			}
-
-			log.Printf("%d merged test results XML(s) found", len(mergedTestResultXmlPths))
-			log.TDonef("=> %d test Assets downloaded", len(responseModel))
-
-			if err := outputExporter.ExportTestResultsDir(tempDir); err != nil {
-				log.Warnf("Failed to export test assets: %s", err)
-			} else {
-				if err := outputExporter.ExportFlakyTestsEnvVar(mergedTestResultXmlPths); err != nil {
-					log.Warnf("Failed to export flaky tests env var: %s", err)
-				}
-			}
-		}
-	}
-
-	var failedTestRuns []string
-	for dimension, isSuccess := range dimensionToStatus {
-		if !isSuccess {
-			failedTestRuns = append(failedTestRuns, dimension)
-		}
-	}
+	return exitCode(step, runErr)
+}
 
-	if len(failedTestRuns) > 0 {
-		log.Errorf("%d test run(s) failed", len(failedTestRuns))
-		os.Exit(1)
+// exitCode logs and exports the failure of the step and returns the exit code, 0 if there is no error.
+func exitCode(step Step, err error) int {
+	if err == nil {
+		return 0
 	}
+	log.Errorf("%s", err)
+	step.ExportFailure(err)
+	return 1
 }
 
-func downloadFile(url string, localPath string) error {
+// errFileSizeLimitExceeded is returned by downloadFile if the downloaded content is larger than the given limit.
+var errFileSizeLimitExceeded = errors.New("file size limit exceeded")
+
+func downloadFile(client *http.Client, url string, localPath string, maxSize int64) error {
 	out, err := os.Create(localPath)
 	if err != nil {
 		return fmt.Errorf("Failed to open the local cache file for write: %s", err)
@@ -282,7 +79,7 @@ func downloadFile(url string, localPath string) error {
 		}
 	}()
 
-	resp, err := http.Get(url)
+	resp, err := client.Get(url)
 	if err != nil {
 		return fmt.Errorf("Failed to create cache download request: %s", err)
 	}
@@ -296,15 +93,27 @@ func downloadFile(url string, localPath string) error {
 		return fmt.Errorf("Failed to download archive - non success response code: %d", resp.StatusCode)
 	}
 
-	_, err = io.Copy(out, resp.Body)
+	if maxSize > 0 && resp.ContentLength > maxSize {
+		return errFileSizeLimitExceeded
+	}
+
+	var body io.Reader = resp.Body
+	if maxSize > 0 {
+		body = io.LimitReader(resp.Body, maxSize+1)
+	}
+
+	written, err := io.Copy(out, body)
 	if err != nil {
 		return fmt.Errorf("Failed to save cache content into file: %s", err)
 	}
+	if maxSize > 0 && written > maxSize {
+		return errFileSizeLimitExceeded
+	}
 
 	return nil
 }
 
-func uploadFile(uploadURL string, archiveFilePath string) error {
+func uploadFile(client *http.Client, uploadURL string, archiveFilePath string) error {
 	archFile, err := os.Open(archiveFilePath)
 	if err != nil {
 		return fmt.Errorf("Failed to open archive file for upload (%s): %s", archiveFilePath, err)
@@ -333,7 +142,7 @@ func uploadFile(uploadURL string, archiveFilePath string) error {
 	req.Header.Add("Content-Length", strconv.FormatInt(fileSize, 10))
 	req.ContentLength = fileSize
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("Failed to upload: %s", err)
 	}
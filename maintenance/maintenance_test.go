@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -32,6 +33,38 @@ func TestDeviceList(t *testing.T) {
 	}
 }
 
+// TestDeviceABICatalog checks that the step's embedded device ABI catalog matches the device list.
+func TestDeviceABICatalog(t *testing.T) {
+	want := map[string][]string{}
+	var id string
+	for _, line := range strings.Split(deviceList, "\n") {
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch {
+		case key == "id":
+			id = value
+		case strings.HasPrefix(key, "supportedAbis["):
+			want[id] = append(want[id], value)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join("..", "resources", "device_abis.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var catalog map[string][]string
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(catalog, want) {
+		t.Errorf("resources/device_abis.json is out of date, the device list's ABIs: %v", want)
+	}
+}
+
 func checkDeviceList() error {
 	cmd := command.New("gcloud", "firebase", "test", "android", "models", "list", "--format", "text", "--filter=VIRTUAL")
 	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
@@ -64,7 +97,7 @@ func checkDeviceList() error {
 	fmt.Println("Fresh device table to use in the step's descriptor:")
 	fmt.Println(deviceTable)
 
-	return fmt.Errorf("device list has changed, update the corresponding step descriptor blocks")
+	return fmt.Errorf("device list has changed, update the corresponding step descriptor blocks and resources/device_abis.json")
 }
 
 func signIn() error {
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	mediaIndexFileName = "media_index.html"
+	mediaIndexEnvID    = "VDTESTING_MEDIA_INDEX_PATH"
+)
+
+var mediaIndexTemplate = template.Must(template.New("media").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Virtual Device Testing media</title>
+<style>
+body { font-family: sans-serif; }
+.media { display: flex; flex-wrap: wrap; gap: 12px; }
+.media figure { margin: 0; }
+.media img, .media video { max-height: 480px; }
+.skipped { color: #888; }
+</style>
+</head>
+<body>
+<h1>Virtual Device Testing media</h1>
+{{range .}}
+<h2>{{.Dimension}}</h2>
+{{range .Attempts}}
+<h3>{{.Title}}</h3>
+<div class="media">
+{{range .Files}}
+<figure>
+{{if .Skipped}}<p class="skipped">{{.Name}}: skipped, larger than the media size limit</p>
+{{else if eq .Type "video"}}<video controls preload="metadata" src="{{.Path}}"></video>
+{{else}}<a href="{{.Path}}"><img src="{{.Path}}" alt="{{.Name}}"></a>
+{{end}}<figcaption>{{.Name}}</figcaption>
+</figure>
+{{end}}
+</div>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+type mediaGalleryAttempt struct {
+	Title string
+	Files []AssetManifestEntry
+}
+
+type mediaGalleryDevice struct {
+	Dimension string
+	Attempts  []mediaGalleryAttempt
+}
+
+// collectMedia groups the downloaded videos and screenshots by device dimension and attempt.
+func collectMedia(manifest AssetManifest) []mediaGalleryDevice {
+	byDimension := map[string]map[int][]AssetManifestEntry{}
+	for _, entry := range manifest.Files {
+		if !isMedia(entry.Type) {
+			continue
+		}
+
+		dimension := entry.deviceID()
+		if byDimension[dimension] == nil {
+			byDimension[dimension] = map[int][]AssetManifestEntry{}
+		}
+		entry.Path = filepath.ToSlash(entry.Path)
+		byDimension[dimension][entry.Attempt] = append(byDimension[dimension][entry.Attempt], entry)
+	}
+
+	dimensions := make([]string, 0, len(byDimension))
+	for dimension := range byDimension {
+		dimensions = append(dimensions, dimension)
+	}
+	sort.Strings(dimensions)
+
+	var devices []mediaGalleryDevice
+	for _, dimension := range dimensions {
+		attempts := make([]int, 0, len(byDimension[dimension]))
+		for attempt := range byDimension[dimension] {
+			attempts = append(attempts, attempt)
+		}
+		sort.Ints(attempts)
+
+		device := mediaGalleryDevice{Dimension: dimension}
+		for _, attempt := range attempts {
+			title := "All attempts"
+			if attempt > 0 {
+				title = fmt.Sprintf("Attempt %d", attempt)
+			}
+			device.Attempts = append(device.Attempts, mediaGalleryAttempt{
+				Title: title,
+				Files: byDimension[dimension][attempt],
+			})
+		}
+		devices = append(devices, device)
+	}
+
+	return devices
+}
+
+// writeMediaIndex writes an HTML page into the download directory, which shows the videos and screenshots of every device.
+// The page uses relative links, so it keeps working when the directory is archived or moved.
+func writeMediaIndex(manifest AssetManifest, dir string) (string, int, error) {
+	devices := collectMedia(manifest)
+	if len(devices) == 0 {
+		return "", 0, nil
+	}
+
+	pth := filepath.Join(dir, mediaIndexFileName)
+	f, err := os.Create(pth)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create media index: %w", err)
+	}
+
+	if err := mediaIndexTemplate.Execute(f, devices); err != nil {
+		_ = f.Close()
+		return "", 0, fmt.Errorf("failed to write media index: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", 0, fmt.Errorf("failed to close media index: %w", err)
+	}
+
+	count := 0
+	for _, device := range devices {
+		for _, attempt := range device.Attempts {
+			count += len(attempt.Files)
+		}
+	}
+
+	return pth, count, nil
+}
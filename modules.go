@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	toolresults "google.golang.org/api/toolresults/v1beta3"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	moduleResultsFileName = "module_results.json"
+	moduleResultsEnvID    = "VDTESTING_MODULE_RESULTS_PATH"
+)
+
+// ModuleResult is the outcome of the instrumentation test run of a test APK (module)
+type ModuleResult struct {
+	Module  string               `json:"module"`
+	TestApk string               `json:"test_apk"`
+	Passed  bool                 `json:"passed"`
+	Devices []ModuleDeviceResult `json:"devices"`
+	// ResultsDir is the directory of the module's test assets relative to the download directory
+	ResultsDir string `json:"results_dir"`
+}
+
+// ModuleDeviceResult is the outcome of a module's tests on a device, a device passes if at least one attempt passed
+type ModuleDeviceResult struct {
+	Dimension string `json:"dimension"`
+	Passed    bool   `json:"passed"`
+}
+
+func isStepSuccessful(step *toolresults.Step) bool {
+	if step.Outcome == nil {
+		return false
+	}
+	summary := step.Outcome.Summary
+	return summary != "failure" && summary != "inconclusive" && summary != "skipped"
+}
+
+// newModuleResults returns the results of the per module instrumentation test runs, nil if a single test APK was tested.
+func newModuleResults(runs []testRun, runSteps map[string][]*toolresults.Step, testApkPaths []string) []ModuleResult {
+	var results []ModuleResult
+	for _, run := range runs {
+		if run.module == "" {
+			continue
+		}
+
+		dimensionToStatus := map[string]bool{}
+		for _, step := range runSteps[run.name] {
+			dimension := stepDimensionID(step)
+			dimensionToStatus[dimension] = dimensionToStatus[dimension] || isStepSuccessful(step)
+		}
+
+		result := ModuleResult{
+			Module:     run.module,
+			TestApk:    testApkPaths[run.testApkIndex],
+			Passed:     len(dimensionToStatus) > 0,
+			ResultsDir: run.name,
+		}
+		for dimension, passed := range dimensionToStatus {
+			result.Devices = append(result.Devices, ModuleDeviceResult{Dimension: dimension, Passed: passed})
+			result.Passed = result.Passed && passed
+		}
+		sort.Slice(result.Devices, func(i, j int) bool {
+			return result.Devices[i].Dimension < result.Devices[j].Dimension
+		})
+
+		results = append(results, result)
+	}
+	return results
+}
+
+func printModuleResults(results []ModuleResult) {
+	for _, result := range results {
+		passedDevices := 0
+		for _, device := range result.Devices {
+			if device.Passed {
+				passedDevices++
+			}
+		}
+
+		if result.Passed {
+			log.Donef("- %s: passed on %d/%d device(s)", result.Module, passedDevices, len(result.Devices))
+		} else {
+			log.Errorf("- %s: failed, passed on %d/%d device(s)", result.Module, passedDevices, len(result.Devices))
+		}
+	}
+}
+
+func writeModuleResults(results []ModuleResult, pth string) error {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal module results: %w", err)
+	}
+	if err := os.WriteFile(pth, data, 0644); err != nil {
+		return fmt.Errorf("failed to write module results: %w", err)
+	}
+	return nil
+}
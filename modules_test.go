@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	toolresults "google.golang.org/api/toolresults/v1beta3"
+)
+
+func newTestStep(model, outcome string) *toolresults.Step {
+	return &toolresults.Step{
+		DimensionValue: []*toolresults.StepDimensionValueEntry{
+			{Key: "Model", Value: model},
+			{Key: "Version", Value: "33"},
+			{Key: "Locale", Value: "en"},
+			{Key: "Orientation", Value: "portrait"},
+		},
+		Outcome: &toolresults.Outcome{Summary: outcome},
+	}
+}
+
+func TestNewModuleResults(t *testing.T) {
+	runs := []testRun{
+		{name: "login-debug", testType: testTypeInstrumentation, module: "login-debug", testApkIndex: 0},
+		{name: "cart-debug", testType: testTypeInstrumentation, module: "cart-debug", testApkIndex: 1},
+		{name: testTypeRobo, testType: testTypeRobo},
+	}
+	runSteps := map[string][]*toolresults.Step{
+		// the flaky attempt passed on the second try
+		"login-debug": {newTestStep("MediumPhone.arm", "failure"), newTestStep("MediumPhone.arm", "success")},
+		"cart-debug":  {newTestStep("MediumPhone.arm", "success"), newTestStep("Pixel2.arm", "failure")},
+		testTypeRobo:  {newTestStep("MediumPhone.arm", "failure")},
+	}
+
+	got := newModuleResults(runs, runSteps, []string{"login-debug-androidTest.apk", "cart-debug-androidTest.apk"})
+	want := []ModuleResult{
+		{
+			Module:     "login-debug",
+			TestApk:    "login-debug-androidTest.apk",
+			Passed:     true,
+			Devices:    []ModuleDeviceResult{{Dimension: "MediumPhone.arm-33-en-portrait", Passed: true}},
+			ResultsDir: "login-debug",
+		},
+		{
+			Module:  "cart-debug",
+			TestApk: "cart-debug-androidTest.apk",
+			Passed:  false,
+			Devices: []ModuleDeviceResult{
+				{Dimension: "MediumPhone.arm-33-en-portrait", Passed: true},
+				{Dimension: "Pixel2.arm-33-en-portrait", Passed: false},
+			},
+			ResultsDir: "cart-debug",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("newModuleResults() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"archive/zip"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"google.golang.org/api/testing/v1"
+)
+
+// The ABIs of the virtual device models (model ID -> supported ABIs), based on the `supportedAbis` of the
+// `gcloud firebase test android models list` output. Keep it in sync with the device list of the maintenance test.
+//
+//go:embed resources/device_abis.json
+var deviceABICatalogJSON []byte
+
+// Native libraries of an APK (lib/<abi>/) and of an app bundle's modules (<module>/lib/<abi>/)
+var (
+	apkNativeLibRegexp = regexp.MustCompile(`^lib/([^/]+)/[^/]+\.so$`)
+	aabNativeLibRegexp = regexp.MustCompile(`^[^/]+/lib/([^/]+)/[^/]+\.so$`)
+)
+
+// ABIs a device can run besides its own ones
+var compatibleABIs = map[string][]string{
+	"arm64-v8a":   {"armeabi-v7a", "armeabi"},
+	"armeabi-v7a": {"armeabi"},
+	"x86_64":      {"x86"},
+}
+
+func deviceABICatalog() (map[string][]string, error) {
+	var catalog map[string][]string
+	if err := json.Unmarshal(deviceABICatalogJSON, &catalog); err != nil {
+		return nil, fmt.Errorf("failed to parse device ABI catalog: %w", err)
+	}
+	return catalog, nil
+}
+
+// readNativeABIs returns the ABIs the APK or AAB ships native libraries for, an app without native libraries returns none.
+func readNativeABIs(pth string) ([]string, error) {
+	reader, err := zip.OpenReader(pth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", pth, err)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	nativeLibRegexp := apkNativeLibRegexp
+	if isAppBundle(pth) {
+		nativeLibRegexp = aabNativeLibRegexp
+	}
+
+	abiSet := map[string]bool{}
+	for _, file := range reader.File {
+		if match := nativeLibRegexp.FindStringSubmatch(file.Name); match != nil {
+			abiSet[match[1]] = true
+		}
+	}
+
+	var abis []string
+	for abi := range abiSet {
+		abis = append(abis, abi)
+	}
+	sort.Strings(abis)
+
+	return abis, nil
+}
+
+// supportsABIs reports whether a device with the given ABIs can run an app shipping native libraries for appABIs.
+func supportsABIs(deviceABIs, appABIs []string) bool {
+	if len(appABIs) == 0 {
+		return true
+	}
+
+	for _, deviceABI := range deviceABIs {
+		for _, abi := range append([]string{deviceABI}, compatibleABIs[deviceABI]...) {
+			for _, appABI := range appABIs {
+				if abi == appABI {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// splitDevicesByABI splits the devices into the ones able to run the app's native libraries and the incompatible ones.
+// Devices missing from the catalog (physical devices, new models) are considered compatible.
+func splitDevicesByABI(appABIs []string, devices []*testing.AndroidDevice, catalog map[string][]string) (compatible, incompatible []*testing.AndroidDevice) {
+	for _, device := range devices {
+		deviceABIs, ok := catalog[device.AndroidModelId]
+		if ok && !supportsABIs(deviceABIs, appABIs) {
+			incompatible = append(incompatible, device)
+		} else {
+			compatible = append(compatible, device)
+		}
+	}
+	return compatible, incompatible
+}
+
+// nativeABIError describes the devices unable to run the app's native libraries.
+func nativeABIError(appABIs []string, incompatible []*testing.AndroidDevice, catalog map[string][]string) error {
+	var devices []string
+	for _, device := range incompatible {
+		devices = append(devices, fmt.Sprintf("%s,%s (%s)", device.AndroidModelId, device.AndroidVersionId, strings.Join(catalog[device.AndroidModelId], ", ")))
+	}
+	return fmt.Errorf("the app contains native libraries only for %s, not supported by: %s", strings.Join(appABIs, ", "), strings.Join(devices, "; "))
+}
+
+// checkNativeABIs checks the app's native libraries against the test devices. Incompatible devices fail the validation,
+// or are dropped from the test devices if DropIncompatibleDevices is set.
+func (configs *ConfigsModel) checkNativeABIs() error {
+	appABIs, err := readNativeABIs(configs.AppPath)
+	if err != nil {
+		log.Warnf("Warning: failed to inspect the app's native libraries: %s", err)
+		return nil
+	}
+	configs.AppNativeABIs = appABIs
+
+	catalog, err := deviceABICatalog()
+	if err != nil {
+		return err
+	}
+
+	compatible, incompatible := splitDevicesByABI(appABIs, configs.TestDevices, catalog)
+	if len(incompatible) == 0 {
+		return nil
+	}
+
+	abiErr := nativeABIError(appABIs, incompatible, catalog)
+	if !configs.DropIncompatibleDevices {
+		return fmt.Errorf("%s, remove these devices or enable drop_incompatible_devices", abiErr)
+	}
+	if len(compatible) == 0 {
+		return fmt.Errorf("%s, no test devices left", abiErr)
+	}
+
+	log.Warnf("Warning: %s, dropping these devices", abiErr)
+	configs.TestDevices = compatible
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	testingapi "google.golang.org/api/testing/v1"
+)
+
+func writeZip(t *testing.T, pth string, names ...string) {
+	file, err := os.Create(pth)
+	if err != nil {
+		t.Fatalf("failed to create %s: %v", pth, err)
+	}
+	writer := zip.NewWriter(file)
+	for _, name := range names {
+		if _, err := writer.Create(name); err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close %s: %v", pth, err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("failed to close %s: %v", pth, err)
+	}
+}
+
+func TestReadNativeABIs(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name  string
+		file  string
+		names []string
+		want  []string
+	}{
+		{
+			name:  "APK",
+			file:  "app.apk",
+			names: []string{"AndroidManifest.xml", "lib/x86_64/libnative.so", "lib/arm64-v8a/libnative.so", "lib/arm64-v8a/libother.so", "assets/lib/x86/libasset.so"},
+			want:  []string{"arm64-v8a", "x86_64"},
+		},
+		{
+			name:  "AAB",
+			file:  "app.aab",
+			names: []string{"base/manifest/AndroidManifest.xml", "base/lib/armeabi-v7a/libnative.so", "feature/lib/arm64-v8a/libfeature.so"},
+			want:  []string{"arm64-v8a", "armeabi-v7a"},
+		},
+		{
+			name:  "no native libraries",
+			file:  "java.apk",
+			names: []string{"AndroidManifest.xml", "classes.dex"},
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pth := filepath.Join(dir, tt.file)
+			writeZip(t, pth, tt.names...)
+
+			got, err := readNativeABIs(pth)
+			if err != nil {
+				t.Fatalf("readNativeABIs() returned error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readNativeABIs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSupportsABIs(t *testing.T) {
+	tests := []struct {
+		deviceABIs []string
+		appABIs    []string
+		want       bool
+	}{
+		{[]string{"x86"}, nil, true},
+		{[]string{"x86"}, []string{"arm64-v8a"}, false},
+		{[]string{"x86"}, []string{"arm64-v8a", "x86"}, true},
+		{[]string{"x86_64"}, []string{"x86"}, true},
+		{[]string{"arm64-v8a"}, []string{"armeabi-v7a"}, true},
+		{[]string{"arm64-v8a"}, []string{"x86", "x86_64"}, false},
+	}
+	for _, tt := range tests {
+		if got := supportsABIs(tt.deviceABIs, tt.appABIs); got != tt.want {
+			t.Errorf("supportsABIs(%v, %v) = %t, want %t", tt.deviceABIs, tt.appABIs, got, tt.want)
+		}
+	}
+}
+
+func TestDeviceABICatalog(t *testing.T) {
+	catalog, err := deviceABICatalog()
+	if err != nil {
+		t.Fatalf("deviceABICatalog() returned error: %v", err)
+	}
+	if abis := catalog["MediumPhone.arm"]; !reflect.DeepEqual(abis, []string{"arm64-v8a"}) {
+		t.Errorf("MediumPhone.arm ABIs = %v", abis)
+	}
+}
+
+func TestSplitDevicesByABI(t *testing.T) {
+	catalog := map[string][]string{
+		"MediumPhone.arm":  {"arm64-v8a"},
+		"GoogleTvEmulator": {"x86"},
+	}
+	arm := &testingapi.AndroidDevice{AndroidModelId: "MediumPhone.arm", AndroidVersionId: "33"}
+	x86 := &testingapi.AndroidDevice{AndroidModelId: "GoogleTvEmulator", AndroidVersionId: "30"}
+	unknown := &testingapi.AndroidDevice{AndroidModelId: "oriole", AndroidVersionId: "33"}
+
+	compatible, incompatible := splitDevicesByABI([]string{"arm64-v8a"}, []*testingapi.AndroidDevice{arm, x86, unknown}, catalog)
+	if !reflect.DeepEqual(compatible, []*testingapi.AndroidDevice{arm, unknown}) || !reflect.DeepEqual(incompatible, []*testingapi.AndroidDevice{x86}) {
+		t.Errorf("splitDevicesByABI() = %v, %v", compatible, incompatible)
+	}
+
+	want := "the app contains native libraries only for arm64-v8a, not supported by: GoogleTvEmulator,30 (x86)"
+	if err := nativeABIError([]string{"arm64-v8a"}, incompatible, catalog); err.Error() != want {
+		t.Errorf("nativeABIError() = %s, want %s", err, want)
+	}
+}
+
+func TestCheckNativeABIs(t *testing.T) {
+	pth := filepath.Join(t.TempDir(), "app.apk")
+	writeZip(t, pth, "AndroidManifest.xml", "lib/arm64-v8a/libnative.so")
+
+	arm := &testingapi.AndroidDevice{AndroidModelId: "MediumPhone.arm", AndroidVersionId: "33"}
+	x86 := &testingapi.AndroidDevice{AndroidModelId: "GoogleTvEmulator", AndroidVersionId: "30"}
+
+	configs := ConfigsModel{AppPath: pth, TestDevices: []*testingapi.AndroidDevice{arm, x86}}
+	if err := configs.checkNativeABIs(); err == nil {
+		t.Errorf("checkNativeABIs() expected error for the x86 device")
+	}
+
+	configs.DropIncompatibleDevices = true
+	if err := configs.checkNativeABIs(); err != nil {
+		t.Fatalf("checkNativeABIs() returned error: %v", err)
+	}
+	if !reflect.DeepEqual(configs.TestDevices, []*testingapi.AndroidDevice{arm}) {
+		t.Errorf("TestDevices = %v, want only the arm device", configs.TestDevices)
+	}
+	if !reflect.DeepEqual(configs.AppNativeABIs, []string{"arm64-v8a"}) {
+		t.Errorf("AppNativeABIs = %v", configs.AppNativeABIs)
+	}
+
+	configs.TestDevices = []*testingapi.AndroidDevice{x86}
+	if err := configs.checkNativeABIs(); err == nil {
+		t.Errorf("checkNativeABIs() expected error when no devices are left")
+	}
+}
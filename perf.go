@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	toolresults "google.golang.org/api/toolresults/v1beta3"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	perfMetricsFileName = "perf_metrics.json"
+	perfMetricsEnvID    = "VDTESTING_PERF_METRICS_PATH"
+)
+
+const (
+	perfSeriesCPUTotal    = "cpuTotal"
+	perfSeriesMemoryTotal = "memoryTotal"
+)
+
+// PerfSeriesStats summarizes the samples of a performance sample series
+type PerfSeriesStats struct {
+	Label   string  `json:"label"`
+	Type    string  `json:"type"`
+	Unit    string  `json:"unit"`
+	Peak    float64 `json:"peak"`
+	Average float64 `json:"average"`
+	Samples int     `json:"samples"`
+}
+
+// StepPerfMetrics contains the performance metrics of a single test step (device and attempt)
+type StepPerfMetrics struct {
+	StepID        string                     `json:"step_id"`
+	Dimension     string                     `json:"dimension"`
+	PerfMetrics   []string                   `json:"perf_metrics,omitempty"`
+	AppStartTime  *toolresults.AppStartTime  `json:"app_start_time,omitempty"`
+	GraphicsStats *toolresults.GraphicsStats `json:"graphics_stats,omitempty"`
+	Series        []PerfSeriesStats          `json:"series"`
+}
+
+// PerfMetricsReport is the exported performance metrics of the test run
+type PerfMetricsReport struct {
+	Steps []StepPerfMetrics `json:"steps"`
+}
+
+func newPerfSeriesStats(series PerfSampleSeries) (PerfSeriesStats, bool) {
+	if series.Series == nil || series.Series.BasicPerfSampleSeries == nil || len(series.Samples) == 0 {
+		return PerfSeriesStats{}, false
+	}
+
+	stats := PerfSeriesStats{
+		Label: series.Series.BasicPerfSampleSeries.SampleSeriesLabel,
+		Type:  series.Series.BasicPerfSampleSeries.PerfMetricType,
+		Unit:  series.Series.BasicPerfSampleSeries.PerfUnit,
+	}
+
+	sum := 0.0
+	for _, sample := range series.Samples {
+		if sample == nil {
+			continue
+		}
+		if stats.Samples == 0 || sample.Value > stats.Peak {
+			stats.Peak = sample.Value
+		}
+		sum += sample.Value
+		stats.Samples++
+	}
+	if stats.Samples == 0 {
+		return PerfSeriesStats{}, false
+	}
+	stats.Average = sum / float64(stats.Samples)
+
+	return stats, true
+}
+
+func newStepPerfMetrics(run testRun, step *toolresults.Step, perfMetrics PerfMetrics) StepPerfMetrics {
+	metrics := StepPerfMetrics{
+		StepID:    step.StepId,
+		Dimension: runStepDimensionID(run, step),
+	}
+	if perfMetrics.Summary != nil {
+		metrics.PerfMetrics = perfMetrics.Summary.PerfMetrics
+		metrics.AppStartTime = perfMetrics.Summary.AppStartTime
+		metrics.GraphicsStats = perfMetrics.Summary.GraphicsStats
+	}
+
+	for _, series := range perfMetrics.SampleSeries {
+		if stats, ok := newPerfSeriesStats(series); ok {
+			metrics.Series = append(metrics.Series, stats)
+		}
+	}
+	sort.Slice(metrics.Series, func(i, j int) bool {
+		return metrics.Series[i].Label < metrics.Series[j].Label
+	})
+
+	return metrics
+}
+
+// stepDimensionID returns the same device identifier as the downloaded file names are prefixed with.
+func stepDimensionID(step *toolresults.Step) string {
+	dimensions := map[string]string{}
+	for _, dimension := range step.DimensionValue {
+		dimensions[dimension.Key] = dimension.Value
+	}
+	return fmt.Sprintf("%s-%s-%s-%s", dimensions["Model"], dimensions["Version"], dimensions["Locale"], dimensions["Orientation"])
+}
+
+// runStepDimensionID returns the stepDimensionID prefixed with the test run name if the step started multiple test matrices,
+// like the directories of the downloaded test assets.
+func runStepDimensionID(run testRun, step *toolresults.Step) string {
+	if run.name != "" {
+		return run.name + "/" + stepDimensionID(step)
+	}
+	return stepDimensionID(step)
+}
+
+func (m StepPerfMetrics) series(label string) (PerfSeriesStats, bool) {
+	for _, series := range m.Series {
+		if series.Label == label {
+			return series, true
+		}
+	}
+	return PerfSeriesStats{}, false
+}
+
+// peakMemoryMB returns the peak of the total memory usage in megabytes.
+func (m StepPerfMetrics) peakMemoryMB() (float64, bool) {
+	memory, ok := m.series(perfSeriesMemoryTotal)
+	if !ok {
+		return 0, false
+	}
+	return kibibytesToMB(memory.Peak), true
+}
+
+func kibibytesToMB(value float64) float64 {
+	return value / 1024
+}
+
+// collectPerfMetrics collects the performance metrics of the test runs' steps, skipped steps have no metrics.
+func collectPerfMetrics(backend testBackend, runs []testRun, runSteps map[string][]*toolresults.Step) (PerfMetricsReport, error) {
+	var report PerfMetricsReport
+	for _, run := range runs {
+		for _, step := range runSteps[run.name] {
+			if step.StepId == "" || (step.Outcome != nil && step.Outcome.Summary == "skipped") {
+				continue
+			}
+
+			perfMetrics, err := backend.GetPerfMetrics(run, step.StepId)
+			if err != nil {
+				return PerfMetricsReport{}, fmt.Errorf("step (%s): %w", step.StepId, err)
+			}
+			report.Steps = append(report.Steps, newStepPerfMetrics(run, step, perfMetrics))
+		}
+	}
+	return report, nil
+}
+
+func printPerfMetricsReport(report PerfMetricsReport) {
+	w := tabwriter.NewWriter(logOutput, 0, 0, 3, ' ', 0)
+	if _, err := fmt.Fprintln(w, "Device\tPeak CPU\tAvg CPU\tPeak memory\tAvg memory\t"); err != nil {
+		log.Errorf("Failed to write in tabwriter, error: %s", err)
+		return
+	}
+
+	for _, step := range report.Steps {
+		peakCPU, avgCPU, peakMemory, avgMemory := "-", "-", "-", "-"
+		if cpu, ok := step.series(perfSeriesCPUTotal); ok {
+			peakCPU = fmt.Sprintf("%.1f%%", cpu.Peak)
+			avgCPU = fmt.Sprintf("%.1f%%", cpu.Average)
+		}
+		if memory, ok := step.series(perfSeriesMemoryTotal); ok {
+			peakMemory = fmt.Sprintf("%.1f MB", kibibytesToMB(memory.Peak))
+			avgMemory = fmt.Sprintf("%.1f MB", kibibytesToMB(memory.Average))
+		}
+
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t\n", step.Dimension, peakCPU, avgCPU, peakMemory, avgMemory); err != nil {
+			log.Errorf("Failed to write in tabwriter, error: %s", err)
+			return
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		log.Errorf("Failed to flush writer, error: %s", err)
+	}
+}
+
+func writePerfMetricsReport(report PerfMetricsReport, pth string) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal performance metrics: %w", err)
+	}
+	if err := os.WriteFile(pth, data, 0644); err != nil {
+		return fmt.Errorf("failed to write performance metrics: %w", err)
+	}
+	return nil
+}
+
+// checkPeakMemory returns the devices whose peak memory usage exceeded the limit (in megabytes) as violations,
+// and the devices without memory data as warnings.
+func checkPeakMemory(report PerfMetricsReport, limitMB int) ([]string, []string) {
+	if limitMB <= 0 {
+		return nil, nil
+	}
+
+	var violations, warnings []string
+	for _, step := range report.Steps {
+		peak, ok := step.peakMemoryMB()
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("%s: no memory data, the %d MB peak memory limit can't be checked", step.Dimension, limitMB))
+		} else if peak > float64(limitMB) {
+			violations = append(violations, fmt.Sprintf("%s: peak memory %.1f MB exceeds the %d MB limit", step.Dimension, peak, limitMB))
+		}
+	}
+	return violations, warnings
+}
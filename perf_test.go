@@ -0,0 +1,128 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	toolresults "google.golang.org/api/toolresults/v1beta3"
+)
+
+func TestNewStepPerfMetrics(t *testing.T) {
+	step := &toolresults.Step{
+		StepId: "step-1",
+		DimensionValue: []*toolresults.StepDimensionValueEntry{
+			{Key: "Model", Value: "MediumPhone.arm"},
+			{Key: "Version", Value: "33"},
+			{Key: "Locale", Value: "en"},
+			{Key: "Orientation", Value: "portrait"},
+		},
+	}
+	perfMetrics := PerfMetrics{
+		SampleSeries: []PerfSampleSeries{
+			{
+				Series: &toolresults.PerfSampleSeries{BasicPerfSampleSeries: &toolresults.BasicPerfSampleSeries{
+					PerfMetricType: "memory", PerfUnit: "kibibyte", SampleSeriesLabel: perfSeriesMemoryTotal,
+				}},
+				Samples: []*toolresults.PerfSample{{Value: 102400}, {Value: 307200}, {Value: 204800}},
+			},
+			{
+				Series: &toolresults.PerfSampleSeries{BasicPerfSampleSeries: &toolresults.BasicPerfSampleSeries{
+					PerfMetricType: "cpu", PerfUnit: "percent", SampleSeriesLabel: perfSeriesCPUTotal,
+				}},
+				Samples: []*toolresults.PerfSample{{Value: 10}, {Value: 30}},
+			},
+			{
+				Series: &toolresults.PerfSampleSeries{BasicPerfSampleSeries: &toolresults.BasicPerfSampleSeries{
+					PerfMetricType: "network", PerfUnit: "bytesPerSecond", SampleSeriesLabel: "ntBytesReceived",
+				}},
+			},
+		},
+	}
+
+	got := newStepPerfMetrics(testRun{testType: testTypeRobo}, step, perfMetrics)
+	want := StepPerfMetrics{
+		StepID:    "step-1",
+		Dimension: "MediumPhone.arm-33-en-portrait",
+		Series: []PerfSeriesStats{
+			{Label: perfSeriesCPUTotal, Type: "cpu", Unit: "percent", Peak: 30, Average: 20, Samples: 2},
+			{Label: perfSeriesMemoryTotal, Type: "memory", Unit: "kibibyte", Peak: 307200, Average: 204800, Samples: 3},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("newStepPerfMetrics() = %+v, want %+v", got, want)
+	}
+
+	report := PerfMetricsReport{Steps: []StepPerfMetrics{got}}
+	if violations, _ := checkPeakMemory(report, 300); len(violations) != 0 {
+		t.Errorf("checkPeakMemory(300) = %v, want no violations", violations)
+	}
+	if violations, _ := checkPeakMemory(report, 250); len(violations) != 1 {
+		t.Errorf("checkPeakMemory(250) = %v, want 1 violation", violations)
+	}
+
+	report.Steps = append(report.Steps, StepPerfMetrics{Dimension: "Pixel2.arm-30-en-portrait"})
+	violations, warnings := checkPeakMemory(report, 300)
+	if len(violations) != 0 {
+		t.Errorf("checkPeakMemory(300) violations = %v, want none for the device without memory data", violations)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "no memory data") {
+		t.Errorf("checkPeakMemory(300) warnings = %v, want a warning for the device without memory data", warnings)
+	}
+}
+
+func TestRunStepDimensionID(t *testing.T) {
+	step := &toolresults.Step{DimensionValue: []*toolresults.StepDimensionValueEntry{
+		{Key: "Model", Value: "MediumPhone.arm"},
+		{Key: "Version", Value: "33"},
+		{Key: "Locale", Value: "en"},
+		{Key: "Orientation", Value: "portrait"},
+	}}
+
+	if got, want := runStepDimensionID(testRun{testType: testTypeRobo}, step), "MediumPhone.arm-33-en-portrait"; got != want {
+		t.Errorf("single test run dimension = %s, want %s", got, want)
+	}
+	if got, want := runStepDimensionID(testRun{name: "login-debug", testType: testTypeInstrumentation}, step), "login-debug/MediumPhone.arm-33-en-portrait"; got != want {
+		t.Errorf("module test run dimension = %s, want %s", got, want)
+	}
+}
+
+// perfMetricsBackend answers the performance metrics requests with an empty report, recording the requested steps.
+type perfMetricsBackend struct {
+	testBackend
+	stepIDs []string
+}
+
+func (b *perfMetricsBackend) GetPerfMetrics(_ testRun, stepID string) (PerfMetrics, error) {
+	b.stepIDs = append(b.stepIDs, stepID)
+	return PerfMetrics{}, nil
+}
+
+func TestCollectPerfMetrics(t *testing.T) {
+	dimensions := []*toolresults.StepDimensionValueEntry{
+		{Key: "Model", Value: "MediumPhone.arm"},
+		{Key: "Version", Value: "33"},
+		{Key: "Locale", Value: "en"},
+		{Key: "Orientation", Value: "portrait"},
+	}
+	runs := []testRun{{name: testTypeInstrumentation, testType: testTypeInstrumentation}, {name: testTypeRobo, testType: testTypeRobo}}
+	runSteps := map[string][]*toolresults.Step{
+		testTypeInstrumentation: {{StepId: "step-a", DimensionValue: dimensions, Outcome: &toolresults.Outcome{Summary: "skipped"}}},
+		testTypeRobo:            {{StepId: "step-b", DimensionValue: dimensions, Outcome: &toolresults.Outcome{Summary: "success"}}},
+	}
+
+	backend := &perfMetricsBackend{}
+	report, err := collectPerfMetrics(backend, runs, runSteps)
+	if err != nil {
+		t.Fatalf("collectPerfMetrics() error = %v", err)
+	}
+	if want := []string{"step-b"}; !reflect.DeepEqual(backend.stepIDs, want) {
+		t.Errorf("requested steps = %v, want %v", backend.stepIDs, want)
+	}
+	if len(report.Steps) != 1 || report.Steps[0].Dimension != "robo/MediumPhone.arm-33-en-portrait" {
+		t.Fatalf("report steps = %+v, want the robo step", report.Steps)
+	}
+	if violations, warnings := checkPeakMemory(report, 300); len(violations) != 0 || len(warnings) != 1 {
+		t.Errorf("checkPeakMemory(300) = %v, %v, want a warning for the step without memory data", violations, warnings)
+	}
+}
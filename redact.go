@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const redactedValue = "[REDACTED]"
+
+// minSecretLength keeps short env var values (like "true" or "1") from being masked everywhere in the log
+const minSecretLength = 6
+
+// signedURLQueryPattern matches the query string of signed Cloud Storage URLs (V2 and V4 signatures)
+var signedURLQueryPattern = regexp.MustCompile(`\?[^\s"'<>(),]*(?:Signature|X-Goog-Credential)=[^\s"'<>(),]*`)
+
+// sensitiveEnvNamePattern matches the names of env vars whose values are masked in the log
+var sensitiveEnvNamePattern = regexp.MustCompile(`(?i)(TOKEN|SECRET|PASSWORD|PASSPHRASE|PRIVATE_KEY|API_KEY|ACCESS_KEY|CREDENTIAL)`)
+
+// logOutput is the output of the step's log, every message is redacted before it is written to stdout.
+var logOutput = newLogRedactor(os.Stdout)
+
+// logRedactor is an io.Writer masking the secrets and the signed URL query strings in the written text.
+type logRedactor struct {
+	out io.Writer
+
+	mu      sync.Mutex
+	secrets []string
+}
+
+func newLogRedactor(out io.Writer) *logRedactor {
+	return &logRedactor{out: out}
+}
+
+// addSecrets registers values to be masked, empty values are ignored.
+func (r *logRedactor) addSecrets(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, secret := range secrets {
+		secret = strings.TrimSpace(secret)
+		if secret == "" {
+			continue
+		}
+		r.secrets = append(r.secrets, secret)
+	}
+	// longer secrets first, so a secret containing another one is masked entirely
+	sort.SliceStable(r.secrets, func(i, j int) bool {
+		return len(r.secrets[i]) > len(r.secrets[j])
+	})
+}
+
+// addSensitiveEnvs registers the values of the env vars which look like secrets by their name.
+func (r *logRedactor) addSensitiveEnvs(environ []string) {
+	var secrets []string
+	for _, env := range environ {
+		name, value, ok := strings.Cut(env, "=")
+		if !ok || len(value) < minSecretLength || !sensitiveEnvNamePattern.MatchString(name) {
+			continue
+		}
+		secrets = append(secrets, value)
+	}
+	r.addSecrets(secrets...)
+}
+
+// redact masks the registered secrets and the signed URL query strings.
+func (r *logRedactor) redact(s string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, redactedValue)
+	}
+	return signedURLQueryPattern.ReplaceAllString(s, "?"+redactedValue)
+}
+
+func (r *logRedactor) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.out, r.redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
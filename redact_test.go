@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLogRedactor(t *testing.T) {
+	r := newLogRedactor(nil)
+	r.addSecrets("api-token", "", "  ")
+	r.addSensitiveEnvs([]string{
+		"ADDON_VDTESTING_API_TOKEN=env-token-value",
+		"KEYSTORE_PASSWORD=keystore-pass",
+		"MY_SECRET=short",
+		"BITRISE_APP_SLUG=app-slug-value",
+		"MALFORMED",
+	})
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "token in URL path",
+			in:   "GET https://vdt.bitrise.io/test/app/build/api-token/robo",
+			want: "GET https://vdt.bitrise.io/test/app/build/[REDACTED]/robo",
+		},
+		{
+			name: "sensitive env var values",
+			in:   "token: env-token-value, password: keystore-pass",
+			want: "token: [REDACTED], password: [REDACTED]",
+		},
+		{
+			name: "short and not sensitive env var values are kept",
+			in:   "short app-slug-value",
+			want: "short app-slug-value",
+		},
+		{
+			name: "signed V4 URL",
+			in:   "failed to upload file(app.apk) to (https://storage.googleapis.com/bucket/app.apk?X-Goog-Algorithm=GOOG4-RSA-SHA256&X-Goog-Credential=sa%40project&X-Goog-Signature=abcd), error: EOF",
+			want: "failed to upload file(app.apk) to (https://storage.googleapis.com/bucket/app.apk?[REDACTED]), error: EOF",
+		},
+		{
+			name: "signed V2 URL",
+			in:   "https://storage.googleapis.com/bucket/app.apk?GoogleAccessId=sa&Expires=1700000000&Signature=abc%2Bd",
+			want: "https://storage.googleapis.com/bucket/app.apk?[REDACTED]",
+		},
+		{
+			name: "not signed URL",
+			in:   "https://storage.googleapis.com/storage/v1/b/bucket/o?prefix=results",
+			want: "https://storage.googleapis.com/storage/v1/b/bucket/o?prefix=results",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.redact(tt.in); got != tt.want {
+				t.Errorf("redact() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogRedactor_Write(t *testing.T) {
+	var out bytes.Buffer
+	r := newLogRedactor(&out)
+	r.addSecrets("api-token")
+
+	in := "Failed to get robo test status, URL: /app/build/api-token\n"
+	n, err := r.Write([]byte(in))
+	if err != nil || n != len(in) {
+		t.Errorf("Write() = %d, %v, want %d, nil", n, err, len(in))
+	}
+	if got, want := out.String(), "Failed to get robo test status, URL: /app/build/[REDACTED]\n"; got != want {
+		t.Errorf("written = %q, want %q", got, want)
+	}
+}
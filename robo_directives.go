@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/testing/v1"
+	"gopkg.in/yaml.v3"
+)
+
+// Robo directive action types, see https://firebase.google.com/docs/test-lab/reference/testing/rest/v1/projects.testMatrices#RoboActionType
+const (
+	roboActionEnterText   = "ENTER_TEXT"
+	roboActionSingleClick = "SINGLE_CLICK"
+	roboActionIgnore      = "IGNORE"
+)
+
+var roboActionTypes = []string{roboActionEnterText, roboActionSingleClick, roboActionIgnore}
+
+// maskedValue replaces the input texts of robo directives in logs.
+const maskedValue = "[REDACTED]"
+
+var envVarNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type roboDirectiveYAML struct {
+	ResourceName string `yaml:"resource_name"`
+	InputText    string `yaml:"input_text"`
+	ActionType   string `yaml:"action_type"`
+}
+
+// parseRoboDirectives parses the robo directives input, which is either
+//   - CSV: one `ResourceName,InputText,ActionType` directive per line, fields containing commas can be quoted (`"a,b"`),
+//   - or a YAML list of `resource_name`, `input_text` and `action_type` mappings.
+//
+// The `$NAME` and `${NAME}` references in the input texts are expanded from the env, `$$` is a literal `$`.
+// The expanded input texts are registered as secrets of the log.
+func parseRoboDirectives(roboDirectives string) ([]*testing.RoboDirective, error) {
+	var directives []*testing.RoboDirective
+	var err error
+	if isYAMLRoboDirectives(roboDirectives) {
+		directives, err = parseYAMLRoboDirectives(roboDirectives)
+	} else {
+		directives, err = parseCSVRoboDirectives(roboDirectives)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, directive := range directives {
+		if err := validateRoboDirective(directive.ResourceName, directive.InputText, directive.ActionType); err != nil {
+			return nil, fmt.Errorf("directive %d: %s", i+1, err)
+		}
+		if directive.InputText, err = expandEnvVarReferences(directive.InputText); err != nil {
+			return nil, fmt.Errorf("directive %d (%s): %s", i+1, directive.ResourceName, err)
+		}
+	}
+
+	return directives, nil
+}
+
+func isYAMLRoboDirectives(roboDirectives string) bool {
+	for _, line := range strings.Split(roboDirectives, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return line == "-" || strings.HasPrefix(line, "- ")
+	}
+	return false
+}
+
+func parseCSVRoboDirectives(roboDirectives string) ([]*testing.RoboDirective, error) {
+	reader := csv.NewReader(strings.NewReader(roboDirectives))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.Comment = '#'
+
+	var directives []*testing.RoboDirective
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid directive configuration: %s", err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		if len(record) != 3 {
+			return nil, fmt.Errorf("line %d: invalid directive configuration, should be `ResourceName,InputText,ActionType` (quote fields containing commas), got %d fields", line, len(record))
+		}
+		directives = append(directives, &testing.RoboDirective{
+			ResourceName: strings.TrimSpace(record[0]),
+			InputText:    record[1],
+			ActionType:   strings.TrimSpace(record[2]),
+		})
+	}
+
+	return directives, nil
+}
+
+func parseYAMLRoboDirectives(roboDirectives string) ([]*testing.RoboDirective, error) {
+	var items []roboDirectiveYAML
+	decoder := yaml.NewDecoder(strings.NewReader(roboDirectives))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&items); err != nil {
+		return nil, fmt.Errorf("invalid directive configuration: %s", err)
+	}
+
+	var directives []*testing.RoboDirective
+	for _, item := range items {
+		directives = append(directives, &testing.RoboDirective{
+			ResourceName: strings.TrimSpace(item.ResourceName),
+			InputText:    item.InputText,
+			ActionType:   strings.TrimSpace(item.ActionType),
+		})
+	}
+
+	return directives, nil
+}
+
+// validateRoboDirective checks a directive's fields, the input text is only allowed for ENTER_TEXT actions.
+func validateRoboDirective(resourceName, inputText, actionType string) error {
+	if resourceName == "" {
+		return fmt.Errorf("resource name is missing")
+	}
+
+	valid := false
+	for _, t := range roboActionTypes {
+		valid = valid || actionType == t
+	}
+	if !valid {
+		return fmt.Errorf("invalid action type (%s) for %s, should be one of %s", actionType, resourceName, strings.Join(roboActionTypes, ", "))
+	}
+
+	if actionType != roboActionEnterText && inputText != "" {
+		return fmt.Errorf("input text is only supported by %s actions, %s of %s has one", roboActionEnterText, actionType, resourceName)
+	}
+
+	return nil
+}
+
+// expandEnvVarReferences expands the env var references of the text, like Bitrise expands the inputs:
+// the text is expanded only once, so the values are used as they are, even if they contain `$` or `,`.
+// The expanded text and the referenced values are masked in the log, as they can be secrets of any name.
+func expandEnvVarReferences(text string) (string, error) {
+	var missing []string
+	var values []string
+	expanded := os.Expand(text, func(name string) string {
+		switch {
+		case name == "$":
+			return "$"
+		case !envVarNameRegexp.MatchString(name):
+			// shell special variables ($1, $?, ...) are not expanded
+			return "$" + name
+		}
+
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+			return ""
+		}
+		values = append(values, value)
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("input text references the %s env var, which is not set", strings.Join(missing, ", "))
+	}
+
+	if expanded != text {
+		logOutput.addSecrets(expanded)
+		for _, value := range values {
+			// short values (like a build number) would mask unrelated parts of the log, the expanded text covers them
+			if len(value) >= minSecretLength {
+				logOutput.addSecrets(value)
+			}
+		}
+	}
+	return expanded, nil
+}
+
+// roboDirectivesString lists the directives for logging, with the input texts masked.
+func roboDirectivesString(directives []*testing.RoboDirective) string {
+	var lines []string
+	for _, directive := range directives {
+		inputText := ""
+		if directive.InputText != "" {
+			inputText = maskedValue
+		}
+		lines = append(lines, strings.Join([]string{directive.ResourceName, inputText, directive.ActionType}, ","))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// maskedTestMatrix returns a copy of the test matrix with the robo directives' input texts masked, to be logged or
+// written to the deploy directory.
+func maskedTestMatrix(testMatrix *testing.TestMatrix) *testing.TestMatrix {
+	if testMatrix.TestSpecification == nil || testMatrix.TestSpecification.AndroidRoboTest == nil {
+		return testMatrix
+	}
+
+	roboTest := *testMatrix.TestSpecification.AndroidRoboTest
+	roboTest.RoboDirectives = nil
+	for _, directive := range testMatrix.TestSpecification.AndroidRoboTest.RoboDirectives {
+		masked := *directive
+		if masked.InputText != "" {
+			masked.InputText = maskedValue
+		}
+		roboTest.RoboDirectives = append(roboTest.RoboDirectives, &masked)
+	}
+
+	testSpecification := *testMatrix.TestSpecification
+	testSpecification.AndroidRoboTest = &roboTest
+	masked := *testMatrix
+	masked.TestSpecification = &testSpecification
+	return &masked
+}
+
+// roboDirectivesCSV formats the config file's directives as the robo directives input, quoting the fields where needed.
+func roboDirectivesCSV(directives []TestMatrixDirective) string {
+	var b strings.Builder
+	writer := csv.NewWriter(&b)
+	for _, directive := range directives {
+		// writing into a strings.Builder does not fail
+		_ = writer.Write([]string{directive.ResourceName, directive.InputText, directive.ActionType})
+	}
+	writer.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	testingapi "google.golang.org/api/testing/v1"
+)
+
+func TestParseRoboDirectives(t *testing.T) {
+	t.Setenv("LOGIN_PASSWORD", "s3cr,et")
+	t.Setenv("DOLLAR_PASSWORD", `$HOME,"pa$$word`)
+	t.Setenv("BUILD_NUMBER", "42")
+
+	tests := []struct {
+		name           string
+		roboDirectives string
+		want           []*testingapi.RoboDirective
+		wantErr        string
+	}{
+		{
+			name:           "empty",
+			roboDirectives: "",
+			want:           nil,
+		},
+		{
+			name:           "CSV",
+			roboDirectives: "username_resource,username,ENTER_TEXT\n\n# login\npassword_resource,\"pass, word\",ENTER_TEXT\nloginbtn_resource,,SINGLE_CLICK\n",
+			want: []*testingapi.RoboDirective{
+				{ResourceName: "username_resource", InputText: "username", ActionType: "ENTER_TEXT"},
+				{ResourceName: "password_resource", InputText: "pass, word", ActionType: "ENTER_TEXT"},
+				{ResourceName: "loginbtn_resource", ActionType: "SINGLE_CLICK"},
+			},
+		},
+		{
+			name:           "CSV with env var references",
+			roboDirectives: "password_resource,$LOGIN_PASSWORD,ENTER_TEXT\nother_resource,${LOGIN_PASSWORD},ENTER_TEXT\nprice_resource,$$5,ENTER_TEXT",
+			want: []*testingapi.RoboDirective{
+				{ResourceName: "password_resource", InputText: "s3cr,et", ActionType: "ENTER_TEXT"},
+				{ResourceName: "other_resource", InputText: "s3cr,et", ActionType: "ENTER_TEXT"},
+				{ResourceName: "price_resource", InputText: "$5", ActionType: "ENTER_TEXT"},
+			},
+		},
+		{
+			name:           "env var value starting with $ and containing a comma",
+			roboDirectives: "password_resource,$DOLLAR_PASSWORD,ENTER_TEXT",
+			want: []*testingapi.RoboDirective{
+				{ResourceName: "password_resource", InputText: `$HOME,"pa$$word`, ActionType: "ENTER_TEXT"},
+			},
+		},
+		{
+			name:           "inline env var references",
+			roboDirectives: "username_resource,user_$BUILD_NUMBER,ENTER_TEXT\nother_resource,$BUILD_NUMBER${BUILD_NUMBER}-$$1,ENTER_TEXT",
+			want: []*testingapi.RoboDirective{
+				{ResourceName: "username_resource", InputText: "user_42", ActionType: "ENTER_TEXT"},
+				{ResourceName: "other_resource", InputText: "4242-$1", ActionType: "ENTER_TEXT"},
+			},
+		},
+		{
+			name:           "YAML",
+			roboDirectives: "- resource_name: password_resource\n  input_text: \"a,b\"\n  action_type: ENTER_TEXT\n- resource_name: ad_banner\n  action_type: IGNORE\n",
+			want: []*testingapi.RoboDirective{
+				{ResourceName: "password_resource", InputText: "a,b", ActionType: "ENTER_TEXT"},
+				{ResourceName: "ad_banner", ActionType: "IGNORE"},
+			},
+		},
+		{
+			name:           "too many CSV fields",
+			roboDirectives: "username_resource,user,name,ENTER_TEXT",
+			wantErr:        "line 1: invalid directive configuration",
+		},
+		{
+			name:           "invalid action type",
+			roboDirectives: "loginbtn_resource,,CLICK",
+			wantErr:        "directive 1: invalid action type (CLICK) for loginbtn_resource",
+		},
+		{
+			name:           "input text of a click",
+			roboDirectives: "loginbtn_resource,text,SINGLE_CLICK",
+			wantErr:        "directive 1: input text is only supported by ENTER_TEXT actions",
+		},
+		{
+			name:           "unset env var",
+			roboDirectives: "password_resource,pass_$MISSING_PASSWORD,ENTER_TEXT",
+			wantErr:        "input text references the MISSING_PASSWORD env var, which is not set",
+		},
+		{
+			name:           "unknown YAML field",
+			roboDirectives: "- resource: password_resource\n",
+			wantErr:        "field resource not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRoboDirectives(tt.roboDirectives)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseRoboDirectives() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseRoboDirectives() returned error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRoboDirectives() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoboDirectivesMasking(t *testing.T) {
+	directives := []*testingapi.RoboDirective{
+		{ResourceName: "password_resource", InputText: "secret", ActionType: "ENTER_TEXT"},
+		{ResourceName: "loginbtn_resource", ActionType: "SINGLE_CLICK"},
+	}
+
+	if got, want := roboDirectivesString(directives), "password_resource,[REDACTED],ENTER_TEXT\nloginbtn_resource,,SINGLE_CLICK"; got != want {
+		t.Errorf("roboDirectivesString() = %q, want %q", got, want)
+	}
+
+	testMatrix := &testingapi.TestMatrix{TestSpecification: &testingapi.TestSpecification{
+		AndroidRoboTest: &testingapi.AndroidRoboTest{RoboDirectives: directives},
+	}}
+	masked := maskedTestMatrix(testMatrix)
+	if got := masked.TestSpecification.AndroidRoboTest.RoboDirectives[0].InputText; got != maskedValue {
+		t.Errorf("masked input text = %s", got)
+	}
+	if got := directives[0].InputText; got != "secret" {
+		t.Errorf("maskedTestMatrix() modified the original directive: %s", got)
+	}
+}
+
+func TestRoboDirectivesCSV(t *testing.T) {
+	directives := []TestMatrixDirective{
+		{ResourceName: "password_resource", InputText: "a,\"b\"", ActionType: "ENTER_TEXT"},
+		{ResourceName: "loginbtn_resource", ActionType: "SINGLE_CLICK"},
+	}
+
+	got, err := parseRoboDirectives(roboDirectivesCSV(directives))
+	if err != nil {
+		t.Fatalf("parseRoboDirectives() returned error: %v", err)
+	}
+	want := []*testingapi.RoboDirective{
+		{ResourceName: "password_resource", InputText: "a,\"b\"", ActionType: "ENTER_TEXT"},
+		{ResourceName: "loginbtn_resource", ActionType: "SINGLE_CLICK"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip = %v, want %v", got, want)
+	}
+}
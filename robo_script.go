@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Robo script event types and whether they require element descriptors,
+// see https://firebase.google.com/docs/test-lab/android/robo-scripts-reference
+var roboScriptEventTypes = map[string]bool{
+	"ADB_SHELL_COMMAND":      false,
+	"ALL_ELEMENTS_IGNORED":   false,
+	"DELAYED_MESSAGE_POSTED": false,
+	"ELEMENT_IGNORED":        true,
+	"POINT_TAP":              false,
+	"PRESSED_BACK":           false,
+	"PRESSED_EDITOR_ACTION":  true,
+	"VIEW_CLICKED":           true,
+	"VIEW_LONG_CLICKED":      true,
+	"VIEW_SWIPED":            false,
+	"VIEW_TEXT_CHANGED":      true,
+	"WAIT":                   false,
+	"WAIT_FOR_ELEMENT":       true,
+	"WINDOW_CHANGED":         false,
+}
+
+// Robo script context descriptor conditions and whether they require element descriptors
+var roboScriptConditions = map[string]bool{
+	"app_under_test_shown":            false,
+	"default_launcher_shown":          false,
+	"element_absent":                  true,
+	"element_present":                 true,
+	"non_roboscript_action_performed": false,
+}
+
+type roboScript struct {
+	ContextDescriptor *roboScriptContext `json:"contextDescriptor"`
+	Actions           []roboScriptAction `json:"actions"`
+}
+
+type roboScriptContext struct {
+	Condition          string                    `json:"condition"`
+	PackageName        string                    `json:"packageName"`
+	ElementDescriptors []roboScriptElementTarget `json:"elementDescriptors"`
+}
+
+type roboScriptAction struct {
+	EventType          string                    `json:"eventType"`
+	ElementDescriptors []roboScriptElementTarget `json:"elementDescriptors"`
+	ReplacementText    *string                   `json:"replacementText"`
+	Command            *string                   `json:"command"`
+}
+
+type roboScriptElementTarget struct {
+	ClassName                 string `json:"className"`
+	ResourceID                string `json:"resourceId"`
+	ResourceIDRegex           string `json:"resourceIdRegex"`
+	Text                      string `json:"text"`
+	TextRegex                 string `json:"textRegex"`
+	ContentDescription        string `json:"contentDescription"`
+	ContentDescriptionRegex   string `json:"contentDescriptionRegex"`
+	RecyclerViewChildPosition *int   `json:"recyclerViewChildPosition"`
+	AdapterViewChildPosition  *int   `json:"adapterViewChildPosition"`
+	GroupViewChildPosition    *int   `json:"groupViewChildPosition"`
+	IndexInParent             *int   `json:"indexInParent"`
+}
+
+// identifies reports whether the descriptor has any attribute to find the element by.
+func (e roboScriptElementTarget) identifies() bool {
+	for _, attribute := range []string{e.ClassName, e.ResourceID, e.ResourceIDRegex, e.Text, e.TextRegex, e.ContentDescription, e.ContentDescriptionRegex} {
+		if attribute != "" {
+			return true
+		}
+	}
+	for _, position := range []*int{e.RecyclerViewChildPosition, e.AdapterViewChildPosition, e.GroupViewChildPosition, e.IndexInParent} {
+		if position != nil && *position >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRoboScriptFile checks a Robo script against the Robo script schema before it gets uploaded.
+func validateRoboScriptFile(pth, appPackageID string) error {
+	data, err := os.ReadFile(pth)
+	if err != nil {
+		return fmt.Errorf("failed to read Robo script: %w", err)
+	}
+	return validateRoboScript(data, appPackageID)
+}
+
+// validateRoboScript checks a Robo script, which is either a list of actions (a recorded script) or a list of scripts with
+// context descriptors and actions. The errors point to the invalid event, like `[0].actions[2]`.
+func validateRoboScript(data []byte, appPackageID string) error {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return fmt.Errorf("invalid JSON at offset %d: %s", syntaxErr.Offset, err)
+		}
+		return fmt.Errorf("should be a JSON array of Robo script actions or scripts: %s", err)
+	}
+	if len(items) == 0 {
+		return errors.New("no actions")
+	}
+
+	var probe struct {
+		EventType *string `json:"eventType"`
+	}
+	if err := json.Unmarshal(items[0], &probe); err != nil {
+		return fmt.Errorf("[0]: %s", err)
+	}
+	isActionList := probe.EventType != nil
+
+	for i, item := range items {
+		path := fmt.Sprintf("[%d]", i)
+
+		if isActionList {
+			var action roboScriptAction
+			if err := json.Unmarshal(item, &action); err != nil {
+				return fmt.Errorf("%s: %s", path, err)
+			}
+			if err := validateRoboScriptAction(path, action); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var script roboScript
+		if err := json.Unmarshal(item, &script); err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		if context := script.ContextDescriptor; context != nil {
+			if err := validateRoboScriptContext(path+".contextDescriptor", *context, appPackageID); err != nil {
+				return err
+			}
+		}
+		if len(script.Actions) == 0 {
+			return fmt.Errorf("%s: actions are missing (actions and scripts should not be mixed)", path)
+		}
+		for j, action := range script.Actions {
+			if err := validateRoboScriptAction(fmt.Sprintf("%s.actions[%d]", path, j), action); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateRoboScriptAction(path string, action roboScriptAction) error {
+	if action.EventType == "" {
+		return fmt.Errorf("%s: eventType is missing", path)
+	}
+	requiresElement, ok := roboScriptEventTypes[action.EventType]
+	if !ok {
+		return fmt.Errorf("%s: unknown eventType (%s), should be one of %s", path, action.EventType, strings.Join(sortedKeys(roboScriptEventTypes), ", "))
+	}
+	if err := validateRoboScriptElements(path, action.ElementDescriptors, requiresElement); err != nil {
+		return err
+	}
+
+	switch action.EventType {
+	case "VIEW_TEXT_CHANGED":
+		if action.ReplacementText == nil {
+			return fmt.Errorf("%s: %s requires replacementText", path, action.EventType)
+		}
+	case "ADB_SHELL_COMMAND":
+		if action.Command == nil || strings.TrimSpace(*action.Command) == "" {
+			return fmt.Errorf("%s: %s requires command", path, action.EventType)
+		}
+	}
+
+	return nil
+}
+
+func validateRoboScriptContext(path string, context roboScriptContext, appPackageID string) error {
+	requiresElement, ok := roboScriptConditions[context.Condition]
+	if !ok {
+		return fmt.Errorf("%s: unknown condition (%s), should be one of %s", path, context.Condition, strings.Join(sortedKeys(roboScriptConditions), ", "))
+	}
+	if err := validateRoboScriptElements(path, context.ElementDescriptors, requiresElement); err != nil {
+		return err
+	}
+	if context.PackageName != "" && appPackageID != "" && context.PackageName != appPackageID {
+		return fmt.Errorf("%s: packageName (%s) does not match the app package (%s)", path, context.PackageName, appPackageID)
+	}
+	return nil
+}
+
+func validateRoboScriptElements(path string, elements []roboScriptElementTarget, required bool) error {
+	if required && len(elements) == 0 {
+		return fmt.Errorf("%s: elementDescriptors are missing", path)
+	}
+	for i, element := range elements {
+		if !element.identifies() {
+			return fmt.Errorf("%s.elementDescriptors[%d]: no attribute to identify the element by (resourceId, text, contentDescription, className or a child position)", path, i)
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
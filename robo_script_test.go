@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateRoboScript(t *testing.T) {
+	const recorded = `[
+  {
+    "eventType": "VIEW_TEXT_CHANGED",
+    "replacementText": "user",
+    "elementDescriptors": [{"className": "android.widget.EditText", "resourceId": "com.example.app:id/username", "indexInParent": -1}]
+  },
+  {
+    "eventType": "VIEW_CLICKED",
+    "elementDescriptors": [{"className": "", "recyclerViewChildPosition": 2}]
+  },
+  {"eventType": "PRESSED_BACK"}
+]`
+
+	tests := []struct {
+		name    string
+		script  string
+		wantErr string
+	}{
+		{name: "recorded actions", script: recorded},
+		{
+			name:   "scripts with context descriptor",
+			script: `[{"id": 1000, "crawlStage": "crawl", "contextDescriptor": {"condition": "app_under_test_shown", "packageName": "com.example.app"}, "actions": [{"eventType": "WAIT", "delayTime": 1000}]}]`,
+		},
+		{
+			name:    "invalid JSON",
+			script:  `[{"eventType": "VIEW_CLICKED",}]`,
+			wantErr: "invalid JSON at offset",
+		},
+		{
+			name:    "not an array",
+			script:  `{"eventType": "VIEW_CLICKED"}`,
+			wantErr: "should be a JSON array",
+		},
+		{
+			name:    "empty",
+			script:  `[]`,
+			wantErr: "no actions",
+		},
+		{
+			name:    "unknown event type",
+			script:  `[{"eventType": "PRESSED_BACK"}, {"eventType": "VIEW_TAPPED"}]`,
+			wantErr: "[1]: unknown eventType (VIEW_TAPPED)",
+		},
+		{
+			name:    "missing element descriptors",
+			script:  `[{"eventType": "VIEW_CLICKED"}]`,
+			wantErr: "[0]: elementDescriptors are missing",
+		},
+		{
+			name:    "empty element descriptor",
+			script:  `[{"eventType": "VIEW_CLICKED", "elementDescriptors": [{"className": "", "indexInParent": -1}]}]`,
+			wantErr: "[0].elementDescriptors[0]: no attribute to identify the element by",
+		},
+		{
+			name:    "missing replacement text",
+			script:  `[{"eventType": "VIEW_TEXT_CHANGED", "elementDescriptors": [{"resourceId": "a:id/b"}]}]`,
+			wantErr: "[0]: VIEW_TEXT_CHANGED requires replacementText",
+		},
+		{
+			name:    "unknown condition",
+			script:  `[{"contextDescriptor": {"condition": "app_shown"}, "actions": [{"eventType": "PRESSED_BACK"}]}]`,
+			wantErr: "[0].contextDescriptor: unknown condition (app_shown)",
+		},
+		{
+			name:    "other app package",
+			script:  `[{"contextDescriptor": {"condition": "app_under_test_shown", "packageName": "com.other.app"}, "actions": [{"eventType": "PRESSED_BACK"}]}]`,
+			wantErr: "[0].contextDescriptor: packageName (com.other.app) does not match the app package (com.example.app)",
+		},
+		{
+			name:    "invalid script action",
+			script:  `[{"contextDescriptor": {"condition": "element_present", "elementDescriptors": [{"text": "Login"}]}, "actions": [{"eventType": "PRESSED_BACK"}, {"eventType": "ADB_SHELL_COMMAND"}]}]`,
+			wantErr: "[0].actions[1]: ADB_SHELL_COMMAND requires command",
+		},
+		{
+			name:    "mixed actions and scripts",
+			script:  `[{"contextDescriptor": {"condition": "default_launcher_shown"}, "actions": [{"eventType": "PRESSED_BACK"}]}, {"eventType": "PRESSED_BACK"}]`,
+			wantErr: "[1]: actions are missing",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRoboScript([]byte(tt.script), "com.example.app")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateRoboScript() returned error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateRoboScript() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
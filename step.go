@@ -0,0 +1,574 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	toolresults "google.golang.org/api/toolresults/v1beta3"
+
+	"github.com/bitrise-io/go-steputils/stepconf"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-ios/output"
+)
+
+// clock tells the current time, the time spent waiting for the test results is measured with it
+type clock interface {
+	Now() time.Time
+}
+
+// sleeper waits between the test status requests
+type sleeper interface {
+	Sleep(d time.Duration)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// Step runs the virtual device tests in three phases: ProcessConfig reads and validates the inputs,
+// Run uploads the test assets, runs the tests and collects the results, Export exports the outputs.
+type Step struct {
+	httpClient     *http.Client
+	clock          clock
+	sleeper        sleeper
+	envExporter    output.OutputExporter
+	outputExporter output.Exporter
+}
+
+// NewStep creates the step with its dependencies, the HTTP client is used for all test API calls, uploads and downloads.
+func NewStep(httpClient *http.Client, clock clock, sleeper sleeper, envExporter output.OutputExporter, outputExporter output.Exporter) Step {
+	return Step{
+		httpClient:     httpClient,
+		clock:          clock,
+		sleeper:        sleeper,
+		envExporter:    envExporter,
+		outputExporter: outputExporter,
+	}
+}
+
+// RunResult contains the reports written by Run, empty paths are not exported.
+type RunResult struct {
+	ModuleResultsPath        string
+	PerfMetricsPath          string
+	AccessibilityReportPath  string
+	DownloadDir              string
+	MergedTestResultXMLPaths []string
+	GameLoopResultsPath      string
+	LogcatReportPath         string
+	MediaIndexPath           string
+	TestAssetsArchivePath    string
+}
+
+// ProcessConfig parses the inputs from the environment, validates and prints them.
+func (s Step) ProcessConfig() (ConfigsModel, error) {
+	var configs ConfigsModel
+	if err := stepconf.Parse(&configs); err != nil {
+		return ConfigsModel{}, &configError{err: fmt.Errorf("Invalid input: %s", err)}
+	}
+	logOutput.addSecrets(configs.APIToken)
+
+	if err := configs.validate(); err != nil {
+		return ConfigsModel{}, &configError{err: fmt.Errorf("Failed to parse config:\n%s", err)}
+	}
+	logOutput.addSecrets(string(configs.ServiceAccountKeyJSON))
+
+	fmt.Println()
+	configs.print()
+
+	log.SetEnableDebugLog(configs.VerboseLog)
+
+	return configs, nil
+}
+
+// Run starts the test matrices and waits for their results. The reports written before an error are returned with it,
+// a *testFailureError is returned if a test run failed or a threshold is exceeded.
+func (s Step) Run(configs ConfigsModel) (RunResult, error) {
+	var result RunResult
+
+	fmt.Println()
+
+	if configs.DryRun {
+		log.Infof("Dry run: building the test matrix without uploading the test assets")
+
+		reportDir, err := prepareReportDir(configs.DeployDir)
+		if err != nil {
+			return result, newStepError(failureReasonInternal, "Failed to prepare report dir, error: %s", err)
+		}
+
+		pths, err := writeDryRunTestMatrices(configs, configs.testRuns(), reportDir)
+		if err != nil {
+			return result, newStepError(failureReasonInternal, "%s", err)
+		}
+		for _, pth := range pths {
+			log.Donef("=> Test matrix written to %s", pth)
+		}
+		return result, nil
+	}
+
+	backend, err := newTestBackend(configs, s.httpClient)
+	if err != nil {
+		return result, newStepError(failureReasonConfig, "Failed to create test backend, error: %s", err)
+	}
+
+	log.Infof("Uploading app and test files")
+
+	testAssets, err := backend.UploadTestAssets()
+	if err != nil {
+		return result, newStepError(failureReasonUpload, "Failed to upload test assets, error: %s", err)
+	}
+	log.Donef("=> Files uploaded")
+
+	runs := configs.testRuns()
+
+	fmt.Println()
+	log.Infof("Starting test")
+
+	for _, run := range runs {
+		if err = backend.StartTestRun(run, testAssets); err != nil {
+			return result, newStepError(apiFailureReason(err), "Starting %s test run failed, error: %s", run.displayName(), err)
+		}
+	}
+	if len(runs) > 1 {
+		log.Donef("=> %d test matrices started", len(runs))
+	} else {
+		log.Donef("=> Test started")
+	}
+
+	fmt.Println()
+	log.Infof("Waiting for test results")
+
+	runSteps, err := s.waitForTestResults(backend, runs)
+	if err != nil {
+		return result, err
+	}
+
+	dimensionToStatus, err := printTestResults(runs, runSteps)
+	if err != nil {
+		return result, err
+	}
+
+	if moduleResults := newModuleResults(runs, runSteps, configs.TestApkPaths); len(moduleResults) > 0 {
+		fmt.Println()
+		log.Infof("Module results:")
+		printModuleResults(moduleResults)
+
+		reportDir, err := prepareReportDir(configs.DeployDir)
+		if err != nil {
+			return result, newStepError(failureReasonInternal, "Failed to prepare report dir, error: %s", err)
+		}
+
+		reportPth := filepath.Join(reportDir, moduleResultsFileName)
+		if err := writeModuleResults(moduleResults, reportPth); err != nil {
+			log.Warnf("%s", err)
+		} else {
+			result.ModuleResultsPath = reportPth
+		}
+	}
+
+	var thresholdViolations []string
+	if configs.CollectPerfMetrics {
+		fmt.Println()
+		log.Infof("Collecting performance metrics")
+
+		perfMetricsReport, err := collectPerfMetrics(backend, runs, runSteps)
+		if err != nil && configs.PerfMaxPeakMemory > 0 {
+			return result, newStepError(apiFailureReason(err), "Failed to collect performance metrics, the peak memory limit can't be checked, error: %s", err)
+		} else if err != nil {
+			log.Warnf("Failed to collect performance metrics: %s", err)
+		} else {
+			printPerfMetricsReport(perfMetricsReport)
+			violations, warnings := checkPeakMemory(perfMetricsReport, configs.PerfMaxPeakMemory)
+			for _, warning := range warnings {
+				log.Warnf("Warning: %s", warning)
+			}
+			thresholdViolations = append(thresholdViolations, violations...)
+
+			reportDir, err := prepareReportDir(configs.DeployDir)
+			if err != nil {
+				return result, newStepError(failureReasonInternal, "Failed to prepare report dir, error: %s", err)
+			}
+
+			reportPth := filepath.Join(reportDir, perfMetricsFileName)
+			if err := writePerfMetricsReport(perfMetricsReport, reportPth); err != nil {
+				log.Warnf("%s", err)
+			} else {
+				result.PerfMetricsPath = reportPth
+			}
+		}
+	}
+
+	if configs.CollectAccessibilityFindings && configs.hasTestType(testTypeRobo) {
+		fmt.Println()
+		log.Infof("Collecting accessibility findings")
+
+		accessibilityReport, err := collectAccessibilityFindings(backend, runs, runSteps)
+		if err != nil && configs.AccessibilityErrorLimit >= 0 {
+			return result, newStepError(apiFailureReason(err), "Failed to collect accessibility findings, the accessibility error limit can't be checked, error: %s", err)
+		} else if err != nil {
+			log.Warnf("Failed to collect accessibility findings: %s", err)
+		} else {
+			printAccessibilityReport(accessibilityReport)
+			if limit := configs.AccessibilityErrorLimit; limit >= 0 && accessibilityReport.errorCount() > limit {
+				thresholdViolations = append(thresholdViolations, fmt.Sprintf("%d accessibility error(s) found, the limit is %d", accessibilityReport.errorCount(), limit))
+			}
+
+			reportDir, err := prepareReportDir(configs.DeployDir)
+			if err != nil {
+				return result, newStepError(failureReasonInternal, "Failed to prepare report dir, error: %s", err)
+			}
+
+			reportPth := filepath.Join(reportDir, accessibilityReportFileName(configs.AccessibilityReportFormat))
+			if err := writeAccessibilityReport(accessibilityReport, configs.AccessibilityReportFormat, reportPth); err != nil {
+				log.Warnf("%s", err)
+			} else {
+				result.AccessibilityReportPath = reportPth
+			}
+		}
+	}
+
+	if configs.DownloadTestResults {
+		fmt.Println()
+		log.Infof("Downloading test assets")
+		if err := s.downloadTestResults(configs, backend, runs, &result); err != nil {
+			return result, err
+		}
+	}
+
+	failure := &testFailureError{thresholdViolations: thresholdViolations}
+	infrastructureFailures := infrastructureFailedDimensions(runs, runSteps)
+	for dimension, isSuccess := range dimensionToStatus {
+		if !isSuccess {
+			failure.failedTestRuns = append(failure.failedTestRuns, dimension)
+			if infrastructureFailures[dimension] {
+				failure.infrastructureFailures = append(failure.infrastructureFailures, dimension)
+			}
+		}
+	}
+
+	if len(failure.failedTestRuns) > 0 || len(failure.thresholdViolations) > 0 {
+		return result, failure
+	}
+	return result, nil
+}
+
+// waitForTestResults polls the status of the test runs until all of their steps are complete, it returns the steps per test run name.
+func (s Step) waitForTestResults(backend testBackend, runs []testRun) (map[string][]*toolresults.Step, error) {
+	runSteps := map[string][]*toolresults.Step{}
+	printedLogs := []string{}
+	waitStart := s.clock.Now()
+
+	for {
+		finished := true
+		validating := false
+		testsRunning := 0
+		testsTotal := 0
+
+		for _, run := range runs {
+			responseModel, err := backend.GetTestRunSteps(run)
+			if err != nil {
+				return nil, newStepError(apiFailureReason(err), "Failed to get %s test status, error: %s", run.displayName(), err)
+			}
+
+			if len(responseModel.Steps) == 0 {
+				finished = false
+				validating = true
+			}
+			for _, step := range responseModel.Steps {
+				if step.State != "complete" {
+					finished = false
+					testsRunning++
+				}
+			}
+			testsTotal += len(responseModel.Steps)
+			runSteps[run.name] = responseModel.Steps
+		}
+
+		if validating && s.clock.Now().Sub(waitStart) > validationTimeout {
+			return nil, newStepError(failureReasonInfrastructure, "Test matrix validation did not finish in %s", validationTimeout)
+		}
+
+		msg := ""
+		if validating {
+			msg = "- Validating"
+		} else {
+			msg = fmt.Sprintf("- (%d/%d) running", testsRunning, testsTotal)
+		}
+
+		if !slices.Contains(printedLogs, msg) {
+			log.Printf(msg)
+			printedLogs = append(printedLogs, msg)
+		}
+
+		if finished {
+			log.Donef("=> Test finished")
+			return runSteps, nil
+		}
+		s.sleeper.Sleep(statusPollInterval)
+	}
+}
+
+// printTestResults prints the outcome of the finished steps, it returns whether the devices (dimensions) passed.
+func printTestResults(runs []testRun, runSteps map[string][]*toolresults.Step) (map[string]bool, error) {
+	fmt.Println()
+
+	log.Infof("Test results:")
+	w := tabwriter.NewWriter(logOutput, 0, 0, 3, ' ', 0)
+	if _, err := fmt.Fprintln(w, "Test type\tModel\tAPI Level\tLocale\tOrientation\tOutcome\t"); err != nil {
+		return nil, newStepError(failureReasonInternal, "Failed to write in tabwriter, error: %s", err)
+	}
+
+	dimensionToStatus := map[string]bool{}
+	anyDeviceRunCrashed := false
+
+	for _, run := range runs {
+		for _, step := range runSteps[run.name] {
+			dimensions := map[string]string{}
+			for _, dimension := range step.DimensionValue {
+				dimensions[dimension.Key] = dimension.Value
+			}
+
+			dimensionID := testRunDimensionID(run, step)
+			isSuccess := isStepSuccessful(step)
+
+			_, exists := dimensionToStatus[dimensionID]
+			if exists {
+				if isSuccess {
+					// Mark the dimension as successful if at least one step (test run) was successful.
+					dimensionToStatus[dimensionID] = true
+				}
+			} else {
+				dimensionToStatus[dimensionID] = isSuccess
+			}
+
+			outcome, crashed := processStepResult(step)
+			anyDeviceRunCrashed = anyDeviceRunCrashed || crashed
+
+			if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t\n", run.displayName(), dimensions["Model"], dimensions["Version"], dimensions["Locale"], dimensions["Orientation"], outcome); err != nil {
+				return nil, newStepError(failureReasonInternal, "Failed to write in tabwriter, error: %s", err)
+			}
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		log.Errorf("Failed to flush writer, error: %s", err)
+	}
+
+	if anyDeviceRunCrashed {
+		fmt.Println()
+		log.Warnf("Firebase detected an app crash during one of the runs.")
+		log.Warnf("Note: If the crash occurred outside active test execution (e.g., during cleanup or background processes), individual test results will still appear successful.")
+		fmt.Println()
+	}
+
+	return dimensionToStatus, nil
+}
+
+// testRunDimensionID identifies the device of a step, prefixed with the test run name if the step started multiple test matrices.
+func testRunDimensionID(run testRun, step *toolresults.Step) string {
+	dimensions := map[string]string{}
+	for _, dimension := range step.DimensionValue {
+		dimensions[dimension.Key] = dimension.Value
+	}
+
+	dimensionID := fmt.Sprintf("%s.%s.%s.%s", dimensions["Model"], dimensions["Version"], dimensions["Orientation"], dimensions["Locale"])
+	if run.name != "" {
+		dimensionID = run.name + "." + dimensionID
+	}
+	return dimensionID
+}
+
+// infrastructureFailedDimensions returns the devices where every step (attempt) was inconclusive because of an infrastructure failure.
+func infrastructureFailedDimensions(runs []testRun, runSteps map[string][]*toolresults.Step) map[string]bool {
+	failed := map[string]bool{}
+	for _, run := range runs {
+		for _, step := range runSteps[run.name] {
+			dimensionID := testRunDimensionID(run, step)
+			isInfrastructureFailure := step.Outcome != nil && step.Outcome.Summary == "inconclusive" &&
+				step.Outcome.InconclusiveDetail != nil && step.Outcome.InconclusiveDetail.InfrastructureFailure
+			if previous, exists := failed[dimensionID]; exists {
+				failed[dimensionID] = previous && isInfrastructureFailure
+			} else {
+				failed[dimensionID] = isInfrastructureFailure
+			}
+		}
+	}
+	return failed
+}
+
+// apiFailureReason classifies the error of a test API request.
+func apiFailureReason(err error) string {
+	if errors.Is(err, errInvalidTestMatrix) {
+		return failureReasonInvalidTestMatrix
+	}
+	return failureReasonAPI
+}
+
+// downloadTestResults downloads the test assets and writes the reports based on them.
+func (s Step) downloadTestResults(configs ConfigsModel, backend testBackend, runs []testRun, result *RunResult) error {
+	runAssets := map[string]map[string]string{}
+	assetCount := 0
+	for _, run := range runs {
+		assets, err := backend.GetTestRunAssets(run)
+		if err != nil {
+			return newStepError(failureReasonAPI, "Failed to get %s test assets, error: %s", run.displayName(), err)
+		}
+		runAssets[run.name] = assets
+		assetCount += len(assets)
+	}
+
+	downloadDir, err := prepareDownloadDir(configs.DownloadDir)
+	if err != nil {
+		return newStepError(failureReasonInternal, "Failed to prepare download dir, error: %s", err)
+	}
+
+	manifest, err := downloadTestAssets(backend.DownloadClient(), runAssets, configs.TestDevices, downloadDir, int64(configs.MaxMediaSize)*1024*1024)
+	if err != nil {
+		return newStepError(failureReasonDownload, "Failed to download test assets, error: %s", err)
+	}
+	result.DownloadDir = downloadDir
+
+	for _, entry := range manifest.Files {
+		// per test run results: MediumPhone.arm-33-en-portrait_test_result_1.xml
+		// merged result: MediumPhone.arm-33-en-portrait_test_results_merged.xml
+		if strings.HasSuffix(entry.Name, "test_results_merged.xml") {
+			result.MergedTestResultXMLPaths = append(result.MergedTestResultXMLPaths, filepath.Join(downloadDir, entry.Path))
+		}
+	}
+
+	log.Printf("%d merged test results XML(s) found", len(result.MergedTestResultXMLPaths))
+	log.TDonef("=> %d test Assets downloaded", assetCount)
+	log.Printf("Asset manifest: %s", filepath.Join(downloadDir, assetManifestFileName))
+
+	if configs.hasTestType(testTypeGameLoop) {
+		gameLoopResults, err := collectGameLoopResults(manifest, downloadDir)
+		if err != nil {
+			log.Warnf("Failed to collect game loop results: %s", err)
+		} else if len(gameLoopResults) > 0 {
+			fmt.Println()
+			log.Infof("Game loop scenario results:")
+			printGameLoopResults(gameLoopResults)
+
+			reportPth := filepath.Join(downloadDir, gameLoopResultsFileName)
+			if err := writeGameLoopJUnitReport(gameLoopResults, reportPth); err != nil {
+				log.Warnf("%s", err)
+			} else {
+				result.GameLoopResultsPath = reportPth
+			}
+		} else {
+			log.Printf("No game loop results files found")
+		}
+	}
+
+	fmt.Println()
+	log.Infof("Analyzing logcat")
+	logcatReport, err := analyzeLogcats(manifest, downloadDir, configs.appPackageID())
+	if err != nil {
+		log.Warnf("Failed to analyze logcat: %s", err)
+	} else {
+		printLogcatReport(logcatReport)
+
+		reportPth := filepath.Join(downloadDir, logcatReportFileName)
+		if err := writeLogcatReport(logcatReport, reportPth); err != nil {
+			log.Warnf("%s", err)
+		} else {
+			result.LogcatReportPath = reportPth
+		}
+	}
+
+	mediaIndexPth, mediaCount, err := writeMediaIndex(manifest, downloadDir)
+	if err != nil {
+		log.Warnf("Failed to create media index: %s", err)
+	} else if mediaIndexPth != "" {
+		fmt.Println()
+		log.Printf("%d video(s) and screenshot(s) collected", mediaCount)
+		result.MediaIndexPath = mediaIndexPth
+	}
+
+	if configs.ArchiveTestResults {
+		archivePth, err := archiveTestAssets(downloadDir, configs.DeployDir)
+		if err != nil {
+			log.Warnf("Failed to archive test assets: %s", err)
+		} else {
+			result.TestAssetsArchivePath = archivePth
+		}
+	}
+
+	return nil
+}
+
+// Export exports the reports of the run as step outputs, failing exports are logged as warnings.
+func (s Step) Export(configs ConfigsModel, result RunResult) {
+	if reflect.DeepEqual(result, RunResult{}) {
+		return
+	}
+
+	fmt.Println()
+	log.Infof("Exporting outputs")
+
+	s.exportOutput(moduleResultsEnvID, result.ModuleResultsPath, "module results")
+	s.exportOutput(perfMetricsEnvID, result.PerfMetricsPath, "performance metrics")
+	s.exportOutput(accessibilityReportEnvID, result.AccessibilityReportPath, "accessibility report")
+
+	if result.GameLoopResultsPath != "" {
+		s.exportOutput(gameLoopResultsEnvID, result.GameLoopResultsPath, "game loop results")
+		if configs.TestResultDir != "" {
+			if _, err := copyToTestResultDir(result.GameLoopResultsPath, configs.TestResultDir); err != nil {
+				log.Warnf("Failed to add game loop results to the test reports: %s", err)
+			}
+		}
+	}
+
+	if result.DownloadDir != "" {
+		if err := s.outputExporter.ExportTestResultsDir(result.DownloadDir); err != nil {
+			log.Warnf("Failed to export test assets: %s", err)
+		} else if err := s.outputExporter.ExportFlakyTestsEnvVar(result.MergedTestResultXMLPaths); err != nil {
+			log.Warnf("Failed to export flaky tests env var: %s", err)
+		}
+	}
+
+	s.exportOutput(logcatReportEnvID, result.LogcatReportPath, "logcat report")
+	s.exportOutput(mediaIndexEnvID, result.MediaIndexPath, "media index")
+	s.exportOutput(testAssetsArchiveEnvID, result.TestAssetsArchivePath, "test assets archive")
+}
+
+// ExportFailure exports the reason and the message of the step's failure.
+func (s Step) ExportFailure(err error) {
+	reason := failureReason(err)
+	log.Printf("Failure reason: %s", reason)
+
+	if err := s.envExporter.ExportOutput(failureReasonEnvID, reason); err != nil {
+		log.Warnf("Failed to export failure reason: %s", err)
+	}
+	if err := s.envExporter.ExportOutput(failureMessageEnvID, failureMessage(err)); err != nil {
+		log.Warnf("Failed to export failure message: %s", err)
+	}
+}
+
+func (s Step) exportOutput(envID, pth, name string) {
+	if pth == "" {
+		return
+	}
+	verb := "is"
+	if strings.HasSuffix(name, "s") {
+		// module results, performance metrics
+		verb = "are"
+	}
+	if err := s.envExporter.ExportOutput(envID, pth); err != nil {
+		log.Warnf("Failed to export %s: %s", name, err)
+	} else {
+		log.Donef("The %s (%s) %s exported to the %s environment variable.", name, pth, verb, envID)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	testingapi "google.golang.org/api/testing/v1"
+
+	logv2 "github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-ios/output"
+)
+
+// fakeClock is a clock whose time only passes when the step sleeps
+type fakeClock struct {
+	now    time.Time
+	sleeps int
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.now = c.now.Add(d)
+	c.sleeps++
+}
+
+type fakeOutputExporter struct {
+	outputs map[string]string
+}
+
+func (e *fakeOutputExporter) ExportOutput(key, value string) error {
+	e.outputs[key] = value
+	return nil
+}
+
+func newFakeServerStep(t *testing.T, server *fakeVDTServer) (Step, ConfigsModel, *fakeClock, *fakeOutputExporter) {
+	dir := t.TempDir()
+	appPth := filepath.Join(dir, "app.apk")
+	if err := os.WriteFile(appPth, []byte("apk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	configs := ConfigsModel{
+		APIBaseURL:          server.URL,
+		AppSlug:             fakeAppSlug,
+		BuildSlug:           fakeBuildSlug,
+		APIToken:            fakeAPIToken,
+		AppPath:             appPth,
+		TestTypes:           []string{testTypeRobo},
+		TestDevices:         []*testingapi.AndroidDevice{{AndroidModelId: "MediumPhone.arm", AndroidVersionId: "33", Locale: "en", Orientation: "portrait"}},
+		TestTimeout:         900,
+		DownloadTestResults: true,
+		DownloadDir:         filepath.Join(dir, "download"),
+		DeployDir:           filepath.Join(dir, "deploy"),
+	}
+
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	exporter := &fakeOutputExporter{outputs: map[string]string{}}
+	step := NewStep(newAPIClient(clock), clock, clock, exporter, output.NewExporter(exporter, logv2.NewLogger()))
+	return step, configs, clock, exporter
+}
+
+func TestStep_Run(t *testing.T) {
+	server := newFakeVDTServer(fakeScenarioSuccess)
+	defer server.Close()
+
+	step, configs, clock, exporter := newFakeServerStep(t, server)
+	result, err := step.Run(configs)
+	if err != nil {
+		t.Fatalf("Run() returned error: %v", err)
+	}
+	if got, want := clock.sleeps, 4; got != want {
+		t.Errorf("status polls waited %d times, want %d", got, want)
+	}
+	if result.DownloadDir != configs.DownloadDir || len(result.MergedTestResultXMLPaths) != 1 || result.LogcatReportPath == "" {
+		t.Errorf("Run() = %+v, want the download dir, a merged test result and a logcat report", result)
+	}
+
+	step.Export(configs, result)
+	want := map[string]string{
+		"VDTESTING_DOWNLOADED_FILES_DIR": configs.DownloadDir,
+		logcatReportEnvID:                result.LogcatReportPath,
+	}
+	if !reflect.DeepEqual(exporter.outputs, want) {
+		t.Errorf("exported outputs = %v, want %v", exporter.outputs, want)
+	}
+}
+
+func TestStep_Run_Errors(t *testing.T) {
+	tests := []struct {
+		name      string
+		scenario  fakeVDTScenario
+		configure func(configs *ConfigsModel)
+		check     func(t *testing.T, err error)
+	}{
+		{
+			name:     "test failure",
+			scenario: fakeScenarioTestFailure,
+			check: func(t *testing.T, err error) {
+				var failure *testFailureError
+				if !errors.As(err, &failure) || len(failure.failedTestRuns) != 1 {
+					t.Errorf("Run() error = %v, want a failed test run", err)
+				}
+				if got := failureReason(err); got != failureReasonTestFailure {
+					t.Errorf("failureReason() = %s, want %s", got, failureReasonTestFailure)
+				}
+			},
+		},
+		{
+			name:     "infrastructure error",
+			scenario: fakeScenarioInfrastructureError,
+			check: func(t *testing.T, err error) {
+				var failure *testFailureError
+				if !errors.As(err, &failure) || len(failure.infrastructureFailures) != 1 {
+					t.Errorf("Run() error = %v, want an infrastructure failure", err)
+				}
+				if got := failureReason(err); got != failureReasonInfrastructure {
+					t.Errorf("failureReason() = %s, want %s", got, failureReasonInfrastructure)
+				}
+			},
+		},
+		{
+			name:     "performance metrics can't be collected with a peak memory limit",
+			scenario: fakeScenarioSuccess,
+			configure: func(configs *ConfigsModel) {
+				configs.CollectPerfMetrics = true
+				configs.PerfMaxPeakMemory = 512
+			},
+			check: func(t *testing.T, err error) {
+				if err == nil || !strings.Contains(err.Error(), "Failed to collect performance metrics") {
+					t.Errorf("Run() error = %v, want performance metrics collection error", err)
+				}
+				if got := failureReason(err); got != failureReasonAPI {
+					t.Errorf("failureReason() = %s, want %s", got, failureReasonAPI)
+				}
+			},
+		},
+		{
+			name:     "accessibility findings can't be collected with an error limit",
+			scenario: fakeScenarioSuccess,
+			configure: func(configs *ConfigsModel) {
+				configs.CollectAccessibilityFindings = true
+				configs.AccessibilityErrorLimit = 0
+			},
+			check: func(t *testing.T, err error) {
+				if err == nil || !strings.Contains(err.Error(), "Failed to collect accessibility findings") {
+					t.Errorf("Run() error = %v, want accessibility findings collection error", err)
+				}
+				if got := failureReason(err); got != failureReasonAPI {
+					t.Errorf("failureReason() = %s, want %s", got, failureReasonAPI)
+				}
+			},
+		},
+		{
+			name:     "stuck validation",
+			scenario: fakeScenarioStuckValidation,
+			check: func(t *testing.T, err error) {
+				if err == nil || !strings.Contains(err.Error(), "Test matrix validation did not finish in 30m0s") {
+					t.Errorf("Run() error = %v, want validation timeout", err)
+				}
+				if got := failureReason(err); got != failureReasonInfrastructure {
+					t.Errorf("failureReason() = %s, want %s", got, failureReasonInfrastructure)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeVDTServer(tt.scenario)
+			defer server.Close()
+
+			step, configs, _, _ := newFakeServerStep(t, server)
+			if tt.configure != nil {
+				tt.configure(&configs)
+			}
+			_, err := step.Run(configs)
+			tt.check(t, err)
+		})
+	}
+}
+
+func TestStep_Run_OptionalReports(t *testing.T) {
+	server := newFakeVDTServer(fakeScenarioSuccess)
+	defer server.Close()
+
+	// without a limit the reports are optional, a collection failure is only a warning
+	step, configs, _, _ := newFakeServerStep(t, server)
+	configs.CollectPerfMetrics = true
+	configs.CollectAccessibilityFindings = true
+	configs.AccessibilityErrorLimit = -1
+	result, err := step.Run(configs)
+	if err != nil {
+		t.Fatalf("Run() returned error: %v", err)
+	}
+	if result.PerfMetricsPath != "" || result.AccessibilityReportPath != "" {
+		t.Errorf("Run() = %+v, want no performance metrics and accessibility reports", result)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	exporter := &fakeOutputExporter{outputs: map[string]string{}}
+	step := NewStep(nil, &fakeClock{}, &fakeClock{}, exporter, output.NewExporter(exporter, logv2.NewLogger()))
+
+	if got := exitCode(step, nil); got != 0 {
+		t.Errorf("exitCode(nil) = %d, want 0", got)
+	}
+	if len(exporter.outputs) != 0 {
+		t.Errorf("exported outputs = %v, want none on success", exporter.outputs)
+	}
+
+	if got := exitCode(step, &configError{err: errors.New("- AppPath: invalid")}); got != 1 {
+		t.Errorf("exitCode(configError) = %d, want 1", got)
+	}
+	want := map[string]string{failureReasonEnvID: failureReasonConfig, failureMessageEnvID: "- AppPath: invalid"}
+	if !reflect.DeepEqual(exporter.outputs, want) {
+		t.Errorf("exported outputs = %v, want %v", exporter.outputs, want)
+	}
+
+	failure := &testFailureError{failedTestRuns: []string{"a"}, thresholdViolations: []string{"peak memory exceeded"}}
+	if got, want := failure.Error(), "peak memory exceeded\n1 test run(s) failed"; got != want {
+		t.Errorf("testFailureError.Error() = %q, want %q", got, want)
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
@@ -12,6 +11,7 @@ import (
 	"strings"
 
 	testing "google.golang.org/api/testing/v1"
+	toolresults "google.golang.org/api/toolresults/v1beta3"
 
 	"github.com/bitrise-io/go-utils/log"
 )
@@ -30,22 +30,35 @@ type TestAssetsAndroid struct {
 	Apk        TestAsset   `json:"apk,omitempty"`
 	Aab        TestAsset   `json:"aab,omitempty"`
 	TestApk    TestAsset   `json:"testApk,omitempty"`
+	TestApks   []TestAsset `json:"testApks,omitempty"`
 	RoboScript TestAsset   `json:"roboScript,omitempty"`
 	ObbFiles   []TestAsset `json:"obbFiles,omitempty"`
 }
 
-func uploadTestAssets(configs ConfigsModel) (TestAssetsAndroid, error) {
-	var testAssets TestAssetsAndroid
+// PerfMetrics describes the performance metrics of a test step as returned by the test API
+type PerfMetrics struct {
+	Summary      *toolresults.PerfMetricsSummary `json:"summary"`
+	SampleSeries []PerfSampleSeries              `json:"sampleSeries"`
+}
 
-	url := configs.APIBaseURL + "/assets/android/" + configs.AppSlug + "/" + configs.BuildSlug + "/" + configs.APIToken
+// PerfSampleSeries is a performance sample series of a test step with its samples
+type PerfSampleSeries struct {
+	Series  *toolresults.PerfSampleSeries `json:"series"`
+	Samples []*toolresults.PerfSample     `json:"samples"`
+}
 
-	if strings.ToLower(filepath.Ext(configs.AppPath)) == ".aab" {
-		testAssets.isBundle = true
+// testApk returns the uploaded test APK of the given instrumentation test run.
+func (a TestAssetsAndroid) testApk(run testRun) TestAsset {
+	if len(a.TestApks) > 0 {
+		return a.TestApks[run.testApkIndex]
 	}
-	log.Debugf("App path (%s), is bundle: %t", configs.AppPath, testAssets.isBundle)
+	return a.TestApk
+}
 
+// requestedTestAssets returns the test assets to request upload URLs for.
+func requestedTestAssets(configs ConfigsModel) TestAssetsAndroid {
 	var requestedAssets TestAssetsAndroid
-	if testAssets.isBundle {
+	if isAppBundle(configs.AppPath) {
 		requestedAssets.Aab = TestAsset{
 			Filename: filepath.Base(configs.AppPath),
 		}
@@ -54,12 +67,20 @@ func uploadTestAssets(configs ConfigsModel) (TestAssetsAndroid, error) {
 			Filename: filepath.Base(configs.AppPath),
 		}
 	}
-	if configs.TestType == testTypeInstrumentation {
-		requestedAssets.TestApk = TestAsset{
-			Filename: filepath.Base(configs.TestApkPath),
+	if configs.hasTestType(testTypeInstrumentation) {
+		if len(configs.TestApkPaths) > 1 {
+			for _, testApkPath := range configs.TestApkPaths {
+				requestedAssets.TestApks = append(requestedAssets.TestApks, TestAsset{
+					Filename: filepath.Base(testApkPath),
+				})
+			}
+		} else {
+			requestedAssets.TestApk = TestAsset{
+				Filename: filepath.Base(configs.TestApkPaths[0]),
+			}
 		}
 	}
-	if configs.TestType == testTypeRobo && configs.RoboScenarioFile != "" {
+	if configs.hasTestType(testTypeRobo) && configs.RoboScenarioFile != "" {
 		requestedAssets.RoboScript = TestAsset{
 			Filename: filepath.Base(configs.RoboScenarioFile),
 		}
@@ -70,6 +91,23 @@ func uploadTestAssets(configs ConfigsModel) (TestAssetsAndroid, error) {
 		})
 	}
 
+	return requestedAssets
+}
+
+func isAppBundle(appPath string) bool {
+	return strings.ToLower(filepath.Ext(appPath)) == ".aab"
+}
+
+func uploadTestAssets(client *http.Client, configs ConfigsModel) (TestAssetsAndroid, error) {
+	var testAssets TestAssetsAndroid
+
+	url := apiURL(configs, "assets/android")
+
+	testAssets.isBundle = isAppBundle(configs.AppPath)
+	log.Debugf("App path (%s), is bundle: %t", configs.AppPath, testAssets.isBundle)
+
+	requestedAssets := requestedTestAssets(configs)
+
 	log.Debugf("Assets requested: %+v", requestedAssets)
 
 	data, err := json.Marshal(requestedAssets)
@@ -77,16 +115,18 @@ func uploadTestAssets(configs ConfigsModel) (TestAssetsAndroid, error) {
 		return TestAssetsAndroid{}, fmt.Errorf("failed to encode to json: %+v", requestedAssets)
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	req, err := newAPIRequest(configs, "POST", url, bytes.NewReader(data))
 	if err != nil {
 		return TestAssetsAndroid{}, fmt.Errorf("failed to create http request, error: %s", err)
 	}
 
-	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
 		return TestAssetsAndroid{}, fmt.Errorf("failed to get http response, error: %s", err)
 	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -109,19 +149,28 @@ func uploadTestAssets(configs ConfigsModel) (TestAssetsAndroid, error) {
 	}
 	log.Debugf("Uploading file(%s) to (%s)", configs.AppPath, testAssets.testApp.GcsPath)
 
-	err = uploadFile(testAssets.testApp.UploadURL, configs.AppPath)
+	err = uploadFile(client, testAssets.testApp.UploadURL, configs.AppPath)
 	if err != nil {
 		return TestAssetsAndroid{}, fmt.Errorf("failed to upload file(%s) to (%s), error: %s", configs.AppPath, testAssets.testApp.UploadURL, err)
 	}
 
-	if configs.TestType == testTypeInstrumentation {
-		if err := uploadFile(testAssets.TestApk.UploadURL, configs.TestApkPath); err != nil {
-			return TestAssetsAndroid{}, fmt.Errorf("failed to upload file(%s) to (%s), error: %s", configs.TestApkPath, testAssets.TestApk.UploadURL, err)
+	if configs.hasTestType(testTypeInstrumentation) {
+		if len(configs.TestApkPaths) > 1 {
+			if len(testAssets.TestApks) != len(configs.TestApkPaths) {
+				return TestAssetsAndroid{}, fmt.Errorf("invalid length of test APK upload URLs in response: %+v", testAssets)
+			}
+			for i, testApkPath := range configs.TestApkPaths {
+				if err := uploadFile(client, testAssets.TestApks[i].UploadURL, testApkPath); err != nil {
+					return TestAssetsAndroid{}, fmt.Errorf("failed to upload file(%s) to (%s), error: %s", testApkPath, testAssets.TestApks[i].UploadURL, err)
+				}
+			}
+		} else if err := uploadFile(client, testAssets.TestApk.UploadURL, configs.TestApkPaths[0]); err != nil {
+			return TestAssetsAndroid{}, fmt.Errorf("failed to upload file(%s) to (%s), error: %s", configs.TestApkPaths[0], testAssets.TestApk.UploadURL, err)
 		}
 	}
 
-	if configs.TestType == testTypeRobo && configs.RoboScenarioFile != "" {
-		if err := uploadFile(testAssets.RoboScript.UploadURL, configs.RoboScenarioFile); err != nil {
+	if configs.hasTestType(testTypeRobo) && configs.RoboScenarioFile != "" {
+		if err := uploadFile(client, testAssets.RoboScript.UploadURL, configs.RoboScenarioFile); err != nil {
 			return TestAssetsAndroid{}, fmt.Errorf("failed to upload file(%s) to (%s), error: %s", configs.RoboScenarioFile, testAssets.RoboScript.UploadURL, err)
 		}
 	}
@@ -130,7 +179,7 @@ func uploadTestAssets(configs ConfigsModel) (TestAssetsAndroid, error) {
 		return TestAssetsAndroid{}, fmt.Errorf("invalid length of obb file upload URLs in response: %+v", testAssets)
 	}
 	for i, obbFile := range configs.ObbFiles {
-		if err := uploadFile(testAssets.ObbFiles[i].UploadURL, obbFile); err != nil {
+		if err := uploadFile(client, testAssets.ObbFiles[i].UploadURL, obbFile); err != nil {
 			return TestAssetsAndroid{}, fmt.Errorf("failed to upload obb file (%s) to (%s), error: %s", obbFile, testAssets.ObbFiles[i].UploadURL, err)
 		}
 	}
@@ -138,9 +187,45 @@ func uploadTestAssets(configs ConfigsModel) (TestAssetsAndroid, error) {
 	return testAssets, nil
 }
 
-func startTestRun(configs ConfigsModel, testAssets TestAssetsAndroid) error {
-	url := configs.APIBaseURL + "/" + configs.AppSlug + "/" + configs.BuildSlug + "/" + configs.APIToken
+func startTestRun(client *http.Client, configs ConfigsModel, run testRun, testAssets TestAssetsAndroid) error {
+	url := testRunURL(configs, "", run)
+
+	testModel, err := newTestMatrix(configs, run, testAssets)
+	if err != nil {
+		return err
+	}
+
+	jsonByte, err := json.Marshal(testModel)
+	if err != nil {
+		return fmt.Errorf("failed to marshal test model, error: %s", err)
+	}
+
+	req, err := newAPIRequest(configs, "POST", url, bytes.NewBuffer(jsonByte))
+	if err != nil {
+		return fmt.Errorf("failed to create http request, error: %s", err)
+	}
 
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get http response, error: %s", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read response body, error: %s", err)
+		}
+		return fmt.Errorf("failed to start test: %d, error: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// newTestMatrix builds the test matrix of the given test run, referencing the uploaded test assets.
+func newTestMatrix(configs ConfigsModel, run testRun, testAssets TestAssetsAndroid) (*testing.TestMatrix, error) {
 	testModel := &testing.TestMatrix{}
 	testModel.EnvironmentMatrix = &testing.EnvironmentMatrix{AndroidDeviceList: &testing.AndroidDeviceList{}}
 
@@ -178,7 +263,7 @@ func startTestRun(configs ConfigsModel, testAssets TestAssetsAndroid) error {
 		},
 	}
 
-	switch configs.TestType {
+	switch run.testType {
 	case testTypeInstrumentation:
 		testModel.TestSpecification.AndroidInstrumentationTest = &testing.AndroidInstrumentationTest{}
 
@@ -190,7 +275,7 @@ func startTestRun(configs ConfigsModel, testAssets TestAssetsAndroid) error {
 			testModel.TestSpecification.AndroidInstrumentationTest.AppApk = &testing.FileReference{GcsPath: testAssets.testApp.GcsPath}
 		}
 
-		testModel.TestSpecification.AndroidInstrumentationTest.TestApk = &testing.FileReference{GcsPath: testAssets.TestApk.GcsPath}
+		testModel.TestSpecification.AndroidInstrumentationTest.TestApk = &testing.FileReference{GcsPath: testAssets.testApk(run).GcsPath}
 		if configs.AppPackageID != "" {
 			testModel.TestSpecification.AndroidInstrumentationTest.AppPackageId = configs.AppPackageID
 		}
@@ -200,9 +285,7 @@ func startTestRun(configs ConfigsModel, testAssets TestAssetsAndroid) error {
 		if configs.InstTestRunnerClass != "" {
 			testModel.TestSpecification.AndroidInstrumentationTest.TestRunnerClass = configs.InstTestRunnerClass
 		}
-		targets := strings.Split(strings.TrimSpace(configs.InstTestTargets), ",")
-		targets = append(targets, configs.QuarantinedTestTargets...)
-		testModel.TestSpecification.AndroidInstrumentationTest.TestTargets = targets
+		testModel.TestSpecification.AndroidInstrumentationTest.TestTargets = configs.instrumentationTestTargets()
 		if configs.UseOrchestrator {
 			testModel.TestSpecification.AndroidInstrumentationTest.OrchestratorOption = "USE_ORCHESTRATOR"
 		} else {
@@ -229,34 +312,19 @@ func startTestRun(configs ConfigsModel, testAssets TestAssetsAndroid) error {
 		if configs.RoboMaxDepth != "" {
 			maxDepth, err := strconv.Atoi(configs.RoboMaxDepth)
 			if err != nil {
-				return fmt.Errorf("failed to parse string(%s) to integer, error: %s", configs.RoboMaxDepth, err)
+				return nil, fmt.Errorf("failed to parse string(%s) to integer, error: %s", configs.RoboMaxDepth, err)
 			}
 			testModel.TestSpecification.AndroidRoboTest.MaxDepth = int64(maxDepth)
 		}
 		if configs.RoboMaxSteps != "" {
 			maxSteps, err := strconv.Atoi(configs.RoboMaxSteps)
 			if err != nil {
-				return fmt.Errorf("failed to parse string(%s) to integer, error: %s", configs.RoboMaxSteps, err)
+				return nil, fmt.Errorf("failed to parse string(%s) to integer, error: %s", configs.RoboMaxSteps, err)
 			}
 			testModel.TestSpecification.AndroidRoboTest.MaxSteps = int64(maxSteps)
 		}
-		if configs.RoboDirectives != "" {
-			roboDirectives := []*testing.RoboDirective{}
-			scanner := bufio.NewScanner(strings.NewReader(configs.RoboDirectives))
-			for scanner.Scan() {
-				directive := scanner.Text()
-				directive = strings.TrimSpace(directive)
-				if directive == "" {
-					continue
-				}
-
-				directiveParams := strings.Split(directive, ",")
-				if len(directiveParams) != 3 {
-					return fmt.Errorf("invalid directive configuration: %s", directive)
-				}
-				roboDirectives = append(roboDirectives, &testing.RoboDirective{ResourceName: directiveParams[0], InputText: directiveParams[1], ActionType: directiveParams[2]})
-			}
-			testModel.TestSpecification.AndroidRoboTest.RoboDirectives = roboDirectives
+		if len(configs.RoboDirectiveList) > 0 {
+			testModel.TestSpecification.AndroidRoboTest.RoboDirectives = configs.RoboDirectiveList
 		}
 		if configs.RoboScenarioFile != "" {
 			log.Debugf("Robo scenario file: %s", testAssets.RoboScript.GcsPath)
@@ -264,7 +332,7 @@ func startTestRun(configs ConfigsModel, testAssets TestAssetsAndroid) error {
 				GcsPath: testAssets.RoboScript.GcsPath,
 			}
 		}
-	case "gameloop":
+	case testTypeGameLoop:
 		testModel.TestSpecification.AndroidTestLoop = &testing.AndroidTestLoop{}
 
 		if testAssets.isBundle {
@@ -278,46 +346,79 @@ func startTestRun(configs ConfigsModel, testAssets TestAssetsAndroid) error {
 		if configs.AppPackageID != "" {
 			testModel.TestSpecification.AndroidTestLoop.AppPackageId = configs.AppPackageID
 		}
-		if configs.LoopScenarios != "" {
-			loopScenarios := []int64{}
-			for _, scenarioStr := range strings.Split(strings.TrimSpace(configs.LoopScenarios), ",") {
-				scenario, err := strconv.Atoi(scenarioStr)
-				if err != nil {
-					return fmt.Errorf("failed to parse string(%s) to integer, error: %s", scenarioStr, err)
-				}
-				loopScenarios = append(loopScenarios, int64(scenario))
-			}
-			testModel.TestSpecification.AndroidTestLoop.Scenarios = loopScenarios
+		if len(configs.LoopScenarioNumbers) > 0 {
+			testModel.TestSpecification.AndroidTestLoop.Scenarios = configs.LoopScenarioNumbers
 		}
-		if configs.LoopScenarioLabels != "" {
-			scenarioLabels := strings.Split(strings.TrimSpace(configs.LoopScenarioLabels), ",")
-			testModel.TestSpecification.AndroidTestLoop.ScenarioLabels = scenarioLabels
+		if len(configs.LoopScenarioLabelList) > 0 {
+			testModel.TestSpecification.AndroidTestLoop.ScenarioLabels = configs.LoopScenarioLabelList
 		}
 	}
 
-	jsonByte, err := json.Marshal(testModel)
+	return testModel, nil
+}
+
+func getPerfMetrics(client *http.Client, configs ConfigsModel, run testRun, stepID string) (PerfMetrics, error) {
+	url := testRunURL(configs, "perfmetrics", run, stepID)
+
+	req, err := newAPIRequest(configs, "GET", url, nil)
 	if err != nil {
-		return fmt.Errorf("failed to marshal test model, error: %s", err)
+		return PerfMetrics{}, fmt.Errorf("failed to create http request, error: %s", err)
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonByte))
+	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to create http request, error: %s", err)
+		return PerfMetrics{}, fmt.Errorf("failed to get http response, error: %s", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return PerfMetrics{}, fmt.Errorf("failed to read response body (status code: %d), error: %s", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return PerfMetrics{}, fmt.Errorf("failed to get performance metrics: %d, error: %s", resp.StatusCode, string(body))
+	}
+
+	var perfMetrics PerfMetrics
+	if err := json.Unmarshal(body, &perfMetrics); err != nil {
+		return PerfMetrics{}, fmt.Errorf("failed to unmarshal response body, error: %s", err)
+	}
+
+	return perfMetrics, nil
+}
+
+func getAccessibilityClusters(client *http.Client, configs ConfigsModel, run testRun, stepID string) (*toolresults.ListStepAccessibilityClustersResponse, error) {
+	url := testRunURL(configs, "accessibility", run, stepID)
+
+	req, err := newAPIRequest(configs, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create http request, error: %s", err)
 	}
 
-	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to get http response, error: %s", err)
+		return nil, fmt.Errorf("failed to get http response, error: %s", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body (status code: %d), error: %s", resp.StatusCode, err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return fmt.Errorf("failed to read response body, error: %s", err)
-		}
-		return fmt.Errorf("failed to start test: %d, error: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("failed to get accessibility clusters: %d, error: %s", resp.StatusCode, string(body))
 	}
 
-	return nil
+	clusters := &toolresults.ListStepAccessibilityClustersResponse{}
+	if err := json.Unmarshal(body, clusters); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response body, error: %s", err)
+	}
+
+	return clusters, nil
 }
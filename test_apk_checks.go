@@ -0,0 +1,193 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Runners supporting the Android Test Orchestrator
+var orchestratorRunners = []string{
+	"androidx.test.runner.AndroidJUnitRunner",
+	"android.support.test.runner.AndroidJUnitRunner",
+}
+
+var dexFileRegexp = regexp.MustCompile(`^classes\d*\.dex$`)
+
+var errInvalidDex = errors.New("invalid dex file")
+
+// readDexClasses returns the classes defined in the dex files of an APK, mapped to their superclass.
+func readDexClasses(apkPath string) (map[string]string, error) {
+	reader, err := zip.OpenReader(apkPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", apkPath, err)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	classes := map[string]string{}
+	for _, file := range reader.File {
+		if !dexFileRegexp.MatchString(file.Name) {
+			continue
+		}
+
+		data, err := readZipFile(&reader.Reader, file.Name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", apkPath, err)
+		}
+		if err := parseDexClasses(data, classes); err != nil {
+			return nil, fmt.Errorf("failed to parse %s of %s: %w", file.Name, apkPath, err)
+		}
+	}
+
+	return classes, nil
+}
+
+// parseDexClasses adds the class definitions of a dex file to classes (class name -> superclass name),
+// see https://source.android.com/docs/core/runtime/dex-format
+func parseDexClasses(data []byte, classes map[string]string) error {
+	if len(data) < 0x70 || !strings.HasPrefix(string(data), "dex\n") {
+		return errInvalidDex
+	}
+
+	u32 := binary.LittleEndian.Uint32
+	stringIDsSize, stringIDsOffset := int(u32(data[0x38:])), int(u32(data[0x3C:]))
+	typeIDsSize, typeIDsOffset := int(u32(data[0x40:])), int(u32(data[0x44:]))
+	classDefsSize, classDefsOffset := int(u32(data[0x60:])), int(u32(data[0x64:]))
+	if stringIDsOffset+4*stringIDsSize > len(data) || typeIDsOffset+4*typeIDsSize > len(data) || classDefsOffset+32*classDefsSize > len(data) {
+		return fmt.Errorf("%w: sections out of bounds", errInvalidDex)
+	}
+
+	typeName := func(typeIndex uint32) (string, error) {
+		const noIndex = 0xFFFFFFFF
+		if typeIndex == noIndex {
+			return "", nil
+		}
+		if int(typeIndex) >= typeIDsSize {
+			return "", fmt.Errorf("%w: type index %d", errInvalidDex, typeIndex)
+		}
+		stringIndex := int(u32(data[typeIDsOffset+4*int(typeIndex):]))
+		if stringIndex >= stringIDsSize {
+			return "", fmt.Errorf("%w: string index %d", errInvalidDex, stringIndex)
+		}
+		offset := int(u32(data[stringIDsOffset+4*stringIndex:]))
+		if offset >= len(data) {
+			return "", fmt.Errorf("%w: string data offset %d", errInvalidDex, offset)
+		}
+
+		// string_data_item: uleb128 UTF-16 size followed by the null terminated MUTF-8 data
+		for offset < len(data) && data[offset]&0x80 != 0 {
+			offset++
+		}
+		offset++
+		end := offset
+		for end < len(data) && data[end] != 0 {
+			end++
+		}
+		if offset > end {
+			return "", fmt.Errorf("%w: string data", errInvalidDex)
+		}
+
+		return descriptorToClassName(string(data[offset:end])), nil
+	}
+
+	for i := 0; i < classDefsSize; i++ {
+		classDef := data[classDefsOffset+32*i:]
+		className, err := typeName(u32(classDef[0:]))
+		if err != nil {
+			return err
+		}
+		superclassName, err := typeName(u32(classDef[8:]))
+		if err != nil {
+			return err
+		}
+		classes[className] = superclassName
+	}
+
+	return nil
+}
+
+// descriptorToClassName converts a type descriptor (Landroidx/test/runner/AndroidJUnitRunner;) to a class name.
+func descriptorToClassName(descriptor string) string {
+	if !strings.HasPrefix(descriptor, "L") || !strings.HasSuffix(descriptor, ";") {
+		return descriptor
+	}
+	return strings.ReplaceAll(descriptor[1:len(descriptor)-1], "/", ".")
+}
+
+// extendsClass reports whether the class is the given base class or one of its subclasses, based on the classes of the APK.
+func extendsClass(classes map[string]string, className, baseClassName string) bool {
+	for seen := map[string]bool{}; className != "" && !seen[className]; {
+		if className == baseClassName {
+			return true
+		}
+		seen[className] = true
+		className = classes[className]
+	}
+	return false
+}
+
+// checkTestApk checks the test APK against the app and the instrumentation inputs. Problems resulting in a test failure
+// are returned as error, the suspicious ones (and test APKs which could not be inspected) as warnings.
+// The test runner class is also looked up in the app APK: the test APK does not contain the dependencies which are
+// already on the app's runtime classpath.
+func checkTestApk(appManifest *AndroidManifest, appPath, testApkPath, runnerClass string, useOrchestrator bool) ([]string, error) {
+	testManifest, err := readAndroidManifest(testApkPath)
+	if err != nil {
+		return []string{fmt.Sprintf("failed to inspect the test APK manifest: %s", err)}, nil
+	}
+	if len(testManifest.Instrumentations) == 0 {
+		return nil, fmt.Errorf("%s: no <instrumentation> declared in the manifest, is it a test APK?", testApkPath)
+	}
+
+	instrumentation := testManifest.Instrumentations[0]
+	for _, candidate := range testManifest.Instrumentations {
+		if candidate.Name == runnerClass {
+			instrumentation = candidate
+		}
+	}
+
+	// library modules' test APKs instrument themselves
+	if appManifest != nil && instrumentation.TargetPackage != testManifest.Package && instrumentation.TargetPackage != appManifest.Package {
+		return nil, fmt.Errorf("%s: the instrumentation targets %s, but the app package is %s", testApkPath, instrumentation.TargetPackage, appManifest.Package)
+	}
+
+	if runnerClass == "" {
+		runnerClass = instrumentation.Name
+	}
+	classes, err := readDexClasses(testApkPath)
+	if err != nil {
+		return []string{fmt.Sprintf("failed to inspect the test APK classes: %s", err)}, nil
+	}
+	if _, ok := classes[runnerClass]; !ok && appPath != "" {
+		// app bundles keep their dex files under the module directories, those are not inspected
+		if appClasses, err := readDexClasses(appPath); err == nil {
+			for class, superclass := range appClasses {
+				if _, ok := classes[class]; !ok {
+					classes[class] = superclass
+				}
+			}
+		}
+	}
+
+	var warnings []string
+	if _, ok := classes[runnerClass]; !ok {
+		return []string{fmt.Sprintf("%s: the test runner class %s is not found in the test APK nor in the app, the tests may fail to start", testApkPath, runnerClass)}, nil
+	}
+
+	if useOrchestrator {
+		supported := false
+		for _, runner := range orchestratorRunners {
+			supported = supported || extendsClass(classes, runnerClass, runner)
+		}
+		if !supported {
+			warnings = append(warnings, fmt.Sprintf("%s: the Orchestrator is enabled, but the test runner (%s) does not extend AndroidJUnitRunner, the tests may not be run with the Orchestrator", testApkPath, runnerClass))
+		}
+	}
+
+	return warnings, nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testXMLElement struct {
+	name       string
+	attributes [][3]string // namespace, name, value
+	children   []testXMLElement
+}
+
+// newBinaryXML builds a binary XML document with an UTF-8 string pool and string attribute values.
+func newBinaryXML(root testXMLElement) []byte {
+	var pool []string
+	index := func(s string) uint32 {
+		for i, poolString := range pool {
+			if poolString == s {
+				return uint32(i)
+			}
+		}
+		pool = append(pool, s)
+		return uint32(len(pool) - 1)
+	}
+	nsIndex := func(s string) uint32 {
+		if s == "" {
+			return resNoEntry
+		}
+		return index(s)
+	}
+
+	le := binary.LittleEndian
+	var elements []byte
+	var appendElement func(e testXMLElement)
+	appendElement = func(e testXMLElement) {
+		start := make([]byte, 36+20*len(e.attributes))
+		le.PutUint16(start[0:], resXMLStartElementType)
+		le.PutUint16(start[2:], 16)
+		le.PutUint32(start[4:], uint32(len(start)))
+		le.PutUint32(start[12:], resNoEntry)
+		le.PutUint32(start[16:], resNoEntry)
+		le.PutUint32(start[20:], index(e.name))
+		le.PutUint16(start[24:], 20)
+		le.PutUint16(start[26:], 20)
+		le.PutUint16(start[28:], uint16(len(e.attributes)))
+		for i, attribute := range e.attributes {
+			a := start[36+20*i:]
+			le.PutUint32(a[0:], nsIndex(attribute[0]))
+			le.PutUint32(a[4:], index(attribute[1]))
+			le.PutUint32(a[8:], index(attribute[2]))
+			le.PutUint16(a[12:], 8)
+			a[15] = resValueTypeString
+			le.PutUint32(a[16:], index(attribute[2]))
+		}
+		elements = append(elements, start...)
+
+		for _, child := range e.children {
+			appendElement(child)
+		}
+
+		end := make([]byte, 24)
+		le.PutUint16(end[0:], resXMLEndElementType)
+		le.PutUint16(end[2:], 16)
+		le.PutUint32(end[4:], 24)
+		le.PutUint32(end[12:], resNoEntry)
+		le.PutUint32(end[16:], resNoEntry)
+		le.PutUint32(end[20:], index(e.name))
+		elements = append(elements, end...)
+	}
+	appendElement(root)
+
+	var stringData []byte
+	offsets := make([]byte, 4*len(pool))
+	for i, s := range pool {
+		le.PutUint32(offsets[4*i:], uint32(len(stringData)))
+		stringData = append(stringData, byte(len(s)), byte(len(s)))
+		stringData = append(stringData, s...)
+		stringData = append(stringData, 0)
+	}
+	for len(stringData)%4 != 0 {
+		stringData = append(stringData, 0)
+	}
+	stringPool := make([]byte, 28)
+	le.PutUint16(stringPool[0:], resStringPoolType)
+	le.PutUint16(stringPool[2:], 28)
+	le.PutUint32(stringPool[4:], uint32(28+len(offsets)+len(stringData)))
+	le.PutUint32(stringPool[8:], uint32(len(pool)))
+	le.PutUint32(stringPool[16:], resStringPoolUTF8Flag)
+	le.PutUint32(stringPool[20:], uint32(28+len(offsets)))
+	stringPool = append(append(stringPool, offsets...), stringData...)
+
+	document := make([]byte, 8)
+	le.PutUint16(document[0:], resXMLType)
+	le.PutUint16(document[2:], 8)
+	le.PutUint32(document[4:], uint32(8+len(stringPool)+len(elements)))
+	return append(append(document, stringPool...), elements...)
+}
+
+// newDex builds a dex file defining the given classes (class name -> superclass name).
+func newDex(classes map[string]string) []byte {
+	var descriptors []string
+	typeIndex := func(className string) uint32 {
+		descriptor := "L" + strings.ReplaceAll(className, ".", "/") + ";"
+		for i, d := range descriptors {
+			if d == descriptor {
+				return uint32(i)
+			}
+		}
+		descriptors = append(descriptors, descriptor)
+		return uint32(len(descriptors) - 1)
+	}
+
+	le := binary.LittleEndian
+	var classDefs []byte
+	for className, superclassName := range classes {
+		classDef := make([]byte, 32)
+		le.PutUint32(classDef[0:], typeIndex(className))
+		le.PutUint32(classDef[8:], typeIndex(superclassName))
+		classDefs = append(classDefs, classDef...)
+	}
+
+	stringIDsOffset := 0x70
+	typeIDsOffset := stringIDsOffset + 4*len(descriptors)
+	classDefsOffset := typeIDsOffset + 4*len(descriptors)
+	stringDataOffset := classDefsOffset + len(classDefs)
+
+	data := make([]byte, stringDataOffset)
+	copy(data, "dex\n035\x00")
+	le.PutUint32(data[0x38:], uint32(len(descriptors)))
+	le.PutUint32(data[0x3C:], uint32(stringIDsOffset))
+	le.PutUint32(data[0x40:], uint32(len(descriptors)))
+	le.PutUint32(data[0x44:], uint32(typeIDsOffset))
+	le.PutUint32(data[0x60:], uint32(len(classes)))
+	le.PutUint32(data[0x64:], uint32(classDefsOffset))
+	copy(data[classDefsOffset:], classDefs)
+	for i, descriptor := range descriptors {
+		le.PutUint32(data[stringIDsOffset+4*i:], uint32(len(data)))
+		le.PutUint32(data[typeIDsOffset+4*i:], uint32(i))
+		data = append(data, byte(len(descriptor)))
+		data = append(data, descriptor...)
+		data = append(data, 0)
+	}
+
+	return data
+}
+
+func writeTestApk(t *testing.T, packageID, runnerClass, targetPackage string, classes map[string]string) string {
+	manifest := testXMLElement{
+		name:       "manifest",
+		attributes: [][3]string{{"", "package", packageID}},
+		children: []testXMLElement{{
+			name: "instrumentation",
+			attributes: [][3]string{
+				{androidNamespace, "name", runnerClass},
+				{androidNamespace, "targetPackage", targetPackage},
+			},
+		}},
+	}
+
+	pth := filepath.Join(t.TempDir(), "app-debug-androidTest.apk")
+	file, err := os.Create(pth)
+	if err != nil {
+		t.Fatalf("failed to create test APK: %v", err)
+	}
+	writer := zip.NewWriter(file)
+	for name, data := range map[string][]byte{
+		"AndroidManifest.xml": newBinaryXML(manifest),
+		"classes.dex":         newDex(classes),
+	} {
+		entry, err := writer.Create(name)
+		if err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+		if _, err := entry.Write(data); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close test APK: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("failed to close test APK: %v", err)
+	}
+
+	return pth
+}
+
+func TestParseDexClasses(t *testing.T) {
+	want := map[string]string{
+		"com.example.CustomRunner":                "androidx.test.runner.AndroidJUnitRunner",
+		"com.example.ExampleInstrumentedTest":     "java.lang.Object",
+		"androidx.test.runner.AndroidJUnitRunner": "androidx.test.runner.MonitoringInstrumentation",
+	}
+
+	got := map[string]string{}
+	if err := parseDexClasses(newDex(want), got); err != nil {
+		t.Fatalf("parseDexClasses() returned error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDexClasses() = %v, want %v", got, want)
+	}
+
+	if err := parseDexClasses([]byte("not a dex"), got); err == nil {
+		t.Errorf("parseDexClasses() expected error for invalid data")
+	}
+}
+
+func TestExtendsClass(t *testing.T) {
+	classes := map[string]string{
+		"com.example.CustomRunner":                "com.example.BaseRunner",
+		"com.example.BaseRunner":                  "androidx.test.runner.AndroidJUnitRunner",
+		"com.example.LegacyRunner":                "android.test.InstrumentationTestRunner",
+		"com.example.Cyclic":                      "com.example.Cyclic",
+		"androidx.test.runner.AndroidJUnitRunner": "androidx.test.runner.MonitoringInstrumentation",
+	}
+
+	tests := []struct {
+		className string
+		want      bool
+	}{
+		{"com.example.CustomRunner", true},
+		{"androidx.test.runner.AndroidJUnitRunner", true},
+		{"com.example.LegacyRunner", false},
+		{"com.example.Cyclic", false},
+		{"com.example.Unknown", false},
+	}
+	for _, tt := range tests {
+		if got := extendsClass(classes, tt.className, "androidx.test.runner.AndroidJUnitRunner"); got != tt.want {
+			t.Errorf("extendsClass(%s) = %t, want %t", tt.className, got, tt.want)
+		}
+	}
+}
+
+func TestCheckTestApk(t *testing.T) {
+	const (
+		appPackage = "com.example.myapplication"
+		runner     = "androidx.test.runner.AndroidJUnitRunner"
+		legacy     = "com.example.LegacyRunner"
+		appRunner  = "com.example.AppRunner"
+	)
+	classes := map[string]string{
+		runner: "androidx.test.runner.MonitoringInstrumentation",
+		legacy: "android.test.InstrumentationTestRunner",
+	}
+	appManifest := &AndroidManifest{Package: appPackage}
+
+	tests := []struct {
+		name            string
+		testApkPackage  string
+		targetPackage   string
+		runnerClass     string
+		useOrchestrator bool
+		appManifest     *AndroidManifest
+		appClasses      map[string]string
+		wantWarnings    int
+		wantErr         string
+	}{
+		{name: "valid", testApkPackage: appPackage + ".test", targetPackage: appPackage, useOrchestrator: true, appManifest: appManifest},
+		{name: "explicit runner class", testApkPackage: appPackage + ".test", targetPackage: appPackage, runnerClass: runner, appManifest: appManifest},
+		{name: "library test APK", testApkPackage: "com.example.library.test", targetPackage: "com.example.library.test", appManifest: appManifest},
+		{name: "unknown app manifest", testApkPackage: "com.other.test", targetPackage: "com.other", appManifest: nil},
+		{name: "target package mismatch", testApkPackage: "com.other.test", targetPackage: "com.other", appManifest: appManifest, wantErr: "the instrumentation targets com.other, but the app package is " + appPackage},
+		{name: "missing runner class", testApkPackage: appPackage + ".test", targetPackage: appPackage, runnerClass: "com.example.MissingRunner", appManifest: appManifest, appClasses: map[string]string{}, wantWarnings: 1},
+		{name: "runner class in the app", testApkPackage: appPackage + ".test", targetPackage: appPackage, runnerClass: appRunner, useOrchestrator: true, appManifest: appManifest, appClasses: map[string]string{appRunner: runner}},
+		{name: "orchestrator with legacy runner", testApkPackage: appPackage + ".test", targetPackage: appPackage, runnerClass: legacy, useOrchestrator: true, appManifest: appManifest, wantWarnings: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pth := writeTestApk(t, tt.testApkPackage, runner, tt.targetPackage, classes)
+			appPth := ""
+			if tt.appClasses != nil {
+				appPth = writeTestApk(t, appPackage, "", "", tt.appClasses)
+			}
+
+			warnings, err := checkTestApk(tt.appManifest, appPth, pth, tt.runnerClass, tt.useOrchestrator)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("checkTestApk() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("checkTestApk() returned error: %v", err)
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("checkTestApk() warnings = %v, want %d", warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestCheckTestApk_NotATestApk(t *testing.T) {
+	pth := filepath.Join(t.TempDir(), "app.apk")
+	if err := os.WriteFile(pth, emptyAndroidApp, 0644); err != nil {
+		t.Fatalf("failed to write app: %v", err)
+	}
+
+	if _, err := checkTestApk(nil, "", pth, "", false); err == nil || !strings.Contains(err.Error(), "no <instrumentation>") {
+		t.Errorf("checkTestApk() error = %v, want missing instrumentation", err)
+	}
+}
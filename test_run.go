@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	toolresults "google.golang.org/api/toolresults/v1beta3"
+)
+
+// testRun is a test matrix started by the step. Every test type gets its own matrix, sharing the uploaded test assets.
+type testRun struct {
+	// name identifies the test matrix in the API URLs and the download directory,
+	// it is empty if the step starts a single matrix, to keep the single matrix API and directory layout.
+	name     string
+	testType string
+	// module is set for instrumentation test runs if multiple test APKs are given, testApkIndex is the index of the run's test APK.
+	module       string
+	testApkIndex int
+}
+
+func (r testRun) displayName() string {
+	if r.module != "" {
+		return r.testType + " (" + r.module + ")"
+	}
+	return r.testType
+}
+
+// testRuns returns the test matrices to start, one per test type and one per test APK for instrumentation tests.
+func (configs ConfigsModel) testRuns() []testRun {
+	var runs []testRun
+	for _, testType := range configs.TestTypes {
+		if testType == testTypeInstrumentation && len(configs.TestApkPaths) > 1 {
+			for i, module := range testModuleNames(configs.TestApkPaths, configs.TestTypes) {
+				runs = append(runs, testRun{name: module, testType: testType, module: module, testApkIndex: i})
+			}
+			continue
+		}
+		runs = append(runs, testRun{name: testType, testType: testType})
+	}
+
+	if len(runs) == 1 {
+		runs[0].name = ""
+	}
+	return runs
+}
+
+// testModuleNames derives the module names from the test APK file names (<module>-<variant>-androidTest.apk),
+// the names are unique and can be used in URLs and directory names.
+func testModuleNames(testApkPaths []string, reserved []string) []string {
+	used := map[string]bool{}
+	for _, name := range reserved {
+		used[name] = true
+	}
+
+	var names []string
+	for _, pth := range testApkPaths {
+		name := strings.TrimSuffix(filepath.Base(pth), filepath.Ext(pth))
+		name = strings.TrimSuffix(name, "-androidTest")
+		name = strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+				return r
+			}
+			return '_'
+		}, name)
+		if name == "" {
+			name = "module"
+		}
+
+		unique := name
+		for i := 2; used[unique]; i++ {
+			unique = fmt.Sprintf("%s_%d", name, i)
+		}
+		used[unique] = true
+		names = append(names, unique)
+	}
+	return names
+}
+
+func (configs ConfigsModel) hasTestType(testType string) bool {
+	for _, t := range configs.TestTypes {
+		if t == testType {
+			return true
+		}
+	}
+	return false
+}
+
+// testRunURL returns the URL of the test API endpoint for the given test run, endpoint is empty for the test matrix itself.
+// The test run is identified by the run query parameter, so it can't be mistaken for the API token or the path elements.
+func testRunURL(configs ConfigsModel, endpoint string, run testRun, elems ...string) string {
+	runURL := apiURL(configs, endpoint, elems...)
+	if run.name != "" {
+		runURL += "?" + url.Values{"run": {run.name}}.Encode()
+	}
+	return runURL
+}
+
+// apiURL returns the URL of the test API endpoint, followed by the non-empty path elements.
+// The API token is part of the path, unless it is sent in the Authorization header.
+func apiURL(configs ConfigsModel, endpoint string, elems ...string) string {
+	url := configs.APIBaseURL
+	if endpoint != "" {
+		url += "/" + endpoint
+	}
+	url += "/" + configs.AppSlug + "/" + configs.BuildSlug
+	if !configs.APITokenInHeader {
+		url += "/" + configs.APIToken
+	}
+	for _, elem := range elems {
+		if elem != "" {
+			url += "/" + elem
+		}
+	}
+	return url
+}
+
+// newAPIRequest creates a test API request, authenticated with the Authorization header if the token is not part of the URL.
+func newAPIRequest(configs ConfigsModel, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if configs.APITokenInHeader {
+		req.Header.Set("Authorization", "Bearer "+configs.APIToken)
+	}
+	return req, nil
+}
+
+// parseTestTypes parses the comma, pipe or newline separated test types.
+func parseTestTypes(testTypeList string) ([]string, error) {
+	var testTypes []string
+	for _, testType := range strings.FieldsFunc(testTypeList, func(r rune) bool {
+		return r == ',' || r == '|' || r == '\n'
+	}) {
+		testType = strings.TrimSpace(testType)
+		if testType == "" {
+			continue
+		}
+
+		switch testType {
+		case testTypeInstrumentation, testTypeRobo, testTypeGameLoop:
+		default:
+			return nil, fmt.Errorf("should be one of instrumentation, robo, gameloop, got: %s", testType)
+		}
+
+		duplicate := false
+		for _, t := range testTypes {
+			duplicate = duplicate || t == testType
+		}
+		if duplicate {
+			return nil, fmt.Errorf("test type is listed multiple times: %s", testType)
+		}
+
+		testTypes = append(testTypes, testType)
+	}
+
+	if len(testTypes) == 0 {
+		return nil, fmt.Errorf("required variable is not present")
+	}
+
+	return testTypes, nil
+}
+
+func getTestRunSteps(client *http.Client, configs ConfigsModel, run testRun) (*toolresults.ListStepsResponse, error) {
+	req, err := newAPIRequest(configs, "GET", testRunURL(configs, "", run), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create http request, error: %s", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get http response, error: %s", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body, error: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get test status, error: %s", string(body))
+	}
+
+	responseModel := &toolresults.ListStepsResponse{}
+	if err := json.Unmarshal(body, responseModel); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response body, error: %s, body: %s", err, string(body))
+	}
+
+	return responseModel, nil
+}
+
+func getTestRunAssets(client *http.Client, configs ConfigsModel, run testRun) (map[string]string, error) {
+	req, err := newAPIRequest(configs, "GET", testRunURL(configs, "assets", run), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create http request, error: %s", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get http response, error: %s", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body, error: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get http response, status code: %d, error: %s", resp.StatusCode, string(body))
+	}
+
+	assets := map[string]string{}
+	if err := json.Unmarshal(body, &assets); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response body, error: %s", err)
+	}
+
+	return assets, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseTestTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "single", input: "robo", want: []string{testTypeRobo}},
+		{name: "comma separated", input: "instrumentation, robo", want: []string{testTypeInstrumentation, testTypeRobo}},
+		{name: "pipe and newline separated", input: "instrumentation|gameloop\nrobo\n", want: []string{testTypeInstrumentation, testTypeGameLoop, testTypeRobo}},
+		{name: "unknown", input: "robo,xctest", wantErr: true},
+		{name: "duplicate", input: "robo,robo", wantErr: true},
+		{name: "empty", input: " , ", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTestTypes(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTestTypes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTestTypes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTestRunURL(t *testing.T) {
+	configs := ConfigsModel{APIBaseURL: "https://vdt.example.com", AppSlug: "app", BuildSlug: "build", APIToken: "token"}
+
+	configs.TestTypes = []string{testTypeRobo}
+	runs := configs.testRuns()
+	if got, want := testRunURL(configs, "", runs[0]), "https://vdt.example.com/app/build/token"; got != want {
+		t.Errorf("single test run URL = %s, want %s", got, want)
+	}
+
+	configs.TestTypes = []string{testTypeInstrumentation, testTypeRobo}
+	runs = configs.testRuns()
+	if len(runs) != 2 {
+		t.Fatalf("len(testRuns()) = %d, want 2", len(runs))
+	}
+	if got, want := testRunURL(configs, "assets", runs[1]), "https://vdt.example.com/assets/app/build/token?run=robo"; got != want {
+		t.Errorf("robo test run assets URL = %s, want %s", got, want)
+	}
+
+	configs.APITokenInHeader = true
+	if got, want := testRunURL(configs, "assets", runs[1]), "https://vdt.example.com/assets/app/build?run=robo"; got != want {
+		t.Errorf("robo test run assets URL with the token in header = %s, want %s", got, want)
+	}
+	req, err := newAPIRequest(configs, "GET", testRunURL(configs, "", runs[1]), nil)
+	if err != nil {
+		t.Fatalf("newAPIRequest() error = %v", err)
+	}
+	if got, want := req.Header.Get("Authorization"), "Bearer token"; got != want {
+		t.Errorf("Authorization header = %s, want %s", got, want)
+	}
+}
+
+func TestVDTBackend_StepReportURLs(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.RequestURI())
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	configs := ConfigsModel{APIBaseURL: server.URL, AppSlug: "app", BuildSlug: "build", APIToken: "token", TestTypes: []string{testTypeInstrumentation, testTypeRobo}}
+	backend := vdtBackend{configs: configs, client: server.Client()}
+	runs := configs.testRuns()
+
+	if _, err := backend.GetPerfMetrics(runs[0], "step-a"); err != nil {
+		t.Fatalf("GetPerfMetrics() error = %v", err)
+	}
+	if _, err := backend.GetAccessibilityClusters(runs[1], "step-b"); err != nil {
+		t.Fatalf("GetAccessibilityClusters() error = %v", err)
+	}
+
+	want := []string{"/perfmetrics/app/build/token/step-a?run=instrumentation", "/accessibility/app/build/token/step-b?run=robo"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("requested paths = %v, want %v", paths, want)
+	}
+}
+
+func TestTestRuns_MultipleTestApks(t *testing.T) {
+	configs := ConfigsModel{
+		TestTypes: []string{testTypeInstrumentation, testTypeRobo},
+		TestApkPaths: parseTestApkPathList("feature/login/build/login-debug-androidTest.apk|feature/cart/build/cart-debug-androidTest.apk\n" +
+			"other/login/build/login-debug-androidTest.apk"),
+	}
+
+	want := []testRun{
+		{name: "login-debug", testType: testTypeInstrumentation, module: "login-debug", testApkIndex: 0},
+		{name: "cart-debug", testType: testTypeInstrumentation, module: "cart-debug", testApkIndex: 1},
+		{name: "login-debug_2", testType: testTypeInstrumentation, module: "login-debug_2", testApkIndex: 2},
+		{name: testTypeRobo, testType: testTypeRobo},
+	}
+	if got := configs.testRuns(); !reflect.DeepEqual(got, want) {
+		t.Errorf("testRuns() = %+v, want %+v", got, want)
+	}
+}
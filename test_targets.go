@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Instrumentation test target filters, see the AndroidJUnitRunner arguments:
+// https://developer.android.com/reference/androidx/test/runner/AndroidJUnitRunner
+const (
+	testTargetClass         = "class"
+	testTargetNotClass      = "notClass"
+	testTargetPackage       = "package"
+	testTargetNotPackage    = "notPackage"
+	testTargetAnnotation    = "annotation"
+	testTargetNotAnnotation = "notAnnotation"
+	testTargetSize          = "size"
+)
+
+var (
+	javaQualifiedNameRegexp = regexp.MustCompile(`^[\p{L}_$][\p{L}\p{N}_$]*(\.[\p{L}_$][\p{L}\p{N}_$]*)*$`)
+	javaIdentifierRegexp    = regexp.MustCompile(`^[\p{L}_$][\p{L}\p{N}_$]*$`)
+)
+
+var testTargetSizes = []string{"small", "medium", "large"}
+
+// parseTestTargets parses the newline or comma separated instrumentation test targets.
+// An entry without a filter keyword continues the previous entry's filter (`class a.B,a.C` is `class a.B` and `class a.C`),
+// empty lines and lines starting with `#` are skipped.
+func parseTestTargets(testTargetList string) ([]string, error) {
+	var targets []string
+	var previousFilter string
+
+	scanner := bufio.NewScanner(strings.NewReader(testTargetList))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		for _, entry := range strings.Split(line, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+
+			if len(strings.Fields(entry)) == 1 && !isTestTargetFilter(entry) {
+				if previousFilter == "" {
+					return nil, fmt.Errorf("line %d: invalid test target (%s): missing filter, should start with one of %s", lineNumber, entry, strings.Join(testTargetFilters(), ", "))
+				}
+				entry = previousFilter + " " + entry
+			}
+
+			target, err := parseTestTarget(entry)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNumber, err)
+			}
+			targets = append(targets, target)
+			previousFilter = strings.Fields(target)[0]
+		}
+	}
+
+	return targets, nil
+}
+
+func testTargetFilters() []string {
+	return []string{testTargetClass, testTargetNotClass, testTargetPackage, testTargetNotPackage, testTargetAnnotation, testTargetNotAnnotation, testTargetSize}
+}
+
+func isTestTargetFilter(s string) bool {
+	for _, filter := range testTargetFilters() {
+		if s == filter {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTestTarget checks the syntax of a single test target and returns it in its normalized (single space separated) form.
+func parseTestTarget(target string) (string, error) {
+	fields := strings.Fields(target)
+	if len(fields) != 2 {
+		return "", fmt.Errorf("invalid test target (%s): should be a filter and a value separated by a space, like `class com.example.MyTest`", target)
+	}
+	filter, value := fields[0], fields[1]
+
+	switch filter {
+	case testTargetClass, testTargetNotClass:
+		className, method, hasMethod := strings.Cut(value, "#")
+		if !javaQualifiedNameRegexp.MatchString(className) {
+			return "", fmt.Errorf("invalid test target (%s): %s is not a fully qualified class name", target, className)
+		}
+		if hasMethod && !javaIdentifierRegexp.MatchString(method) {
+			return "", fmt.Errorf("invalid test target (%s): %s is not a valid method name", target, method)
+		}
+	case testTargetPackage, testTargetNotPackage:
+		if !javaQualifiedNameRegexp.MatchString(value) {
+			return "", fmt.Errorf("invalid test target (%s): %s is not a valid package name", target, value)
+		}
+	case testTargetAnnotation, testTargetNotAnnotation:
+		if !javaQualifiedNameRegexp.MatchString(value) || !strings.Contains(value, ".") {
+			return "", fmt.Errorf("invalid test target (%s): %s is not a fully qualified annotation name", target, value)
+		}
+	case testTargetSize:
+		valid := false
+		for _, size := range testTargetSizes {
+			valid = valid || value == size
+		}
+		if !valid {
+			return "", fmt.Errorf("invalid test target (%s): size should be one of %s", target, strings.Join(testTargetSizes, ", "))
+		}
+	default:
+		return "", fmt.Errorf("invalid test target (%s): unknown filter %s, should be one of %s", target, filter, strings.Join(testTargetFilters(), ", "))
+	}
+
+	return filter + " " + value, nil
+}
+
+// instrumentationTestTargets returns the test targets sent to Firebase: the configured targets and the quarantined test exclusions.
+// No targets means all tests are run.
+func (configs ConfigsModel) instrumentationTestTargets() []string {
+	var targets []string
+	targets = append(targets, configs.TestTargets...)
+	targets = append(targets, configs.QuarantinedTestTargets...)
+	return targets
+}
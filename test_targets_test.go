@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTestTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:  "empty input runs all tests",
+			input: "  \n",
+			want:  nil,
+		},
+		{
+			name:  "legacy comma separated list",
+			input: "class com.example.LoginTest,class com.example.CartTest#checkout",
+			want:  []string{"class com.example.LoginTest", "class com.example.CartTest#checkout"},
+		},
+		{
+			name: "newline separated list with comments and filters",
+			input: `# smoke tests only
+package com.example.smoke
+notAnnotation androidx.test.filters.FlakyTest
+annotation   com.example.Smoke
+size medium
+notClass com.example.smoke.SlowTest#scroll
+notPackage com.example.smoke.legacy`,
+			want: []string{
+				"package com.example.smoke",
+				"notAnnotation androidx.test.filters.FlakyTest",
+				"annotation com.example.Smoke",
+				"size medium",
+				"notClass com.example.smoke.SlowTest#scroll",
+				"notPackage com.example.smoke.legacy",
+			},
+		},
+		{
+			name:  "entries without filter continue the previous filter",
+			input: "class com.example.LoginTest,com.example.Outer$Inner",
+			want:  []string{"class com.example.LoginTest", "class com.example.Outer$Inner"},
+		},
+		{
+			name:    "missing filter",
+			input:   "com.example.LoginTest",
+			wantErr: "line 1: invalid test target (com.example.LoginTest): missing filter",
+		},
+		{
+			name:    "unknown filter",
+			input:   "size small\ntest com.example.LoginTest",
+			wantErr: "line 2: invalid test target (test com.example.LoginTest): unknown filter test",
+		},
+		{
+			name:    "invalid size",
+			input:   "size huge",
+			wantErr: "line 1: invalid test target (size huge): size should be one of small, medium, large",
+		},
+		{
+			name:    "invalid method",
+			input:   "class com.example.LoginTest#log-in",
+			wantErr: "line 1: invalid test target (class com.example.LoginTest#log-in): log-in is not a valid method name",
+		},
+		{
+			name:    "annotation is not fully qualified",
+			input:   "annotation Smoke",
+			wantErr: "line 1: invalid test target (annotation Smoke): Smoke is not a fully qualified annotation name",
+		},
+		{
+			name:    "missing value",
+			input:   "package",
+			wantErr: "line 1: invalid test target (package): should be a filter and a value",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTestTargets(tt.input)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("parseTestTargets() error = %v, want prefix %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTestTargets() returned error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTestTargets() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}